@@ -0,0 +1,11 @@
+package main
+
+import (
+	"knative.dev/pkg/injection/sharedmain"
+
+	"github.com/vaikas/ftp/pkg/reconciler/ftpsource"
+)
+
+func main() {
+	sharedmain.Main("ftp-source-controller", ftpsource.NewController)
+}
@@ -26,18 +26,51 @@ import (
 )
 
 type FTPFileEvent struct {
-	Name    string
-	Size    int64
-	ModTime time.Time
+	Name        string
+	Path        string
+	Size        int64
+	ModTime     time.Time
+	FullPath    string
+	Directory   string
+	Mode        string
+	UID         *uint32
+	GID         *uint32
+	MIMEType    string
+	Server      string
+	Protocol    string
+	Content     []byte
+	ContentType string
+	Object      *FTPObject
+
+	ChecksumAlgorithm string
+	Checksum          string
+	ChecksumVerified  bool
+	ExpectedChecksum  string
+}
+
+type FTPObject struct {
+	URL       string
+	Key       string
+	ETag      string
+	Size      int64
+	VersionID string
 }
 
 func receive(ctx context.Context, event cloudevents.Event) error {
 	fmt.Printf("Got Event Context: %+v\n", event.Context)
+	if event.DataContentType() != cloudevents.ApplicationJSON {
+		fmt.Printf("Got Data: %d bytes of %s\n", len(event.Data()), event.DataContentType())
+		fmt.Printf("----------------------------\n")
+		return nil
+	}
 	var data FTPFileEvent
 	if err := event.DataAs(&data); err != nil {
 		fmt.Printf("Got Data Error: %s\n", err.Error())
 	}
 	fmt.Printf("Got Data: %+v\n", data)
+	if data.Object != nil {
+		fmt.Printf("Got Object: %+v\n", *data.Object)
+	}
 
 	fmt.Printf("----------------------------\n")
 	return nil
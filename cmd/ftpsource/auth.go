@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	authPublicKey           = "publickey"
+	authPassword            = "password"
+	authKeyboardInteractive = "keyboard-interactive"
+)
+
+// defaultAuthMethods is the order we try the authentication methods in when
+// none are given explicitly.
+var defaultAuthMethods = []string{authPublicKey, authPassword, authKeyboardInteractive}
+
+// sshCredentials are the credentials we can log into an SFTP server with.
+type sshCredentials struct {
+	password string
+	// PEM or OpenSSH encoded private key, optionally protected by
+	// passphrase.
+	privateKey string
+	passphrase string
+	// OpenSSH certificate for privateKey, in authorized_keys format.
+	certificate string
+}
+
+// sshAuthMethods returns the authentication methods to log in with, in the
+// order they're tried by the ssh client. Methods we have no credentials for
+// are left out, unless they were asked for explicitly.
+func sshAuthMethods(creds sshCredentials, methods []string) ([]ssh.AuthMethod, error) {
+	explicit := len(methods) > 0
+	if !explicit {
+		methods = defaultAuthMethods
+	}
+
+	var auth []ssh.AuthMethod
+	for _, m := range methods {
+		switch m {
+		case authPublicKey:
+			if creds.privateKey == "" {
+				if explicit {
+					return nil, errors.New("publickey authentication needs a private key")
+				}
+				continue
+			}
+			signers, err := sshSigners(creds)
+			if err != nil {
+				return nil, err
+			}
+			auth = append(auth, ssh.PublicKeys(signers...))
+		case authPassword:
+			if creds.password == "" && !explicit {
+				continue
+			}
+			auth = append(auth, ssh.Password(creds.password))
+		case authKeyboardInteractive:
+			if creds.password == "" && !explicit {
+				continue
+			}
+			auth = append(auth, ssh.KeyboardInteractive(passwordChallenge(creds.password)))
+		default:
+			return nil, fmt.Errorf("unknown authentication method %q", m)
+		}
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("no credentials to authenticate with")
+	}
+	return auth, nil
+}
+
+// sshSigners parses the private key, and if there's a certificate for it,
+// returns a signer presenting the certificate ahead of the plain key.
+func sshSigners(creds sshCredentials) ([]ssh.Signer, error) {
+	var signer ssh.Signer
+	var err error
+	if creds.passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(creds.privateKey), []byte(creds.passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey([]byte(creds.privateKey))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	if creds.certificate == "" {
+		return []ssh.Signer{signer}, nil
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(creds.certificate))
+	if err != nil {
+		return nil, fmt.Errorf("parsing certificate: %w", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("not a certificate: %s", pub.Type())
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("using certificate: %w", err)
+	}
+	return []ssh.Signer{certSigner, signer}, nil
+}
+
+// passwordChallenge answers every keyboard-interactive question with the
+// password, which is what servers that only ask for the password expect.
+func passwordChallenge(password string) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range questions {
+			answers[i] = password
+		}
+		return answers, nil
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newPrivateKey returns a PEM encoded private key, encrypted with the
+// passphrase if there is one, and its signer.
+func newPrivateKey(t *testing.T, passphrase string) (string, ssh.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() = %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() = %v", err)
+	}
+	block := &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	if passphrase != "" {
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, der, []byte(passphrase), x509.PEMCipherAES256)
+		if err != nil {
+			t.Fatalf("EncryptPEMBlock() = %v", err)
+		}
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey() = %v", err)
+	}
+	return string(pem.EncodeToMemory(block)), signer
+}
+
+// newCertificate returns the user certificate for the key signed by the CA,
+// in authorized_keys format.
+func newCertificate(t *testing.T, key ssh.PublicKey, ca ssh.Signer) string {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"partner"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatalf("SignCert() = %v", err)
+	}
+	return string(ssh.MarshalAuthorizedKey(cert))
+}
+
+// login logs into an SSH server with the config as the user with the auth
+// methods, returning the error of the server if it refused.
+func login(t *testing.T, config *ssh.ServerConfig, user string, auth []ssh.AuthMethod) error {
+	t.Helper()
+	_, hostKey := newPrivateKey(t, "")
+	config.AddHostKey(hostKey)
+
+	// Both ends write their version first, so they can't share a net.Pipe.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() = %v", err)
+	}
+	defer l.Close()
+	done := make(chan error, 1)
+	go func() {
+		server, err := l.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer server.Close()
+		conn, _, _, err := ssh.NewServerConn(server, config)
+		if err == nil {
+			conn.Close()
+		}
+		done <- err
+	}()
+	conn, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		conn.Close()
+	}
+	return <-done
+}
+
+func TestSSHAuthMethods(t *testing.T) {
+	key, signer := newPrivateKey(t, "")
+	encrypted, encryptedSigner := newPrivateKey(t, "s3cret")
+	_, ca := newPrivateKey(t, "")
+	certificate := newCertificate(t, signer.PublicKey(), ca)
+
+	acceptKey := func(want ssh.PublicKey) func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+		return func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), want.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		}
+	}
+	acceptPassword := func(_ ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		if string(password) != "hunter2" {
+			return nil, errors.New("wrong password")
+		}
+		return nil, nil
+	}
+	acceptAnswers := func(_ ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		answers, err := client("partner", "", []string{"Password: ", "Verification code: "}, []bool{false, false})
+		if err != nil {
+			return nil, err
+		}
+		for _, answer := range answers {
+			if answer != "hunter2" {
+				return nil, errors.New("wrong answer")
+			}
+		}
+		return nil, nil
+	}
+	certChecker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), ca.PublicKey().Marshal())
+		},
+	}
+
+	tests := []struct {
+		name    string
+		env     EnvConfig
+		methods []string
+		server  ssh.ServerConfig
+		wantErr bool
+	}{{
+		name:   "private key from the env",
+		env:    EnvConfig{User: "partner", PrivateKey: key},
+		server: ssh.ServerConfig{PublicKeyCallback: acceptKey(signer.PublicKey())},
+	}, {
+		name:   "private key with a passphrase",
+		env:    EnvConfig{User: "partner", PrivateKey: encrypted, PrivateKeyPassphrase: "s3cret"},
+		server: ssh.ServerConfig{PublicKeyCallback: acceptKey(encryptedSigner.PublicKey())},
+	}, {
+		name:   "certificate",
+		env:    EnvConfig{User: "partner", PrivateKey: key, Certificate: certificate},
+		server: ssh.ServerConfig{PublicKeyCallback: certChecker.Authenticate},
+	}, {
+		name:   "password",
+		env:    EnvConfig{User: "partner", Password: "hunter2"},
+		server: ssh.ServerConfig{PasswordCallback: acceptPassword},
+	}, {
+		name:    "keyboard-interactive",
+		env:     EnvConfig{User: "partner", Password: "hunter2"},
+		methods: []string{authKeyboardInteractive},
+		server:  ssh.ServerConfig{KeyboardInteractiveCallback: acceptAnswers},
+	}, {
+		// The server doesn't know the key, and only asks for the password
+		// keyboard-interactively.
+		name: "methods tried in order",
+		env:  EnvConfig{User: "partner", PrivateKey: key, Password: "hunter2"},
+		server: ssh.ServerConfig{
+			PublicKeyCallback:           acceptKey(encryptedSigner.PublicKey()),
+			KeyboardInteractiveCallback: acceptAnswers,
+		},
+	}, {
+		name:    "only the methods asked for",
+		env:     EnvConfig{User: "partner", PrivateKey: key, Password: "hunter2"},
+		methods: []string{authPublicKey},
+		server: ssh.ServerConfig{
+			PublicKeyCallback:           acceptKey(encryptedSigner.PublicKey()),
+			KeyboardInteractiveCallback: acceptAnswers,
+		},
+		wantErr: true,
+	}, {
+		name:    "wrong password",
+		env:     EnvConfig{User: "partner", Password: "hunter3"},
+		server:  ssh.ServerConfig{PasswordCallback: acceptPassword, KeyboardInteractiveCallback: acceptAnswers},
+		wantErr: true,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := &watchTarget{}
+			user, creds, err := target.credentials(&test.env)
+			if err != nil {
+				t.Fatalf("credentials() = %v", err)
+			}
+			auth, err := sshAuthMethods(creds, test.methods)
+			if err != nil {
+				t.Fatalf("sshAuthMethods() = %v", err)
+			}
+			err = login(t, &test.server, user, auth)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("Logging in = %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSSHAuthMethodsErrors(t *testing.T) {
+	key, signer := newPrivateKey(t, "")
+	encrypted, _ := newPrivateKey(t, "s3cret")
+
+	tests := []struct {
+		name    string
+		creds   sshCredentials
+		methods []string
+	}{{
+		name: "no credentials",
+	}, {
+		name:    "publickey without a private key",
+		creds:   sshCredentials{password: "hunter2"},
+		methods: []string{authPublicKey},
+	}, {
+		name:    "unknown method",
+		creds:   sshCredentials{password: "hunter2"},
+		methods: []string{"gssapi-with-mic"},
+	}, {
+		name:  "invalid private key",
+		creds: sshCredentials{privateKey: "not a key"},
+	}, {
+		name:  "missing passphrase",
+		creds: sshCredentials{privateKey: encrypted},
+	}, {
+		name:  "wrong passphrase",
+		creds: sshCredentials{privateKey: encrypted, passphrase: "hunter2"},
+	}, {
+		name:  "not a certificate",
+		creds: sshCredentials{privateKey: key, certificate: string(ssh.MarshalAuthorizedKey(signer.PublicKey()))},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if auth, err := sshAuthMethods(test.creds, test.methods); err == nil {
+				t.Errorf("sshAuthMethods() = %d methods, want error", len(auth))
+			}
+		})
+	}
+}
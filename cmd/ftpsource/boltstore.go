@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// stateBucket is the bbolt bucket we keep state in.
+var stateBucket = []byte("ftpsource")
+
+// boltStore keeps the state in a bbolt database file, typically on a
+// PersistentVolume, which isn't limited in size like ConfigMaps. The file is
+// locked while open, so only one watcher can use it at a time.
+type boltStore struct {
+	db  *bolt.DB
+	key []byte
+}
+
+var _ keyedStore = (*boltStore)(nil)
+
+func newBoltStore(path, key string) (*boltStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("missing state file")
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 30 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening state file: %w", err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(stateBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating state bucket: %w", err)
+	}
+	return &boltStore{db: db, key: []byte(key)}, nil
+}
+
+// withKey implements keyedStore. The keys are prefixed with the key of the
+// store, which names the source.
+func (s *boltStore) withKey(key string) sharedStore {
+	return &boltStore{db: s.db, key: []byte(string(s.key) + "/" + key)}
+}
+
+// Load implements StateStore.
+func (s *boltStore) Load(ctx context.Context) (*configdata, error) {
+	var b []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// The value is only valid during the transaction.
+		b = append(b, tx.Bucket(stateBucket).Get(s.key)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalState(b)
+}
+
+// Save implements StateStore.
+func (s *boltStore) Save(ctx context.Context, data *configdata) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(stateBucket).Put(s.key, b)
+	})
+}
+
+// update implements sharedStore, in a single transaction.
+func (s *boltStore) update(ctx context.Context, fn func(*configdata)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(stateBucket)
+		data, err := unmarshalState(bucket.Get(s.key))
+		if err != nil {
+			return err
+		}
+		fn(data)
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(s.key, b)
+	})
+}
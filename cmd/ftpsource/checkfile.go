@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The check-file extension of SFTP, from draft-ietf-secsh-filexfer-extensions,
+// asks the server for the checksum of a file. The sftp client doesn't expose
+// extended requests, so we speak just enough of the protocol to make one, on
+// an SFTP session of our own.
+const (
+	sftpProtocolVersion = 3
+
+	sshFxpInit          = 1
+	sshFxpVersion       = 2
+	sshFxpStatus        = 101
+	sshFxpExtended      = 200
+	sshFxpExtendedReply = 201
+
+	sshFxOpUnsupported = 8
+
+	// Larger packets than this aren't replies to a check-file request.
+	maxSFTPPacket = 64 * 1024
+)
+
+// checkFile asks the SFTP server at the other end of rw for the checksum of
+// the whole file with the given algorithm, and returns errChecksumUnsupported
+// if the server doesn't support the extension or the algorithm.
+func checkFile(rw io.ReadWriter, path, algorithm string) (string, error) {
+	var init bytes.Buffer
+	putUint32(&init, sftpProtocolVersion)
+	if err := writeSFTPPacket(rw, sshFxpInit, init.Bytes()); err != nil {
+		return "", err
+	}
+	if typ, _, err := readSFTPPacket(rw); err != nil {
+		return "", err
+	} else if typ != sshFxpVersion {
+		return "", fmt.Errorf("unexpected SFTP packet type %d, want version", typ)
+	}
+
+	const id = 1
+	var req bytes.Buffer
+	putUint32(&req, id)
+	putString(&req, "check-file-name")
+	putString(&req, path)
+	putString(&req, algorithm)
+	// The whole file, at once.
+	binary.Write(&req, binary.BigEndian, uint64(0))
+	binary.Write(&req, binary.BigEndian, uint64(0))
+	putUint32(&req, 0)
+	if err := writeSFTPPacket(rw, sshFxpExtended, req.Bytes()); err != nil {
+		return "", err
+	}
+
+	typ, reply, err := readSFTPPacket(rw)
+	if err != nil {
+		return "", err
+	}
+	switch typ {
+	case sshFxpStatus:
+		// uint32 id, uint32 code, string message, string language
+		if len(reply) < 8 {
+			return "", errors.New("short SFTP status")
+		}
+		code := binary.BigEndian.Uint32(reply[4:8])
+		if code == sshFxOpUnsupported {
+			return "", errChecksumUnsupported
+		}
+		msg, _, _ := getString(reply[8:])
+		return "", fmt.Errorf("check-file failed with status %d: %s", code, msg)
+	case sshFxpExtendedReply:
+		// uint32 id, string "check-file", string algorithm, byte[] hash
+		if len(reply) < 4 {
+			return "", errors.New("short SFTP extended reply")
+		}
+		name, rest, err := getString(reply[4:])
+		if err != nil {
+			return "", err
+		}
+		used, hash, err := getString(rest)
+		if err != nil {
+			return "", err
+		}
+		sum := hex.EncodeToString(hash)
+		if name != "check-file" || used != algorithm || !isChecksum(sum, algorithm) {
+			return "", errChecksumUnsupported
+		}
+		return sum, nil
+	default:
+		return "", fmt.Errorf("unexpected SFTP packet type %d, want a check-file reply", typ)
+	}
+}
+
+func putUint32(b *bytes.Buffer, v uint32) {
+	binary.Write(b, binary.BigEndian, v)
+}
+
+func putString(b *bytes.Buffer, s string) {
+	putUint32(b, uint32(len(s)))
+	b.WriteString(s)
+}
+
+// getString returns the string at the start of b, and what follows it.
+func getString(b []byte) (string, []byte, error) {
+	if len(b) < 4 {
+		return "", nil, errors.New("short SFTP string")
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < n {
+		return "", nil, errors.New("short SFTP string")
+	}
+	return string(b[4 : 4+n]), b[4+n:], nil
+}
+
+func writeSFTPPacket(w io.Writer, typ byte, payload []byte) error {
+	var b bytes.Buffer
+	putUint32(&b, uint32(1+len(payload)))
+	b.WriteByte(typ)
+	b.Write(payload)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func readSFTPPacket(r io.Reader) (byte, []byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return 0, nil, err
+	}
+	if n == 0 || n > maxSFTPPacket {
+		return 0, nil, fmt.Errorf("invalid SFTP packet length %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, err
+	}
+	return b[0], b[1:], nil
+}
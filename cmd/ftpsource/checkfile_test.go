@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net"
+	"testing"
+)
+
+// serveCheckFile answers the INIT and the check-file request of a client on
+// conn with reply, a packet of the given type, and returns the request.
+func serveCheckFile(t *testing.T, conn net.Conn, typ byte, reply []byte) <-chan []byte {
+	requests := make(chan []byte, 1)
+	go func() {
+		defer conn.Close()
+		if typ, _, err := readSFTPPacket(conn); err != nil || typ != sshFxpInit {
+			t.Errorf("INIT = %d, %v", typ, err)
+			return
+		}
+		var version bytes.Buffer
+		putUint32(&version, sftpProtocolVersion)
+		putString(&version, "check-file")
+		putString(&version, "1")
+		if err := writeSFTPPacket(conn, sshFxpVersion, version.Bytes()); err != nil {
+			t.Errorf("writing VERSION: %v", err)
+			return
+		}
+		reqTyp, req, err := readSFTPPacket(conn)
+		if err != nil || reqTyp != sshFxpExtended {
+			t.Errorf("EXTENDED = %d, %v", reqTyp, err)
+			return
+		}
+		requests <- req
+		if err := writeSFTPPacket(conn, typ, reply); err != nil {
+			t.Errorf("writing reply: %v", err)
+		}
+	}()
+	return requests
+}
+
+func extendedReply(name, algorithm, hash string) []byte {
+	var b bytes.Buffer
+	putUint32(&b, 1)
+	putString(&b, name)
+	putString(&b, algorithm)
+	raw, _ := hex.DecodeString(hash)
+	b.Write(raw)
+	return b.Bytes()
+}
+
+func status(code uint32, msg string) []byte {
+	var b bytes.Buffer
+	putUint32(&b, 1)
+	putUint32(&b, code)
+	putString(&b, msg)
+	putString(&b, "")
+	return b.Bytes()
+}
+
+func TestCheckFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		typ       byte
+		reply     []byte
+		want      string
+		wantErr   bool
+		// Whether the error is errChecksumUnsupported.
+		unsupported bool
+	}{{
+		name:      "sha256",
+		algorithm: checksumSHA256,
+		typ:       sshFxpExtendedReply,
+		reply:     extendedReply("check-file", "sha256", testSHA256),
+		want:      testSHA256,
+	}, {
+		name:      "crc32",
+		algorithm: checksumCRC32,
+		typ:       sshFxpExtendedReply,
+		reply:     extendedReply("check-file", "crc32", testCRC32),
+		want:      testCRC32,
+	}, {
+		name:        "not supported",
+		algorithm:   checksumSHA256,
+		typ:         sshFxpStatus,
+		reply:       status(sshFxOpUnsupported, "unsupported"),
+		wantErr:     true,
+		unsupported: true,
+	}, {
+		name:        "other algorithm",
+		algorithm:   checksumSHA256,
+		typ:         sshFxpExtendedReply,
+		reply:       extendedReply("check-file", "md5", testMD5),
+		wantErr:     true,
+		unsupported: true,
+	}, {
+		name:        "truncated hash",
+		algorithm:   checksumSHA256,
+		typ:         sshFxpExtendedReply,
+		reply:       extendedReply("check-file", "sha256", testSHA256[:32]),
+		wantErr:     true,
+		unsupported: true,
+	}, {
+		name:      "no such file",
+		algorithm: checksumSHA256,
+		typ:       sshFxpStatus,
+		reply:     status(2, "no such file"),
+		wantErr:   true,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			requests := serveCheckFile(t, server, test.typ, test.reply)
+
+			got, err := checkFile(client, "/in/test.csv", test.algorithm)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("checkFile() = %v, want error: %v", err, test.wantErr)
+			}
+			if unsupported := errors.Is(err, errChecksumUnsupported); unsupported != test.unsupported {
+				t.Errorf("checkFile() = %v, want %v: %v", err, errChecksumUnsupported, test.unsupported)
+			}
+			if got != test.want {
+				t.Errorf("checkFile() = %q, want %q", got, test.want)
+			}
+
+			// The request names the file and the algorithm.
+			req := <-requests
+			ext, rest, _ := getString(req[4:])
+			path, rest, _ := getString(rest)
+			algorithm, _, _ := getString(rest)
+			if ext != "check-file-name" || path != "/in/test.csv" || algorithm != test.algorithm {
+				t.Errorf("request = %q %q %q, want check-file-name /in/test.csv %s", ext, path, algorithm, test.algorithm)
+			}
+		})
+	}
+}
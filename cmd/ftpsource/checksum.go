@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// The algorithms we can checksum files with.
+const (
+	checksumSHA256 = "sha256"
+	checksumMD5    = "md5"
+	checksumCRC32  = "crc32"
+)
+
+// Where we can get the checksums to verify files against from.
+const (
+	// A sidecar file named like the file with the algorithm appended, for
+	// example report.csv.sha256, like sha256sum writes them.
+	verifySidecar = "sidecar"
+	// The server, with the FTP HASH command or its older X extensions.
+	verifyServer = "server"
+)
+
+// Extensions describing the checksum of a file.
+const (
+	// The algorithm and hex checksum of the file, like sha256:9f86d0...
+	extensionChecksum = "ftpchecksum"
+	// The checksum the file was expected to have, for failure events.
+	extensionExpectedChecksum = "ftpexpectedchecksum"
+)
+
+var (
+	// errChecksumUnsupported is returned by servers that can't compute the
+	// checksum of a file.
+	errChecksumUnsupported = errors.New("checksums are not supported by the server")
+	// errChecksumMismatch is returned by the handler when a file failed
+	// verification, after sending a failure event instead of its event.
+	errChecksumMismatch = errors.New("checksum mismatch")
+	// errUnchanged is returned by the handler when a modified file still
+	// has the checksum it was processed with, without sending an event.
+	errUnchanged = errors.New("content unchanged")
+)
+
+// checksums computes the checksums of files while they are streamed, and
+// verifies them, so consumers can rely on getting the file that was
+// uploaded.
+type checksums struct {
+	algorithm string
+	sidecar   bool
+	server    bool
+}
+
+// newChecksums returns nil if no algorithm is given, in which case no
+// checksums are computed.
+func newChecksums(algorithm string, verify []string) (*checksums, error) {
+	if algorithm == "" {
+		if len(verify) > 0 {
+			return nil, fmt.Errorf("missing algorithm to verify checksums with")
+		}
+		return nil, nil
+	}
+	c := &checksums{algorithm: algorithm}
+	if c.newHash() == nil {
+		return nil, fmt.Errorf("unknown checksum algorithm %q", algorithm)
+	}
+	for _, v := range verify {
+		switch v {
+		case verifySidecar:
+			c.sidecar = true
+		case verifyServer:
+			c.server = true
+		default:
+			return nil, fmt.Errorf("unknown checksum verification %q", v)
+		}
+	}
+	return c, nil
+}
+
+func (c *checksums) newHash() hash.Hash {
+	switch c.algorithm {
+	case checksumSHA256:
+		return sha256.New()
+	case checksumMD5:
+		return md5.New()
+	case checksumCRC32:
+		return crc32.NewIEEE()
+	}
+	return nil
+}
+
+// sidecarSuffix is the suffix of sidecar checksum files, which we don't
+// send events for when verifying against them.
+func (c *checksums) sidecarSuffix() string {
+	return "." + c.algorithm
+}
+
+// compute returns the checksum of the file. The file is only downloaded if
+// it hasn't been read to the end already, for inlining or copying it.
+func (c *checksums) compute(f *remoteFile) (string, error) {
+	if f.sum == nil || !f.sum.done {
+		f.sum = &fileHash{hash: c.newHash()}
+		r, err := f.open()
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		if _, err := io.Copy(ioutil.Discard, r); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(f.sum.hash.Sum(nil)), nil
+}
+
+// expected returns the checksum to verify the file against, and where it
+// came from, or "" if there's none.
+func (c *checksums) expected(f *remoteFile) (string, string, error) {
+	if c.sidecar {
+		sum, err := c.readSidecar(f)
+		if err != nil && !notFound(err) {
+			return "", "", fmt.Errorf("reading sidecar: %w", err)
+		}
+		if sum != "" {
+			return sum, verifySidecar, nil
+		}
+	}
+	if c.server {
+		sum, err := f.client.checksum(f.path, c.algorithm)
+		if err != nil && !errors.Is(err, errChecksumUnsupported) {
+			return "", "", fmt.Errorf("getting checksum from the server: %w", err)
+		}
+		if sum != "" {
+			return sum, verifyServer, nil
+		}
+	}
+	return "", "", nil
+}
+
+// readSidecar reads the checksum from the sidecar file of the file, which
+// is either in the "<checksum>  <name>" format of sha256sum and friends, or
+// in the "SHA256 (<name>) = <checksum>" format of their BSD counterparts.
+func (c *checksums) readSidecar(f *remoteFile) (string, error) {
+	r, err := f.client.open(f.path + c.sidecarSuffix())
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(r, 4096))
+	if err != nil {
+		return "", err
+	}
+	line := strings.SplitN(string(b), "\n", 2)[0]
+	if i := strings.LastIndex(line, "= "); i >= 0 {
+		line = line[i+2:]
+	}
+	return parseChecksum(strings.Fields(line), c.algorithm), nil
+}
+
+// parseChecksum returns the first of fields that is a checksum with the
+// given algorithm, in lower case, or "" if there's none. Only fields of
+// exactly the length of the checksum are taken for one, so that names, sizes
+// and the like in replies aren't.
+func parseChecksum(fields []string, algorithm string) string {
+	for _, f := range fields {
+		if isChecksum(f, algorithm) {
+			return strings.ToLower(f)
+		}
+	}
+	return ""
+}
+
+// parseChecksumColumn returns the field known to hold a checksum with the
+// given algorithm, in lower case, or "" if it isn't one. Servers may drop
+// the leading zeros of CRCs, which are restored.
+func parseChecksumColumn(field, algorithm string) string {
+	if size := 2 * crc32.Size; algorithm == checksumCRC32 && len(field) > 0 && len(field) < size {
+		field = strings.Repeat("0", size-len(field)) + field
+	}
+	return parseChecksum([]string{field}, algorithm)
+}
+
+// isChecksum returns true if s is the hex encoding of a checksum with the
+// given algorithm.
+func isChecksum(s, algorithm string) bool {
+	size := map[string]int{checksumSHA256: sha256.Size, checksumMD5: md5.Size, checksumCRC32: crc32.Size}[algorithm]
+	if len(s) != 2*size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// fileHash is the checksum of a file, computed while the file is read.
+type fileHash struct {
+	hash hash.Hash
+	// done is set once the file has been read to the end.
+	done bool
+}
+
+// reader returns a reader computing the checksum of what's read from r,
+// starting from scratch.
+func (h *fileHash) reader(r io.ReadCloser) io.ReadCloser {
+	h.hash.Reset()
+	h.done = false
+	return &hashingReader{ReadCloser: r, sum: h}
+}
+
+type hashingReader struct {
+	io.ReadCloser
+	sum *fileHash
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.sum.hash.Write(p[:n])
+	if err == io.EOF {
+		r.sum.done = true
+	}
+	return n, err
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// The checksums of "test".
+const (
+	testSHA256 = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	testMD5    = "098f6bcd4621d373cade4e832627b4f6"
+	testCRC32  = "d87f7e0c"
+)
+
+func TestParseChecksum(t *testing.T) {
+	tests := []struct {
+		name      string
+		fields    []string
+		algorithm string
+		want      string
+	}{{
+		name:      "sha256",
+		fields:    []string{testSHA256},
+		algorithm: checksumSHA256,
+		want:      testSHA256,
+	}, {
+		name:      "upper case",
+		fields:    []string{strings.ToUpper(testSHA256)},
+		algorithm: checksumSHA256,
+		want:      testSHA256,
+	}, {
+		name:      "first checksum of the fields",
+		fields:    []string{"213", "SHA-256", "0-4", testSHA256, "test.csv"},
+		algorithm: checksumSHA256,
+		want:      testSHA256,
+	}, {
+		name:      "checksum of another algorithm",
+		fields:    []string{testMD5},
+		algorithm: checksumSHA256,
+	}, {
+		name:      "md5",
+		fields:    []string{testMD5, "test.csv"},
+		algorithm: checksumMD5,
+		want:      testMD5,
+	}, {
+		name:      "crc32",
+		fields:    []string{testCRC32},
+		algorithm: checksumCRC32,
+		want:      testCRC32,
+	}, {
+		name:      "crc32 without leading zeros isn't taken for one",
+		fields:    []string{"7f7e0c"},
+		algorithm: checksumCRC32,
+	}, {
+		name:      "hex looking fields of other lengths",
+		fields:    []string{"213", "cafe", "0-4", "deadbeef00", testCRC32},
+		algorithm: checksumCRC32,
+		want:      testCRC32,
+	}, {
+		name:      "hex looking name",
+		fields:    []string{"dead.csv", "beef", "d87f7e0c0"},
+		algorithm: checksumCRC32,
+	}, {
+		name:      "sha256 one digit short",
+		fields:    []string{testSHA256[1:]},
+		algorithm: checksumSHA256,
+	}, {
+		name:      "not hex",
+		fields:    []string{strings.Repeat("z", 64)},
+		algorithm: checksumSHA256,
+	}, {
+		name:      "nothing",
+		algorithm: checksumSHA256,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parseChecksum(test.fields, test.algorithm); got != test.want {
+				t.Errorf("parseChecksum() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseChecksumColumn(t *testing.T) {
+	tests := []struct {
+		field     string
+		algorithm string
+		want      string
+	}{
+		{testCRC32, checksumCRC32, testCRC32},
+		{"7F7E0C", checksumCRC32, "007f7e0c"},
+		{"0", checksumCRC32, "00000000"},
+		{"", checksumCRC32, ""},
+		{"d87f7e0c0", checksumCRC32, ""},
+		{"test.csv", checksumCRC32, ""},
+		{testMD5, checksumMD5, testMD5},
+		{testMD5[1:], checksumMD5, ""},
+		{testSHA256[2:], checksumSHA256, ""},
+	}
+	for _, test := range tests {
+		if got := parseChecksumColumn(test.field, test.algorithm); got != test.want {
+			t.Errorf("parseChecksumColumn(%q, %s) = %q, want %q", test.field, test.algorithm, got, test.want)
+		}
+	}
+}
+
+func TestChecksumsExpected(t *testing.T) {
+	tests := []struct {
+		name       string
+		verify     []string
+		sidecar    string // the content of test.csv.sha256, none if ""
+		server     string // the checksum the server computes, none if ""
+		want       string
+		wantSource string
+	}{{
+		name:       "sha256sum sidecar",
+		verify:     []string{verifySidecar},
+		sidecar:    testSHA256 + "  test.csv\n",
+		want:       testSHA256,
+		wantSource: verifySidecar,
+	}, {
+		name:       "binary mode sha256sum sidecar",
+		verify:     []string{verifySidecar},
+		sidecar:    testSHA256 + " *test.csv\n",
+		want:       testSHA256,
+		wantSource: verifySidecar,
+	}, {
+		name:       "BSD sidecar",
+		verify:     []string{verifySidecar},
+		sidecar:    "SHA256 (test.csv) = " + testSHA256 + "\n",
+		want:       testSHA256,
+		wantSource: verifySidecar,
+	}, {
+		name:       "only the first line of the sidecar",
+		verify:     []string{verifySidecar},
+		sidecar:    "checksum follows\n" + testSHA256 + "\n",
+		wantSource: "",
+	}, {
+		name:   "no sidecar",
+		verify: []string{verifySidecar},
+	}, {
+		name:       "server",
+		verify:     []string{verifyServer},
+		server:     testSHA256,
+		want:       testSHA256,
+		wantSource: verifyServer,
+	}, {
+		name:       "sidecar before the server",
+		verify:     []string{verifySidecar, verifyServer},
+		sidecar:    testSHA256 + "  test.csv\n",
+		server:     "other",
+		want:       testSHA256,
+		wantSource: verifySidecar,
+	}, {
+		name:       "server without sidecar",
+		verify:     []string{verifySidecar, verifyServer},
+		server:     testSHA256,
+		want:       testSHA256,
+		wantSource: verifyServer,
+	}, {
+		name:   "server without checksums",
+		verify: []string{verifyServer},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := newChecksums(checksumSHA256, test.verify)
+			if err != nil {
+				t.Fatalf("newChecksums() = %v", err)
+			}
+			r := &fakeRemote{files: map[string]string{"/in/test.csv": "test"}, checksums: map[string]string{}}
+			if test.sidecar != "" {
+				r.files["/in/test.csv.sha256"] = test.sidecar
+			}
+			if test.server != "" {
+				r.checksums["/in/test.csv"] = test.server
+			}
+			sum, source, err := c.expected(&remoteFile{client: r, path: "/in/test.csv"})
+			if err != nil {
+				t.Fatalf("expected() = %v", err)
+			}
+			if sum != test.want || source != test.wantSource {
+				t.Errorf("expected() = %q, %q, want %q, %q", sum, source, test.want, test.wantSource)
+			}
+		})
+	}
+}
+
+func TestChecksumsCompute(t *testing.T) {
+	for algorithm, want := range map[string]string{
+		checksumSHA256: testSHA256,
+		checksumMD5:    testMD5,
+		checksumCRC32:  testCRC32,
+	} {
+		c, err := newChecksums(algorithm, nil)
+		if err != nil {
+			t.Fatalf("newChecksums() = %v", err)
+		}
+		r := &fakeRemote{files: map[string]string{"/in/test.csv": "test"}}
+		if got, err := c.compute(&remoteFile{client: r, path: "/in/test.csv"}); err != nil || got != want {
+			t.Errorf("compute() with %s = %q, %v, want %q", algorithm, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// The stores we can copy files to in claim-check mode.
+const (
+	claimCheckNone   = ""
+	claimCheckS3     = "s3"
+	claimCheckVolume = "volume"
+)
+
+// objectStore is where claim-check mode copies files to, so consumers can
+// read them without credentials for the server, or while it's unavailable.
+type objectStore interface {
+	// put stores what's read from r under key, and returns a reference to
+	// the stored object.
+	put(ctx context.Context, key string, r io.Reader, contentType string) (*FTPObject, error)
+}
+
+// claimCheckConfig selects and configures the objectStore.
+type claimCheckConfig struct {
+	backend string
+	// Files of at least minSize bytes are copied, all of them if 0.
+	minSize int64
+	// Prefix of the keys of the copies, followed by the server, the
+	// watched directory and the path of the file relative to it.
+	prefix string
+	// Bucket, endpoint and region for the s3 backend. The endpoint is only
+	// needed for other S3 compatible stores than AWS, like MinIO, which
+	// usually also need path style URLs.
+	s3Bucket    string
+	s3Endpoint  string
+	s3Region    string
+	s3PathStyle bool
+	// Directory the volume backend copies files to.
+	dir string
+}
+
+// claimCheck copies files to an objectStore before their events are sent,
+// and the events reference the copies.
+type claimCheck struct {
+	store   objectStore
+	minSize int64
+	prefix  string
+}
+
+// newClaimCheck returns the claimCheck for the configured backend, nil if
+// claim-check mode is off.
+func newClaimCheck(cfg claimCheckConfig) (*claimCheck, error) {
+	var store objectStore
+	var err error
+	switch cfg.backend {
+	case claimCheckNone:
+		return nil, nil
+	case claimCheckS3:
+		store, err = newS3Store(cfg.s3Bucket, cfg.s3Endpoint, cfg.s3Region, cfg.s3PathStyle)
+	case claimCheckVolume:
+		store, err = newVolumeStore(cfg.dir)
+	default:
+		return nil, fmt.Errorf("unknown claim-check store %q", cfg.backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.minSize < 0 {
+		return nil, fmt.Errorf("invalid minimum size to claim-check %d", cfg.minSize)
+	}
+	return &claimCheck{store: store, minSize: cfg.minSize, prefix: cfg.prefix}, nil
+}
+
+// applies returns true if the file should be copied. f is nil for files
+// that are no longer on the server.
+func (c *claimCheck) applies(e os.FileInfo, f *remoteFile) bool {
+	return c != nil && f != nil && e.Size() >= c.minSize
+}
+
+// key returns the key of the copy of the file with the given path relative
+// to the directory on the server, like
+// <prefix>/ftp.example.com:21/outgoing/report.csv, so that the copies of
+// every server and directory watched are kept apart.
+func (c *claimCheck) key(server, dir, rel string) string {
+	// Rooting the directory keeps ".." from escaping the server.
+	return path.Join(c.prefix, server, path.Join("/", dir), rel)
+}
+
+// copy streams the file with the given path relative to the directory on
+// the server to the store.
+func (c *claimCheck) copy(ctx context.Context, server, dir, rel string, f *remoteFile) (*FTPObject, error) {
+	r, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return c.store.put(ctx, c.key(server, dir, rel), r, contentType(rel, nil))
+}
+
+// volumeStore copies files to a directory, usually on a volume shared with
+// the consumers.
+type volumeStore struct {
+	dir string
+}
+
+var _ objectStore = (*volumeStore)(nil)
+
+func newVolumeStore(dir string) (*volumeStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("missing directory to copy files to")
+	}
+	return &volumeStore{dir: dir}, nil
+}
+
+// put writes the copy to a temporary file first, so consumers never see
+// partial copies. The ETag is the hex MD5 of the content, like the ETags of
+// objects uploaded to S3 in one part.
+func (s *volumeStore) put(ctx context.Context, key string, r io.Reader, contentType string) (*FTPObject, error) {
+	name := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, fmt.Errorf("creating %s: %w", filepath.Dir(name), err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(name), ".ftpsource-")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	h := md5.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("copying to %s: %w", f.Name(), err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("closing %s: %w", f.Name(), err)
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return nil, fmt.Errorf("making %s readable: %w", f.Name(), err)
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return nil, fmt.Errorf("renaming %s to %s: %w", f.Name(), name, err)
+	}
+	u := url.URL{Scheme: "file", Path: name}
+	return &FTPObject{URL: u.String(), Key: key, ETag: `"` + hex.EncodeToString(h.Sum(nil)) + `"`, Size: size}, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClaimCheckKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		server string
+		dir    string
+		want   string
+	}{{
+		name:   "absolute directory",
+		prefix: "partner-a",
+		server: "ftp.example.com:21",
+		dir:    "/outgoing",
+		want:   "partner-a/ftp.example.com:21/outgoing/sub/report.csv",
+	}, {
+		name:   "relative directory",
+		prefix: "partner-a",
+		server: "ftp.example.com:21",
+		dir:    "outgoing",
+		want:   "partner-a/ftp.example.com:21/outgoing/sub/report.csv",
+	}, {
+		name:   "home directory",
+		prefix: "partner-a",
+		server: "ftp.example.com:21",
+		dir:    ".",
+		want:   "partner-a/ftp.example.com:21/sub/report.csv",
+	}, {
+		name:   "no prefix",
+		server: "ftp.example.com:21",
+		dir:    "/outgoing/",
+		want:   "ftp.example.com:21/outgoing/sub/report.csv",
+	}, {
+		name:   "directory out of the server",
+		prefix: "partner-a",
+		server: "ftp.example.com:21",
+		dir:    "../../other",
+		want:   "partner-a/ftp.example.com:21/other/sub/report.csv",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &claimCheck{prefix: test.prefix}
+			if got := c.key(test.server, test.dir, "sub/report.csv"); got != test.want {
+				t.Errorf("key() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestClaimCheckCopy(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newClaimCheck(claimCheckConfig{backend: claimCheckVolume, dir: dir, prefix: "partner-a"})
+	if err != nil {
+		t.Fatalf("newClaimCheck() = %v", err)
+	}
+	// The same file in two directories.
+	r := &fakeRemote{files: map[string]string{"/in/a.csv": "in", "/out/a.csv": "out"}}
+	for _, d := range []string{"/in", "/out"} {
+		obj, err := c.copy(context.Background(), "ftp.example.com:21", d, "a.csv", &remoteFile{client: r, path: d + "/a.csv"})
+		if err != nil {
+			t.Fatalf("copy() = %v", err)
+		}
+		want := &FTPObject{
+			URL:  "file://" + filepath.Join(dir, "partner-a/ftp.example.com:21", d, "a.csv"),
+			Key:  "partner-a/ftp.example.com:21" + d + "/a.csv",
+			ETag: map[string]string{"/in": `"13b5bfe96f3e2fe411c9f66f4a582adf"`, "/out": `"c68271a63ddbc431c307beb7d2918275"`}[d],
+			Size: int64(len(r.files[d+"/a.csv"])),
+		}
+		if diff := cmp.Diff(want, obj); diff != "" {
+			t.Errorf("copy() (-want, +got): %s", diff)
+		}
+	}
+	for _, d := range []string{"in", "out"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "partner-a/ftp.example.com:21", d, "a.csv"))
+		if err != nil {
+			t.Fatalf("ReadFile() = %v", err)
+		}
+		if got := string(b); got != d {
+			t.Errorf("copy of /%s/a.csv = %q, want %q", d, got, d)
+		}
+	}
+}
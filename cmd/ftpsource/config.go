@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Names of targets are DNS labels, so they can be part of state keys.
+var targetName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Characters of directories that can't be part of state keys.
+var invalidKeyChars = regexp.MustCompile(`[^-_a-zA-Z0-9]`)
+
+// Files the credentials of a target are read from, named like the keys of
+// the credentials Secret of FTPSources, so the Secret can be mounted as is.
+const (
+	credentialsUser        = "user"
+	credentialsPassword    = "password"
+	credentialsPrivateKey  = "privateKey"
+	credentialsPassphrase  = "privateKeyPassphrase"
+	credentialsCertificate = "certificate"
+)
+
+// watchConfig is the configuration file of an adapter watching several
+// servers and directories at once, typically mounted from a ConfigMap.
+type watchConfig struct {
+	Targets []watchTarget `json:"targets"`
+}
+
+// watchTarget is a server to watch directories on. The protocol, interval,
+// authentication methods, host key verification, TLS configuration, filters
+// and event attributes a target leaves out default to the flags, and its
+// extensions are added to the ones of the flags. The switches are pointers,
+// so that a target can turn off what the flags turn on.
+type watchTarget struct {
+	// Name identifies the target in logs and names its state.
+	Name string `json:"name"`
+	// Server is the host:port of the server.
+	Server   string `json:"server"`
+	Protocol string `json:"protocol,omitempty"`
+	// CredentialsDir holds the user, password, privateKey,
+	// privateKeyPassphrase and certificate files to log in with, all
+	// optional, usually a mounted Secret. Defaults to the credentials in
+	// the environment.
+	CredentialsDir string   `json:"credentialsDir,omitempty"`
+	AuthMethods    []string `json:"authMethods,omitempty"`
+
+	// Host key verification for SFTP.
+	KnownHosts            []string `json:"knownHosts,omitempty"`
+	HostKeyFingerprints   []string `json:"hostKeyFingerprints,omitempty"`
+	TrustOnFirstUse       *bool    `json:"trustOnFirstUse,omitempty"`
+	InsecureIgnoreHostKey *bool    `json:"insecureIgnoreHostKey,omitempty"`
+
+	// TLS configuration for FTPS.
+	TLSDir                string `json:"tlsDir,omitempty"`
+	TLSInsecureSkipVerify *bool  `json:"tlsInsecureSkipVerify,omitempty"`
+
+	// Directories to watch, each by a watcher of its own. Defaults to the
+	// login directory.
+	Directories []string `json:"directories,omitempty"`
+	Recursive   bool     `json:"recursive,omitempty"`
+	MaxDepth    int      `json:"maxDepth,omitempty"`
+
+	Include      []string `json:"include,omitempty"`
+	Exclude      []string `json:"exclude,omitempty"`
+	IncludeRegex []string `json:"includeRegex,omitempty"`
+	ExcludeRegex []string `json:"excludeRegex,omitempty"`
+
+	// Interval between two probes of the directories.
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	TypePrefix     string            `json:"typePrefix,omitempty"`
+	SourceTemplate string            `json:"sourceTemplate,omitempty"`
+	Extensions     map[string]string `json:"extensions,omitempty"`
+}
+
+// loadWatchConfig reads the configuration file, and fills in the defaults of
+// its targets.
+func loadWatchConfig(file string) (*watchConfig, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config := &watchConfig{}
+	if err := yaml.UnmarshalStrict(b, config); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", file, err)
+	}
+	if len(config.Targets) == 0 {
+		return nil, fmt.Errorf("no targets to watch in %s", file)
+	}
+
+	names := make(map[string]bool, len(config.Targets))
+	for i := range config.Targets {
+		t := &config.Targets[i]
+		switch {
+		case !targetName.MatchString(t.Name):
+			return nil, fmt.Errorf("invalid target name %q, must be lower case letters, digits and dashes", t.Name)
+		case names[t.Name]:
+			return nil, fmt.Errorf("duplicate target %q", t.Name)
+		case t.Server == "":
+			return nil, fmt.Errorf("missing server of target %q", t.Name)
+		}
+		names[t.Name] = true
+		t.setDefaults()
+
+		keys := make(map[string]string, len(t.Directories))
+		for _, d := range t.Directories {
+			key := stateKey(t.Name, d)
+			if other, ok := keys[key]; ok {
+				return nil, fmt.Errorf("directories %q and %q of target %q would share their state", other, d, t.Name)
+			}
+			keys[key] = d
+		}
+	}
+	return config, nil
+}
+
+// flagTarget returns the target configured by the flags, for adapters
+// watching a single directory. It has no name, and keeps its state under the
+// key state was always kept under.
+func flagTarget() watchTarget {
+	return watchTarget{
+		Server:                sftpServer,
+		Protocol:              protocol,
+		AuthMethods:           authMethods,
+		KnownHosts:            knownHosts,
+		HostKeyFingerprints:   fingerprints,
+		TrustOnFirstUse:       boolPtr(trustFirstUse),
+		InsecureIgnoreHostKey: boolPtr(ignoreHostKey),
+		TLSDir:                tlsDir,
+		TLSInsecureSkipVerify: boolPtr(tlsSkipVerify),
+		Directories:           []string{dir},
+		Recursive:             recursive,
+		MaxDepth:              maxDepth,
+		Include:               include,
+		Exclude:               exclude,
+		IncludeRegex:          includeRegex,
+		ExcludeRegex:          excludeRegex,
+		Interval:              &metav1.Duration{Duration: time.Duration(probeFrequency) * time.Second},
+		TypePrefix:            typePrefix,
+		SourceTemplate:        sourceTemplate,
+	}
+}
+
+// setDefaults fills in what the target leaves out from the flags.
+func (t *watchTarget) setDefaults() {
+	if t.Protocol == "" {
+		t.Protocol = protocol
+	}
+	if t.AuthMethods == nil {
+		t.AuthMethods = authMethods
+	}
+	if t.KnownHosts == nil && t.HostKeyFingerprints == nil {
+		t.KnownHosts = knownHosts
+		t.HostKeyFingerprints = fingerprints
+	}
+	if t.TrustOnFirstUse == nil {
+		t.TrustOnFirstUse = boolPtr(trustFirstUse)
+	}
+	if t.InsecureIgnoreHostKey == nil {
+		t.InsecureIgnoreHostKey = boolPtr(ignoreHostKey)
+	}
+	if t.TLSDir == "" {
+		t.TLSDir = tlsDir
+	}
+	if t.TLSInsecureSkipVerify == nil {
+		t.TLSInsecureSkipVerify = boolPtr(tlsSkipVerify)
+	}
+	if len(t.Directories) == 0 {
+		t.Directories = []string{"."}
+	}
+	if t.Include == nil && t.Exclude == nil && t.IncludeRegex == nil && t.ExcludeRegex == nil {
+		t.Include = include
+		t.Exclude = exclude
+		t.IncludeRegex = includeRegex
+		t.ExcludeRegex = excludeRegex
+	}
+	if t.Interval == nil || t.Interval.Duration <= 0 {
+		t.Interval = &metav1.Duration{Duration: time.Duration(probeFrequency) * time.Second}
+	}
+	if t.TypePrefix == "" {
+		t.TypePrefix = typePrefix
+	}
+	if t.SourceTemplate == "" {
+		t.SourceTemplate = sourceTemplate
+	}
+}
+
+// boolPtr returns a pointer to a copy of b.
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// extensions returns the static extensions of the events of the target, as
+// name=value, after the ones of the flags so they take precedence.
+func (t *watchTarget) extensions() []string {
+	exts := append([]string{}, extensions...)
+	names := make([]string, 0, len(t.Extensions))
+	for name := range t.Extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		exts = append(exts, name+"="+t.Extensions[name])
+	}
+	return exts
+}
+
+// credentials returns the user and the credentials to log into the server
+// with, read from the credentials directory if there is one.
+func (t *watchTarget) credentials(env *EnvConfig) (string, sshCredentials, error) {
+	if t.CredentialsDir == "" {
+		return env.User, sshCredentials{
+			password:    env.Password,
+			privateKey:  env.PrivateKey,
+			passphrase:  env.PrivateKeyPassphrase,
+			certificate: env.Certificate,
+		}, nil
+	}
+	files := map[string]string{
+		credentialsUser:        "",
+		credentialsPassword:    "",
+		credentialsPrivateKey:  "",
+		credentialsPassphrase:  "",
+		credentialsCertificate: "",
+	}
+	for name := range files {
+		b, err := ioutil.ReadFile(filepath.Join(t.CredentialsDir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return "", sshCredentials{}, fmt.Errorf("reading credentials: %w", err)
+		}
+		files[name] = string(b)
+	}
+	// Secrets edited by hand often end in a newline.
+	return strings.TrimSpace(files[credentialsUser]), sshCredentials{
+		password:    strings.TrimRight(files[credentialsPassword], "\r\n"),
+		privateKey:  files[credentialsPrivateKey],
+		passphrase:  strings.TrimRight(files[credentialsPassphrase], "\r\n"),
+		certificate: files[credentialsCertificate],
+	}, nil
+}
+
+// stateKey returns the key the state of a directory of the target is kept
+// under, which is also a valid ConfigMap key, like configdata.acme._outgoing
+// for the /outgoing directory of the acme target. The state of the target
+// itself, like the host keys it trusts, is kept under the key of dir "".
+func stateKey(target, dir string) string {
+	key := configdatakey + "." + target
+	if dir != "" {
+		key += "." + invalidKeyChars.ReplaceAllString(path.Clean(dir), "_")
+	}
+	return key
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestStateKey(t *testing.T) {
+	tests := []struct {
+		target string
+		dir    string
+		want   string
+	}{
+		{target: "acme", dir: "/outgoing", want: "configdata.acme._outgoing"},
+		{target: "acme", dir: "/outgoing/", want: "configdata.acme._outgoing"},
+		{target: "acme", dir: "outgoing", want: "configdata.acme.outgoing"},
+		{target: "acme", dir: "/out going/2021.01", want: "configdata.acme._out_going_2021_01"},
+		{target: "acme", dir: ".", want: "configdata.acme._"},
+		{target: "acme", want: "configdata.acme"},
+	}
+	for _, test := range tests {
+		got := stateKey(test.target, test.dir)
+		if got != test.want {
+			t.Errorf("stateKey(%q, %q) = %q, want %q", test.target, test.dir, got, test.want)
+		}
+		if errs := validation.IsConfigMapKey(got); len(errs) > 0 {
+			t.Errorf("stateKey(%q, %q) = %q, not a valid ConfigMap key: %v", test.target, test.dir, got, errs)
+		}
+	}
+}
+
+// setFlags sets the flags targets default to for the test.
+func setFlags(t *testing.T) {
+	t.Helper()
+	oldProtocol, oldAuth, oldKnownHosts, oldFingerprints := protocol, authMethods, knownHosts, fingerprints
+	oldTOFU, oldIgnore, oldTLSDir, oldSkipVerify := trustFirstUse, ignoreHostKey, tlsDir, tlsSkipVerify
+	oldInclude, oldFrequency, oldPrefix, oldTemplate := include, probeFrequency, typePrefix, sourceTemplate
+	oldExtensions := extensions
+	t.Cleanup(func() {
+		protocol, authMethods, knownHosts, fingerprints = oldProtocol, oldAuth, oldKnownHosts, oldFingerprints
+		trustFirstUse, ignoreHostKey, tlsDir, tlsSkipVerify = oldTOFU, oldIgnore, oldTLSDir, oldSkipVerify
+		include, probeFrequency, typePrefix, sourceTemplate = oldInclude, oldFrequency, oldPrefix, oldTemplate
+		extensions = oldExtensions
+	})
+	protocol = protocolSFTP
+	authMethods = stringsFlag{"publickey"}
+	knownHosts = stringsFlag{"/etc/ssh/known_hosts"}
+	fingerprints = nil
+	trustFirstUse = true
+	ignoreHostKey = false
+	tlsDir = "/etc/ftpsource/tls"
+	tlsSkipVerify = true
+	include = stringsFlag{"*.csv"}
+	probeFrequency = 60
+	typePrefix = "org.example.ftp"
+	sourceTemplate = "ftp://{{ .Server }}"
+	extensions = stringsFlag{"team=ops", "env=prod"}
+}
+
+func TestLoadWatchConfig(t *testing.T) {
+	setFlags(t)
+	tests := []struct {
+		name    string
+		config  string
+		want    []watchTarget
+		wantErr string
+	}{{
+		name: "defaults from the flags",
+		config: `
+targets:
+- name: acme
+  server: sftp.acme.com:22
+`,
+		want: []watchTarget{{
+			Name:                  "acme",
+			Server:                "sftp.acme.com:22",
+			Protocol:              protocolSFTP,
+			AuthMethods:           []string{"publickey"},
+			KnownHosts:            []string{"/etc/ssh/known_hosts"},
+			TrustOnFirstUse:       boolPtr(true),
+			InsecureIgnoreHostKey: boolPtr(false),
+			TLSDir:                "/etc/ftpsource/tls",
+			TLSInsecureSkipVerify: boolPtr(true),
+			Directories:           []string{"."},
+			Include:               []string{"*.csv"},
+			Interval:              &metav1.Duration{Duration: time.Minute},
+			TypePrefix:            "org.example.ftp",
+			SourceTemplate:        "ftp://{{ .Server }}",
+		}},
+	}, {
+		name: "target overrides the flags",
+		config: `
+targets:
+- name: globex
+  server: ftp.globex.com:21
+  protocol: ftps-explicit
+  authMethods: [password]
+  hostKeyFingerprints: ["SHA256:abc"]
+  trustOnFirstUse: false
+  insecureIgnoreHostKey: true
+  tlsDir: /etc/globex/tls
+  tlsInsecureSkipVerify: false
+  directories: [/out, /reports]
+  exclude: ["*.tmp"]
+  interval: 30s
+  typePrefix: com.globex.ftp
+  sourceTemplate: globex
+`,
+		want: []watchTarget{{
+			Name:                  "globex",
+			Server:                "ftp.globex.com:21",
+			Protocol:              protocolFTPSExplicit,
+			AuthMethods:           []string{"password"},
+			HostKeyFingerprints:   []string{"SHA256:abc"},
+			TrustOnFirstUse:       boolPtr(false),
+			InsecureIgnoreHostKey: boolPtr(true),
+			TLSDir:                "/etc/globex/tls",
+			TLSInsecureSkipVerify: boolPtr(false),
+			Directories:           []string{"/out", "/reports"},
+			Exclude:               []string{"*.tmp"},
+			Interval:              &metav1.Duration{Duration: 30 * time.Second},
+			TypePrefix:            "com.globex.ftp",
+			SourceTemplate:        "globex",
+		}},
+	}, {
+		name:    "no targets",
+		config:  "targets: []\n",
+		wantErr: "no targets",
+	}, {
+		name:    "unknown field",
+		config:  "targets:\n- name: acme\n  server: a:22\n  directory: /out\n",
+		wantErr: "unknown field",
+	}, {
+		name:    "invalid name",
+		config:  "targets:\n- name: Acme\n  server: a:22\n",
+		wantErr: "invalid target name",
+	}, {
+		name:    "duplicate name",
+		config:  "targets:\n- name: acme\n  server: a:22\n- name: acme\n  server: b:22\n",
+		wantErr: "duplicate target",
+	}, {
+		name:    "missing server",
+		config:  "targets:\n- name: acme\n",
+		wantErr: "missing server",
+	}, {
+		name:    "directories sharing their state",
+		config:  "targets:\n- name: acme\n  server: a:22\n  directories: [/out, /out/]\n",
+		wantErr: "would share their state",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "config.yaml")
+			if err := ioutil.WriteFile(file, []byte(test.config), 0600); err != nil {
+				t.Fatalf("WriteFile() = %v", err)
+			}
+			config, err := loadWatchConfig(file)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("loadWatchConfig() = %v, want an error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadWatchConfig() = %v", err)
+			}
+			if diff := cmp.Diff(test.want, config.Targets); diff != "" {
+				t.Errorf("targets (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestSetDefaultsCopiesTheFlags(t *testing.T) {
+	setFlags(t)
+	var a, b watchTarget
+	a.setDefaults()
+	b.setDefaults()
+	*a.TrustOnFirstUse = false
+	if !*b.TrustOnFirstUse || !trustFirstUse {
+		t.Error("turning off trust on first use for one target turned it off for others")
+	}
+}
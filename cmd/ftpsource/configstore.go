@@ -1,11 +1,12 @@
 // Simple abstraction for storing state on a k8s ConfigMap. Very very simple
 // and uses a single entry in the ConfigMap.data for storing serialized
-// JSON of the generic data that Load/Save uses.
+// JSON of the state.
 package main
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -14,155 +15,178 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
 	kubeclient "knative.dev/pkg/client/injection/kube/client"
-	"knative.dev/pkg/configmap"
 	"knative.dev/pkg/logging"
 	"knative.dev/pkg/system"
 )
 
 const (
 	configdatakey = "configdata"
+	// The key of the host keys trusted by the target configured by the
+	// flags, which can't be the key of any other target.
+	hostKeysKey = configdatakey + "._hostkeys"
 )
 
-var (
-	storeName = "sftp-store"
-)
-
-type configstore struct {
-	data string
-}
-
-type store struct {
-	*configmap.UntypedStore
+// errStateConflict is returned when saving state that was saved by someone
+// else since it was loaded.
+var errStateConflict = errors.New("state was saved concurrently")
+
+// configMapStore keeps the state in a key of a ConfigMap. Writes are
+// optimistically concurrent: the state is saved with the resourceVersion of
+// the ConfigMap it was loaded from. When other keys have been saved since,
+// the state is saved next to them, but when its own key has, saving fails
+// with errStateConflict rather than clobbering it. ConfigMaps are limited to
+// 1MiB, so this doesn't scale to very large directories.
+type configMapStore struct {
+	client typedcorev1.ConfigMapInterface
+	name   string
+	key    string
 }
 
-type cfgKey struct{}
-
-func NewSFTPStoreConfigFromConfigMap(config *corev1.ConfigMap) (*configstore, error) {
-	if config == nil {
-		return nil, fmt.Errorf("config is nil")
-	}
-	return newConfigFromMap(config.Data)
-}
+var _ keyedStore = (*configMapStore)(nil)
 
-// newConfigFromMap returns a Config given a map corresponding to a ConfigMap
-func newConfigFromMap(cfgMap map[string]string) (*configstore, error) {
-	data, ok := cfgMap[configdatakey]
-	if !ok {
-		return nil, fmt.Errorf("config data not present")
+// newConfigMapStore returns a store keeping state in the named ConfigMap,
+// creating it if needed. This can fail during startup while Istio sidecar is
+// not ready yet for a little while (~seconds), and if the ServiceAccount we
+// run as is not configured properly, so we make sure things are right before
+// proceeding.
+func newConfigMapStore(ctx context.Context, name string) (*configMapStore, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing ConfigMap name")
 	}
-	return &configstore{data}, nil
-}
-
-// Initialize ConfigStore. Basically ensures that we can create or get
-// the initial configmap. This can fail during startup while Istio
-// sidecar is not ready yet for a little while (~seconds), and if the
-// ServiceAccount we run as is not configured properly, so we make sure
-// things are right before proceeding.
-func initConfigStore(ctx context.Context, name string) error {
-	//override default store name
-	if name != "" {
-		storeName = name
+	s := &configMapStore{
+		client: kubeclient.Get(ctx).CoreV1().ConfigMaps(system.Namespace()),
+		name:   name,
+		key:    configdatakey,
 	}
 
 	logger := logging.FromContext(ctx)
-	logger.Info("Initializing ConfigStore...")
-
-	err := loadConfigMapData(ctx)
+	logger.Info("Initializing ConfigMap store...")
+	err := wait.PollImmediate(1*time.Second, 30*time.Second, func() (bool, error) {
+		_, err := s.client.Get(ctx, s.name, metav1.GetOptions{})
+		if err == nil || apierrors.IsNotFound(err) {
+			return true, err
+		}
+		logger.Info("Waiting to load config map:", zap.Error(err))
+		return false, nil
+	})
 	switch {
 	case err == nil:
 		logger.Info("Config loaded succsesfully")
 	case apierrors.IsNotFound(err):
-		if err := createEmptyConfigMapData(ctx); err != nil {
-			logger.Error("Failed to create empty configmap", zap.Error(err))
-			return err
+		if err := s.create(ctx); err != nil && !apierrors.IsAlreadyExists(err) {
+			return nil, fmt.Errorf("creating ConfigMap: %w", err)
 		}
 	default:
-		logger.Error("Failed to load configmap data:", zap.Error(err))
+		return nil, fmt.Errorf("loading ConfigMap: %w", err)
 	}
-
-	return nil
+	return s, nil
 }
 
-// loadConfigMapData loads the ConfigMap and grabs the configmapkey value from
-// the map that contains our state.
-func loadConfigMapData(ctx context.Context) error {
-	cmClient := kubeclient.Get(ctx).CoreV1().ConfigMaps(system.Namespace())
-	if err := wait.PollImmediate(1*time.Second, 30*time.Second, func() (bool, error) {
-		var err error
-		_, err = cmClient.Get(ctx, storeName, metav1.GetOptions{})
-		if err == nil || apierrors.IsNotFound(err) {
-			return true, err
-		}
-		logging.FromContext(ctx).Info("Waiting to load config map:", zap.Error(err))
-		return false, nil
-	}); err != nil {
-		return err
-	}
-	return nil
+// withKey implements keyedStore, keeping the state in another key of the
+// ConfigMap.
+func (s *configMapStore) withKey(key string) sharedStore {
+	c := *s
+	c.key = key
+	return &c
 }
 
-func createEmptyConfigMapData(ctx context.Context) error {
-	cmClient := kubeclient.Get(ctx).CoreV1().ConfigMaps(system.Namespace())
-	bytes, err := json.Marshal(configdata{})
+func (s *configMapStore) create(ctx context.Context) error {
+	bytes, err := json.Marshal(configdata{Files: map[string]fileState{}})
 	if err != nil {
-		logging.FromContext(ctx).Error("Failed to Marshal:", zap.Error(err))
 		return err
 	}
 	cm := &corev1.ConfigMap{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: "v1",
-			Kind:       "ConfigMap",
-		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name: storeName,
+			Name: s.name,
 		},
-		Data: map[string]string{configdatakey: string(bytes)},
+		Data: map[string]string{s.key: string(bytes)},
 	}
-	_, err = cmClient.Create(ctx, cm, metav1.CreateOptions{})
+	_, err = s.client.Create(ctx, cm, metav1.CreateOptions{})
 	return err
 }
 
-// save takes the value given in, and marshals it into a string
-// and saves it into the k8s ConfigMap under the configdatakey.
-func (cs *configstore) save(ctx context.Context, value interface{}) error {
-	bytes, err := json.Marshal(value)
+// Load implements StateStore.
+func (s *configMapStore) Load(ctx context.Context) (*configdata, error) {
+	cm, err := s.client.Get(ctx, s.name, metav1.GetOptions{})
 	if err != nil {
-		logging.FromContext(ctx).Error("Failed to Marshal", zap.Error(err))
-		return err
+		return nil, err
+	}
+	data, err := unmarshalState([]byte(cm.Data[s.key]))
+	if err != nil {
+		return nil, err
 	}
-	cs.data = string(bytes)
-	return cs.saveConfigMapData(ctx)
+	data.source = cm
+	return data, nil
 }
 
-// saveConfigMapData saves the ConfigMap with the data from configstore.data
-// stored in the configmapkey value of that map.
-func (cs *configstore) saveConfigMapData(ctx context.Context) error {
-	cmClient := kubeclient.Get(ctx).CoreV1().ConfigMaps(system.Namespace())
-	cm, err := cmClient.Get(ctx, storeName, metav1.GetOptions{})
+// Save implements StateStore. On conflict, the ConfigMap is read again, and
+// the update retried as long as it's only other keys that have changed.
+func (s *configMapStore) Save(ctx context.Context, data *configdata) error {
+	bytes, err := json.Marshal(data)
 	if err != nil {
-		logging.FromContext(ctx).Error("Failed to save CM:", zap.Error(err))
 		return err
 	}
-	cm.Data[configdatakey] = cs.data
-	_, err = cmClient.Update(ctx, cm, metav1.UpdateOptions{})
-	return err
-}
-
-// NewConfigStore creates a new configuration Store.
-func NewConfigStore(logger configmap.Logger) *store {
-	return &store{
-		UntypedStore: configmap.NewUntypedStore(
-			"ftpsource",
-			logger,
-			configmap.Constructors{
-				storeName: NewSFTPStoreConfigFromConfigMap,
-			},
-		),
+	cm, ok := data.source.(*corev1.ConfigMap)
+	if !ok {
+		// The state wasn't loaded from us, save it over the state that's
+		// there now.
+		if cm, err = s.client.Get(ctx, s.name, metav1.GetOptions{}); err != nil {
+			return err
+		}
 	}
+	loaded := cm.Data[s.key]
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		if cm.Data[s.key] != loaded {
+			return fmt.Errorf("%w: %s/%s changed since it was loaded", errStateConflict, s.name, s.key)
+		}
+		update := cm.DeepCopy()
+		if update.Data == nil {
+			update.Data = make(map[string]string, 1)
+		}
+		update.Data[s.key] = string(bytes)
+		saved, err := s.client.Update(ctx, update, metav1.UpdateOptions{})
+		if apierrors.IsConflict(err) {
+			// Check whether it's our key that was saved in the
+			// meantime, or another one we can save next to.
+			var getErr error
+			if cm, getErr = s.client.Get(ctx, s.name, metav1.GetOptions{}); getErr != nil {
+				return getErr
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		data.source = saved
+		return nil
+	})
 }
 
-// Load returns the current state of config
-func (s *store) Load() *configstore {
-	return s.UntypedLoad(storeName).(*configstore)
+// update implements sharedStore, reading the ConfigMap again and retrying
+// when it was updated concurrently.
+func (s *configMapStore) update(ctx context.Context, fn func(*configdata)) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		cm, err := s.client.Get(ctx, s.name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		data, err := unmarshalState([]byte(cm.Data[s.key]))
+		if err != nil {
+			return err
+		}
+		fn(data)
+		bytes, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = make(map[string]string, 1)
+		}
+		cm.Data[s.key] = string(bytes)
+		_, err = s.client.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
 }
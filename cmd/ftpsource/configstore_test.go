@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// newFakeConfigMaps returns a fake clientset with the ConfigMap, which, like
+// the API server, only updates ConfigMaps at their current resourceVersion.
+func newFakeConfigMaps(cm *corev1.ConfigMap) *fake.Clientset {
+	client := fake.NewSimpleClientset(cm)
+	gvr := corev1.SchemeGroupVersion.WithResource("configmaps")
+	client.PrependReactor("update", "configmaps", func(action ktesting.Action) (bool, runtime.Object, error) {
+		cm := action.(ktesting.UpdateAction).GetObject().(*corev1.ConfigMap).DeepCopy()
+		current, err := client.Tracker().Get(gvr, cm.Namespace, cm.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		if rv := current.(*corev1.ConfigMap).ResourceVersion; rv != cm.ResourceVersion {
+			return true, nil, apierrors.NewConflict(gvr.GroupResource(), cm.Name,
+				fmt.Errorf("resourceVersion is %s, not %s", rv, cm.ResourceVersion))
+		}
+		rv, _ := strconv.Atoi(cm.ResourceVersion)
+		cm.ResourceVersion = strconv.Itoa(rv + 1)
+		return true, cm, client.Tracker().Update(gvr, cm, cm.Namespace)
+	})
+	return client
+}
+
+func TestConfigMapStore(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeConfigMaps(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "state", Namespace: "default", ResourceVersion: "1"},
+	})
+	store := &configMapStore{client: client.CoreV1().ConfigMaps("default"), name: "state", key: "a"}
+	other := store.withKey("b")
+	again := store.withKey("a")
+
+	load := func(s StateStore) *configdata {
+		t.Helper()
+		data, err := s.Load(ctx)
+		if err != nil {
+			t.Fatalf("Load() = %v", err)
+		}
+		return data
+	}
+	save := func(s StateStore, data *configdata, files ...string) error {
+		t.Helper()
+		data.Files = make(map[string]fileState, len(files))
+		for _, name := range files {
+			data.Files[name] = fileState{Size: 1, ModTime: t0}
+		}
+		return s.Save(ctx, data)
+	}
+	files := func(s StateStore) []string {
+		t.Helper()
+		var names []string
+		for name := range load(s).Files {
+			names = append(names, name)
+		}
+		return names
+	}
+
+	// No state yet.
+	data := load(store)
+	if data.Files != nil {
+		t.Errorf("Files = %v, want none", data.Files)
+	}
+
+	// The watcher sharing the ConfigMap saves its own state in the
+	// meantime, which our state is saved next to.
+	if err := save(other, load(other), "b.csv"); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+	if err := save(store, data, "a.csv"); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+	if diff := cmp.Diff([]string{"a.csv"}, files(store)); diff != "" {
+		t.Errorf("Files (-want, +got): %s", diff)
+	}
+	if diff := cmp.Diff([]string{"b.csv"}, files(other)); diff != "" {
+		t.Errorf("Files of the other key (-want, +got): %s", diff)
+	}
+
+	// State can be saved again after saving it.
+	if err := save(store, data, "a2.csv"); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+
+	// Someone else saves our state in the meantime, which is kept.
+	stale := load(store)
+	if err := save(again, load(again), "c.csv"); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+	if err := save(store, stale, "a3.csv"); !errors.Is(err, errStateConflict) {
+		t.Fatalf("Save() = %v, want %v", err, errStateConflict)
+	}
+	if diff := cmp.Diff([]string{"c.csv"}, files(store)); diff != "" {
+		t.Errorf("Files (-want, +got): %s", diff)
+	}
+	if diff := cmp.Diff([]string{"b.csv"}, files(other)); diff != "" {
+		t.Errorf("Files of the other key (-want, +got): %s", diff)
+	}
+}
+
+func TestConfigMapStoreUpdate(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeConfigMaps(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "state", Namespace: "default", ResourceVersion: "1"},
+	})
+	store := &configMapStore{client: client.CoreV1().ConfigMaps("default"), name: "state", key: "a"}
+	other := store.withKey("a")
+
+	calls := 0
+	err := store.update(ctx, func(data *configdata) {
+		calls++
+		if calls == 1 {
+			// Another shard saves its file in the meantime.
+			if err := other.update(ctx, func(data *configdata) {
+				data.Files = map[string]fileState{"y/b.csv": {Size: 1, ModTime: t0}}
+			}); err != nil {
+				t.Fatalf("update() = %v", err)
+			}
+		}
+		if data.Files == nil {
+			data.Files = make(map[string]fileState)
+		}
+		data.Files["x/a.csv"] = fileState{Size: 1, ModTime: t0}
+	})
+	if err != nil {
+		t.Fatalf("update() = %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("update() called fn %d times, want 2", calls)
+	}
+	data, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	var got []string
+	for name := range data.Files {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	if diff := cmp.Diff([]string{"x/a.csv", "y/b.csv"}, got); diff != "" {
+		t.Errorf("Files (-want, +got): %s", diff)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/metrics"
+)
+
+// connection keeps a long lived connection to the server, so we don't have
+// to dial the server for every probe. The connection is health checked before
+// it's handed out and kept alive in between probes. When it drops, we
+// reconnect with exponential backoff, so we don't hammer a server that's
+// down or rate limiting us. The connection can be shared by the watchers of
+// several directories, possibly of different shards, and is only closed once
+// none of them is running.
+type connection struct {
+	dial func(context.Context) (remote, error)
+	// Interval between keepalives, 0 disables them.
+	keepalive time.Duration
+	backoff   wait.Backoff
+
+	mu     sync.Mutex
+	client remote
+	// Closed to stop the keepalives of client.
+	stop chan struct{}
+	// Backoff state since the last successful dial.
+	retry    wait.Backoff
+	nextDial time.Time
+	// Whether we were connected before, so dialing again is a reconnect.
+	connected bool
+	// The number of running watchers using the connection.
+	users int
+}
+
+func newConnection(dial func(context.Context) (remote, error), keepalive, maxBackoff time.Duration) *connection {
+	backoff := wait.Backoff{
+		Duration: time.Second,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    math.MaxInt32,
+		Cap:      maxBackoff,
+	}
+	return &connection{
+		dial:      dial,
+		keepalive: keepalive,
+		backoff:   backoff,
+		retry:     backoff,
+	}
+}
+
+// get returns the connection to the server, reconnecting if it has dropped.
+// The watchers sharing the connection wait for each other's reconnects, which
+// the dialers bound with dialTimeout.
+func (c *connection) get(ctx context.Context) (remote, error) {
+	logger := logging.FromContext(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client != nil {
+		err := c.client.keepalive()
+		if err == nil {
+			return c.client, nil
+		}
+		logger.Warn("Connection lost, reconnecting:", zap.Error(err))
+		c.closeLocked()
+	}
+
+	if delay := time.Until(c.nextDial); delay > 0 {
+		return nil, fmt.Errorf("reconnecting in %v", delay.Round(time.Second))
+	}
+	client, err := c.dial(ctx)
+	if err != nil {
+		c.nextDial = time.Now().Add(c.retry.Step())
+		return nil, err
+	}
+	c.retry = c.backoff
+	if c.connected {
+		metrics.Record(ctx, reconnectsM.M(1))
+	}
+	c.connected = true
+	c.client = client
+	c.stop = make(chan struct{})
+	if c.keepalive > 0 {
+		go c.keepalives(ctx, client, c.stop)
+	}
+	return client, nil
+}
+
+// keepalives keeps client alive until it's closed, closing it if it drops.
+func (c *connection) keepalives(ctx context.Context, client remote, stop chan struct{}) {
+	ticker := time.NewTicker(c.keepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := client.keepalive(); err != nil {
+				logging.FromContext(ctx).Warn("Keepalive failed, closing connection:", zap.Error(err))
+				c.mu.Lock()
+				if c.client == client {
+					c.closeLocked()
+				}
+				c.mu.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// acquire records that a watcher started using the connection.
+func (c *connection) acquire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.users++
+}
+
+// release records that a watcher stopped using the connection, closing it
+// once the last one has.
+func (c *connection) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.users--
+	if c.users == 0 && c.client != nil {
+		c.closeLocked()
+	}
+}
+
+func (c *connection) closeLocked() {
+	close(c.stop)
+	c.client.Close()
+	c.client = nil
+}
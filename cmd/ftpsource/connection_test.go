@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// closingRemote is a remote recording whether it was closed.
+type closingRemote struct {
+	fakeRemote
+	closed bool
+}
+
+func (r *closingRemote) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestConnectionSharedUntilReleased(t *testing.T) {
+	ctx := context.Background()
+	var dialed []*closingRemote
+	c := newConnection(func(context.Context) (remote, error) {
+		r := &closingRemote{}
+		dialed = append(dialed, r)
+		return r, nil
+	}, 0, time.Second)
+
+	// The watchers of two shards share the connection.
+	c.acquire()
+	c.acquire()
+	first, err := c.get(ctx)
+	if err != nil {
+		t.Fatalf("get() = %v", err)
+	}
+
+	// One of them stops, the other keeps using the connection.
+	c.release()
+	if dialed[0].closed {
+		t.Error("connection closed while a watcher still uses it")
+	}
+	if got, err := c.get(ctx); err != nil || got != first {
+		t.Errorf("get() = %v, %v, want the open connection", got, err)
+	}
+
+	// The last one stops.
+	c.release()
+	if !dialed[0].closed {
+		t.Error("connection still open after the last watcher stopped")
+	}
+
+	// Starting again dials again.
+	c.acquire()
+	if _, err := c.get(ctx); err != nil {
+		t.Fatalf("get() = %v", err)
+	}
+	if len(dialed) != 2 {
+		t.Errorf("dialed %d times, want 2", len(dialed))
+	}
+}
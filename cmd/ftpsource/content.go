@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+)
+
+// The encodings we can inline the content of files with.
+const (
+	// The content is the data of the event, with the content type of the
+	// file as its datacontenttype.
+	inlineBinary = "binary"
+	// The content is base64 encoded into the JSON data of the event.
+	inlineBase64 = "base64"
+)
+
+// Extensions describing the file whose content is the data of the event,
+// since there's no room for them in the data.
+const (
+	extensionPath    = "ftppath"
+	extensionSize    = "ftpsize"
+	extensionModTime = "ftpmodtime"
+)
+
+// inlineContent is how we embed the content of small files in their events,
+// so that consumers don't need credentials for the server to read them.
+type inlineContent struct {
+	// Files up to maxSize bytes are inlined, none if 0.
+	maxSize  int64
+	encoding string
+}
+
+func newInlineContent(maxSize int64, encoding string) (inlineContent, error) {
+	switch {
+	case maxSize < 0:
+		return inlineContent{}, fmt.Errorf("invalid maximum size to inline %d", maxSize)
+	case encoding != inlineBinary && encoding != inlineBase64:
+		return inlineContent{}, fmt.Errorf("unknown inline encoding %q", encoding)
+	}
+	return inlineContent{maxSize: maxSize, encoding: encoding}, nil
+}
+
+// applies returns true if the content of the file should be inlined. f is
+// nil for files that are no longer on the server.
+func (c inlineContent) applies(e os.FileInfo, f *remoteFile) bool {
+	return c.maxSize > 0 && f != nil && e.Size() <= c.maxSize
+}
+
+// read downloads the content of the file. Returns nil if the file has grown
+// past the maximum size since it was listed, in which case we only send the
+// metadata.
+func (c inlineContent) read(f *remoteFile) ([]byte, error) {
+	r, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(r, c.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > c.maxSize {
+		return nil, nil
+	}
+	return b, nil
+}
+
+// contentType guesses the media type of the file from its extension, or
+// failing that from its content, if we have it.
+func contentType(name string, content []byte) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	if content == nil {
+		return "application/octet-stream"
+	}
+	return http.DetectContentType(content)
+}
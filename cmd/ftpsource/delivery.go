@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"knative.dev/pkg/logging"
+)
+
+// Extensions describing why an event was sent to the dead letter sink.
+const (
+	// Number of attempts made to send the event to the sink.
+	extensionErrorAttempts = "ftperrorattempts"
+	// Status code of the last response from the sink, if it responded.
+	extensionErrorCode = "ftperrorcode"
+	// Error of the last attempt.
+	extensionError = "ftperror"
+)
+
+// errDeadLettered is returned by send when the sink didn't ACK the event, but
+// the dead letter sink did.
+var errDeadLettered = errors.New("sent to the dead letter sink")
+
+// retryPolicy is how we retry sending an event the sink didn't ACK.
+type retryPolicy struct {
+	// Maximum number of attempts to send an event, including the first.
+	maxAttempts int
+	// Delay before the first retry. With the exponential policy it doubles
+	// for every further retry, with the linear policy it grows by backoff
+	// for every further retry, up to maxBackoff.
+	backoff    time.Duration
+	linear     bool
+	maxBackoff time.Duration
+}
+
+// delay returns how long to wait before the given retry, counting from 1.
+func (r retryPolicy) delay(retry int) time.Duration {
+	d := r.backoff
+	for i := 1; i < retry && (r.maxBackoff <= 0 || d < r.maxBackoff); i++ {
+		if r.linear {
+			d += r.backoff
+		} else {
+			d *= 2
+		}
+	}
+	if r.maxBackoff > 0 && d > r.maxBackoff {
+		d = r.maxBackoff
+	}
+	// Spread out the retries of many sources failing at the same time.
+	return wait.Jitter(d, 0.5)
+}
+
+// send sends the event to the sink, retrying with backoff until the sink
+// ACKs it or the attempts run out. Every attempt sends the same event, with
+// the same ID, so the sink can tell retries apart from new events. Returns
+// the result of the last attempt, or errDeadLettered once the dead letter
+// sink ACKed the event instead.
+func (p *publisher) send(ctx context.Context, event cloudevents.Event) error {
+	logger := logging.FromContext(ctx)
+	for attempt := 1; ; attempt++ {
+		result := p.ceClient.Send(ctx, event)
+		if cloudevents.IsACK(result) {
+			return nil
+		}
+		recordSendFailure(ctx, result)
+		if attempt >= p.retry.maxAttempts || !retriable(result) {
+			logger.Error("Failed to send cloudevent, giving up:", zap.String("id", event.ID()), zap.Int("attempts", attempt), zap.Error(result))
+			if p.deadLetter == nil {
+				return result
+			}
+			return p.sendDeadLetter(ctx, event, attempt, result)
+		}
+
+		delay := p.retry.delay(attempt)
+		logger.Warn("Failed to send cloudevent, retrying:", zap.String("id", event.ID()), zap.Int("attempt", attempt), zap.Duration("backoff", delay), zap.Error(result))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// sendDeadLetter sends an event that failed to the dead letter sink, along
+// with extensions describing the failure. Once the dead letter sink ACKs it,
+// errDeadLettered is returned: the event won't be sent again, but the sink
+// never got it.
+func (p *publisher) sendDeadLetter(ctx context.Context, event cloudevents.Event, attempts int, cause error) error {
+	logger := logging.FromContext(ctx)
+	event = event.Clone()
+	event.SetExtension(extensionErrorAttempts, attempts)
+	event.SetExtension(extensionError, cause.Error())
+	var httpResult *cehttp.Result
+	if cloudevents.ResultAs(cause, &httpResult) {
+		event.SetExtension(extensionErrorCode, httpResult.StatusCode)
+	}
+
+	if result := p.deadLetter.Send(ctx, event); !cloudevents.IsACK(result) {
+		recordSendFailure(ctx, result)
+		logger.Error("Failed to send cloudevent to the dead letter sink:", zap.String("id", event.ID()), zap.Error(result))
+		return fmt.Errorf("sending to the dead letter sink: %w, after: %v", result, cause)
+	}
+	logger.Warn("Sent cloudevent to the dead letter sink", zap.String("id", event.ID()))
+	return fmt.Errorf("%w, after: %v", errDeadLettered, cause)
+}
+
+// retriable returns true if sending again may succeed. Anything but a
+// response from the sink might be transient, as are the responses of
+// overloaded or not yet ready sinks. Other client errors are permanent.
+func retriable(result error) bool {
+	var httpResult *cehttp.Result
+	if !cloudevents.ResultAs(result, &httpResult) {
+		return true
+	}
+	switch code := httpResult.StatusCode; {
+	case code >= http.StatusInternalServerError:
+		return true
+	case code == http.StatusNotFound, code == http.StatusRequestTimeout, code == http.StatusConflict, code == http.StatusTooManyRequests:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+)
+
+// fakeSink is a cloudevents.Client answering every event with the next of
+// its results, and the last one once they run out.
+type fakeSink struct {
+	results []cloudevents.Result
+	sent    []cloudevents.Event
+}
+
+func (s *fakeSink) Send(ctx context.Context, event cloudevents.Event) cloudevents.Result {
+	s.sent = append(s.sent, event)
+	result := s.results[0]
+	if len(s.results) > 1 {
+		s.results = s.results[1:]
+	}
+	return result
+}
+
+func (s *fakeSink) Request(ctx context.Context, event cloudevents.Event) (*cloudevents.Event, cloudevents.Result) {
+	return nil, s.Send(ctx, event)
+}
+
+func (s *fakeSink) StartReceiver(ctx context.Context, fn interface{}) error {
+	return nil
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy retryPolicy
+		// The delay of every retry, before jitter.
+		want []time.Duration
+	}{{
+		name:   "exponential",
+		policy: retryPolicy{backoff: time.Second},
+		want:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+	}, {
+		name:   "exponential up to the maximum",
+		policy: retryPolicy{backoff: time.Second, maxBackoff: 5 * time.Second},
+		want:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second},
+	}, {
+		name:   "linear",
+		policy: retryPolicy{backoff: time.Second, linear: true},
+		want:   []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second},
+	}, {
+		name:   "linear up to the maximum",
+		policy: retryPolicy{backoff: time.Second, linear: true, maxBackoff: 2500 * time.Millisecond},
+		want:   []time.Duration{time.Second, 2 * time.Second, 2500 * time.Millisecond, 2500 * time.Millisecond},
+	}, {
+		name:   "first delay over the maximum",
+		policy: retryPolicy{backoff: time.Minute, maxBackoff: time.Second},
+		want:   []time.Duration{time.Second, time.Second},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i, want := range test.want {
+				retry := i + 1
+				// Jitter adds up to half of the delay.
+				if got := test.policy.delay(retry); got < want || got >= want+want/2 {
+					t.Errorf("delay(%d) = %v, want in [%v, %v)", retry, got, want, want+want/2)
+				}
+			}
+		})
+	}
+}
+
+func TestRetriable(t *testing.T) {
+	tests := []struct {
+		name   string
+		result error
+		want   bool
+	}{
+		{"connection refused", errors.New("connection refused"), true},
+		{"bad request", cehttp.NewResult(http.StatusBadRequest, "bad"), false},
+		{"unauthorized", cehttp.NewResult(http.StatusUnauthorized, "unauthorized"), false},
+		{"not found", cehttp.NewResult(http.StatusNotFound, "not ready"), true},
+		{"request timeout", cehttp.NewResult(http.StatusRequestTimeout, "timeout"), true},
+		{"conflict", cehttp.NewResult(http.StatusConflict, "conflict"), true},
+		{"payload too large", cehttp.NewResult(http.StatusRequestEntityTooLarge, "too large"), false},
+		{"too many requests", cehttp.NewResult(http.StatusTooManyRequests, "slow down"), true},
+		{"internal server error", cehttp.NewResult(http.StatusInternalServerError, "oops"), true},
+		{"service unavailable", cehttp.NewResult(http.StatusServiceUnavailable, "unavailable"), true},
+		{"wrapped", fmt.Errorf("sending: %w", cehttp.NewResult(http.StatusBadRequest, "bad")), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := retriable(test.result); got != test.want {
+				t.Errorf("retriable() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	badRequest := cehttp.NewResult(http.StatusBadRequest, "bad")
+	unavailable := cehttp.NewResult(http.StatusServiceUnavailable, "unavailable")
+	tests := []struct {
+		name         string
+		sink         []cloudevents.Result
+		deadLetter   []cloudevents.Result // no dead letter sink if nil
+		want         error
+		wantAttempts int
+	}{{
+		name:         "ACKed",
+		sink:         []cloudevents.Result{cloudevents.ResultACK},
+		wantAttempts: 1,
+	}, {
+		name:         "ACKed after retrying",
+		sink:         []cloudevents.Result{unavailable, unavailable, cloudevents.ResultACK},
+		wantAttempts: 3,
+	}, {
+		name:         "attempts run out",
+		sink:         []cloudevents.Result{unavailable},
+		want:         unavailable,
+		wantAttempts: 3,
+	}, {
+		name:         "not retriable",
+		sink:         []cloudevents.Result{badRequest},
+		want:         badRequest,
+		wantAttempts: 1,
+	}, {
+		name:         "dead lettered",
+		sink:         []cloudevents.Result{badRequest},
+		deadLetter:   []cloudevents.Result{cloudevents.ResultACK},
+		want:         errDeadLettered,
+		wantAttempts: 1,
+	}, {
+		name:         "dead letter sink down too",
+		sink:         []cloudevents.Result{unavailable},
+		deadLetter:   []cloudevents.Result{unavailable},
+		want:         unavailable,
+		wantAttempts: 3,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sink := &fakeSink{results: test.sink}
+			p := &publisher{ceClient: sink, retry: retryPolicy{maxAttempts: 3, backoff: time.Millisecond}}
+			var deadLetter *fakeSink
+			if test.deadLetter != nil {
+				deadLetter = &fakeSink{results: test.deadLetter}
+				p.deadLetter = deadLetter
+			}
+			event := cloudevents.NewEvent()
+			event.SetID("1")
+
+			err := p.send(context.Background(), event)
+			if test.want == nil && err != nil || test.want != nil && !errors.Is(err, test.want) {
+				t.Errorf("send() = %v, want %v", err, test.want)
+			}
+			if got := len(sink.sent); got != test.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, test.wantAttempts)
+			}
+			if deadLetter == nil || len(deadLetter.sent) == 0 {
+				return
+			}
+			// The dead letter event says why it failed.
+			dl := deadLetter.sent[0]
+			if got := dl.Extensions()[extensionErrorAttempts]; got != int32(test.wantAttempts) {
+				t.Errorf("%s = %v, want %d", extensionErrorAttempts, got, test.wantAttempts)
+			}
+			if _, ok := dl.Extensions()[extensionErrorCode]; !ok {
+				t.Errorf("%s missing", extensionErrorCode)
+			}
+		})
+	}
+}
+
+func TestSendCancelledWhileBackingOff(t *testing.T) {
+	sink := &fakeSink{results: []cloudevents.Result{cehttp.NewResult(http.StatusServiceUnavailable, "unavailable")}}
+	p := &publisher{ceClient: sink, retry: retryPolicy{maxAttempts: 10, backoff: time.Hour}}
+	stop := make(chan struct{})
+	w := &watcher{stop: stop}
+	ctx, cancel := w.untilStopped(context.Background())
+	defer cancel()
+
+	errc := make(chan error)
+	go func() {
+		errc <- p.send(ctx, cloudevents.NewEvent())
+	}()
+	close(stop)
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("send() = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("send() still backing off after the watcher was stopped")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// The attributes of events that are not extensions, which can't be set as
+// static extensions.
+var reservedAttributes = map[string]bool{
+	"specversion":     true,
+	"id":              true,
+	"source":          true,
+	"type":            true,
+	"subject":         true,
+	"time":            true,
+	"datacontenttype": true,
+	"dataschema":      true,
+	"data":            true,
+}
+
+// Names of extensions may only hold lower case letters and digits.
+var extensionName = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// eventAttributes are the attributes of the events we send that can be
+// configured per source, so that different teams can route on them.
+type eventAttributes struct {
+	// typePrefix replaces defaultTypePrefix in the event types.
+	typePrefix string
+	source     string
+	// Extensions set on every event. Our own extensions, and the ones of
+	// the CloudEvent overrides, take precedence over them.
+	extensions map[string]string
+}
+
+// sourceData is what the source template is executed with.
+type sourceData struct {
+	// Server is the host:port of the server, split into Host and Port.
+	Server string
+	Host   string
+	Port   string
+	// Directory is the watched directory.
+	Directory string
+	Protocol  string
+}
+
+// newEventAttributes returns the event attributes. The source is
+// //<server>/<dir> unless a template is given, and extensions are given as
+// name=value.
+func newEventAttributes(typePrefix, sourceTemplate string, extensions []string, data sourceData) (*eventAttributes, error) {
+	a := &eventAttributes{
+		typePrefix: strings.TrimSuffix(typePrefix, "."),
+		//adding slashes to avoid parse url errors
+		source:     "//" + path.Join(data.Server, data.Directory),
+		extensions: make(map[string]string, len(extensions)),
+	}
+	if a.typePrefix == "" {
+		return nil, fmt.Errorf("missing event type prefix")
+	}
+
+	if sourceTemplate != "" {
+		data.Host, data.Port, _ = net.SplitHostPort(data.Server)
+		t, err := template.New("source").Option("missingkey=error").Parse(sourceTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source template: %w", err)
+		}
+		var b bytes.Buffer
+		if err := t.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("executing source template: %w", err)
+		}
+		a.source = b.String()
+	}
+	if _, err := url.Parse(a.source); err != nil || a.source == "" {
+		return nil, fmt.Errorf("invalid source %q, must be a URI reference", a.source)
+	}
+
+	for _, e := range extensions {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid extension %q, must be name=value", e)
+		}
+		name := parts[0]
+		if !extensionName.MatchString(name) || reservedAttributes[name] {
+			return nil, fmt.Errorf("invalid extension name %q, must be lower case letters and digits, and not a reserved attribute", name)
+		}
+		a.extensions[name] = parts[1]
+	}
+	return a, nil
+}
+
+// eventType returns the type of events of the given default type.
+func (a *eventAttributes) eventType(t string) string {
+	return a.typePrefix + strings.TrimPrefix(t, defaultTypePrefix)
+}
+
+// apply sets the source and the static extensions of the event.
+func (a *eventAttributes) apply(event *cloudevents.Event) {
+	event.SetSource(a.source)
+	for name, value := range a.extensions {
+		event.SetExtension(name, value)
+	}
+}
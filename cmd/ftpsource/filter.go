@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// stringsFlag is a flag.Value collecting every occurrence of a flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// fileFilter decides which files we send events for. A file is selected if
+// it matches at least one of the include patterns (or there are none), and
+// none of the exclude patterns.
+//
+// Glob patterns containing a "/" are matched against the path of the file
+// relative to the watched directory, other globs against the base name of
+// the file, so "*.tmp" excludes temporary files in every subdirectory.
+// Regular expressions are always matched against the relative path.
+type fileFilter struct {
+	include      []string
+	exclude      []string
+	includeRegex []*regexp.Regexp
+	excludeRegex []*regexp.Regexp
+}
+
+func newFileFilter(include, exclude, includeRegex, excludeRegex []string) (*fileFilter, error) {
+	for _, p := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", p, err)
+		}
+	}
+	f := &fileFilter{include: include, exclude: exclude}
+	var err error
+	if f.includeRegex, err = compileRegexes(includeRegex); err != nil {
+		return nil, err
+	}
+	if f.excludeRegex, err = compileRegexes(excludeRegex); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func compileRegexes(exprs []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", expr, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// matches returns true if events should be sent for the file at the given
+// path relative to the watched directory.
+func (f *fileFilter) matches(rel string) bool {
+	if len(f.include)+len(f.includeRegex) > 0 && !matchGlobs(f.include, rel) && !matchRegexes(f.includeRegex, rel) {
+		return false
+	}
+	return !matchGlobs(f.exclude, rel) && !matchRegexes(f.excludeRegex, rel)
+}
+
+func matchGlobs(globs []string, rel string) bool {
+	for _, g := range globs {
+		name := rel
+		if !strings.Contains(g, "/") {
+			name = path.Base(rel)
+		}
+		// The patterns have been validated in newFileFilter.
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchRegexes(res []*regexp.Regexp, rel string) bool {
+	for _, re := range res {
+		if re.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package main
+
+import "testing"
+
+func TestFileFilterMatches(t *testing.T) {
+	tests := []struct {
+		name                 string
+		include, exclude     []string
+		includeRe, excludeRe []string
+		matches              map[string]bool
+	}{{
+		name: "no filters",
+		matches: map[string]bool{
+			"a.csv":     true,
+			"sub/b.tmp": true,
+		},
+	}, {
+		name:    "include glob matches the base name",
+		include: []string{"*.csv"},
+		matches: map[string]bool{
+			"a.csv":       true,
+			"sub/b.csv":   true,
+			"a.tmp":       false,
+			"csv/a.json":  false,
+			"sub/a.csv.x": false,
+		},
+	}, {
+		name:    "exclude glob matches the base name",
+		exclude: []string{"*.tmp"},
+		matches: map[string]bool{
+			"a.csv":     true,
+			"a.tmp":     false,
+			"sub/b.tmp": false,
+		},
+	}, {
+		name:    "glob with a slash matches the relative path",
+		include: []string{"in/*.csv"},
+		matches: map[string]bool{
+			"in/a.csv":     true,
+			"a.csv":        false,
+			"out/a.csv":    false,
+			"in/sub/a.csv": false,
+		},
+	}, {
+		name:    "any include",
+		include: []string{"*.csv", "*.json"},
+		matches: map[string]bool{
+			"a.csv":  true,
+			"a.json": true,
+			"a.xml":  false,
+		},
+	}, {
+		name:    "exclude wins over include",
+		include: []string{"*.csv"},
+		exclude: []string{"tmp-*"},
+		matches: map[string]bool{
+			"a.csv":     true,
+			"tmp-a.csv": false,
+		},
+	}, {
+		name:      "include regex matches the relative path",
+		includeRe: []string{`^2021/\d+/.*\.csv$`},
+		matches: map[string]bool{
+			"2021/01/a.csv": true,
+			"2020/01/a.csv": false,
+			"a.csv":         false,
+		},
+	}, {
+		name:      "include glob or regex",
+		include:   []string{"*.json"},
+		includeRe: []string{`\.csv$`},
+		matches: map[string]bool{
+			"a.csv":  true,
+			"a.json": true,
+			"a.xml":  false,
+		},
+	}, {
+		name:      "exclude regex",
+		excludeRe: []string{`(^|/)\.`},
+		matches: map[string]bool{
+			"a.csv":        true,
+			".hidden":      false,
+			"sub/.partial": false,
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := newFileFilter(test.include, test.exclude, test.includeRe, test.excludeRe)
+			if err != nil {
+				t.Fatalf("newFileFilter() = %v", err)
+			}
+			for rel, want := range test.matches {
+				if got := f.matches(rel); got != want {
+					t.Errorf("matches(%q) = %v, want %v", rel, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewFileFilterInvalid(t *testing.T) {
+	tests := []struct {
+		name                 string
+		include, exclude     []string
+		includeRe, excludeRe []string
+	}{{
+		name:    "include glob",
+		include: []string{"[a-"},
+	}, {
+		name:    "exclude glob",
+		exclude: []string{"\\"},
+	}, {
+		name:      "include regex",
+		includeRe: []string{"("},
+	}, {
+		name:      "exclude regex",
+		excludeRe: []string{"a**"},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newFileFilter(test.include, test.exclude, test.includeRe, test.excludeRe); err == nil {
+				t.Error("newFileFilter() = nil, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"knative.dev/pkg/logging"
+)
+
+// hostKeyVerifier verifies the host keys of SFTP servers. A host key is
+// accepted if its SHA256 fingerprint is one of the pinned ones, or if the
+// known_hosts files list it for the host. With trust on first use, the key
+// of a host we know nothing else about is recorded in the state store the
+// first time we connect, and from then on only that key is accepted.
+type hostKeyVerifier struct {
+	knownHosts      ssh.HostKeyCallback // nil without known_hosts files
+	fingerprints    map[string]bool
+	trustOnFirstUse bool
+	// Accept any host key. Only meant for testing.
+	insecure bool
+
+	store sharedStore
+	// Where older versions recorded the keys they trusted, if elsewhere.
+	legacy StateStore
+}
+
+func newHostKeyVerifier(knownHostsFiles, fingerprints []string, trustOnFirstUse, insecure bool, store sharedStore) (*hostKeyVerifier, error) {
+	v := &hostKeyVerifier{
+		fingerprints:    make(map[string]bool, len(fingerprints)),
+		trustOnFirstUse: trustOnFirstUse,
+		insecure:        insecure,
+		store:           store,
+	}
+	if len(knownHostsFiles) > 0 {
+		cb, err := knownhosts.New(knownHostsFiles...)
+		if err != nil {
+			return nil, fmt.Errorf("loading known hosts: %w", err)
+		}
+		v.knownHosts = cb
+	}
+	for _, fp := range fingerprints {
+		if !strings.HasPrefix(fp, "SHA256:") {
+			fp = "SHA256:" + fp
+		}
+		v.fingerprints[fp] = true
+	}
+	if !insecure && !trustOnFirstUse && v.knownHosts == nil && len(v.fingerprints) == 0 {
+		return nil, errors.New("no known hosts, host key fingerprints or trust on first use configured to verify host keys with")
+	}
+	return v, nil
+}
+
+// callback returns the ssh.HostKeyCallback to verify host keys with.
+func (v *hostKeyVerifier) callback(ctx context.Context) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if err := v.verify(ctx, hostname, remote, key); err != nil {
+			logging.FromContext(ctx).Error("Host key verification failed, refusing to connect:",
+				zap.String("host", hostname), zap.String("fingerprint", ssh.FingerprintSHA256(key)), zap.Error(err))
+			return err
+		}
+		return nil
+	}
+}
+
+func (v *hostKeyVerifier) verify(ctx context.Context, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if v.insecure {
+		return nil
+	}
+	fp := ssh.FingerprintSHA256(key)
+	if v.fingerprints[fp] {
+		return nil
+	}
+	if v.knownHosts != nil {
+		err := v.knownHosts(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		switch {
+		case err == nil:
+			return nil
+		case errors.As(err, &keyErr) && len(keyErr.Want) > 0:
+			return fmt.Errorf("host key mismatch, %s is not the key known_hosts lists for the host", fp)
+		case !errors.As(err, &keyErr):
+			// The key has been revoked.
+			return err
+		}
+	}
+	if len(v.fingerprints) > 0 || !v.trustOnFirstUse {
+		return fmt.Errorf("unknown host key %s", fp)
+	}
+	return v.trust(ctx, hostname, fp)
+}
+
+// trust checks the fingerprint against the one recorded for the host in the
+// state store, recording it if this is the first time we see the host, or
+// the key older versions trusted for it. The key is recorded with an atomic
+// update, so it isn't lost to someone else saving the state at the same time.
+func (v *hostKeyVerifier) trust(ctx context.Context, hostname, fp string) error {
+	data, err := v.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("loading trusted host keys: %w", err)
+	}
+	trusted, ok := data.HostKeys[hostname]
+	if !ok {
+		before, err := v.trustedBefore(ctx, hostname)
+		if err != nil {
+			return err
+		}
+		if err := v.store.update(ctx, func(data *configdata) {
+			if t, ok := data.HostKeys[hostname]; ok {
+				// Trusted concurrently.
+				trusted = t
+				return
+			}
+			trusted = before
+			if trusted == "" {
+				trusted = fp
+			}
+			if data.HostKeys == nil {
+				data.HostKeys = make(map[string]string, 1)
+			}
+			data.HostKeys[hostname] = trusted
+		}); err != nil {
+			return fmt.Errorf("saving trusted host key: %w", err)
+		}
+		if before == "" && trusted == fp {
+			logging.FromContext(ctx).Info("Trusting host key on first use:", zap.String("host", hostname), zap.String("fingerprint", fp))
+		}
+	}
+	if trusted != fp {
+		return fmt.Errorf("host key mismatch, %s is not the trusted key %s", fp, trusted)
+	}
+	return nil
+}
+
+// trustedBefore returns the key older versions trusted for the host, if any.
+func (v *hostKeyVerifier) trustedBefore(ctx context.Context, hostname string) (string, error) {
+	if v.legacy == nil {
+		return "", nil
+	}
+	data, err := v.legacy.Load(ctx)
+	if err != nil {
+		return "", fmt.Errorf("loading trusted host keys: %w", err)
+	}
+	return data.HostKeys[hostname], nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// memStore is a keyedStore keeping the state in memory.
+type memStore struct {
+	mu   sync.Mutex
+	data []byte
+	keys map[string]*memStore
+}
+
+func (s *memStore) withKey(key string) sharedStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = make(map[string]*memStore)
+	}
+	if s.keys[key] == nil {
+		s.keys[key] = &memStore{}
+	}
+	return s.keys[key]
+}
+
+func (s *memStore) Load(ctx context.Context) (*configdata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return unmarshalState(s.data)
+}
+
+func (s *memStore) Save(ctx context.Context, data *configdata) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = b
+	return nil
+}
+
+func (s *memStore) update(ctx context.Context, fn func(*configdata)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := unmarshalState(s.data)
+	if err != nil {
+		return err
+	}
+	fn(data)
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	s.data = b
+	return nil
+}
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() = %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("NewPublicKey() = %v", err)
+	}
+	return key
+}
+
+func TestHostKeyVerifier(t *testing.T) {
+	const host = "sftp.example.com:22"
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+	known, other, revoked := newHostKey(t), newHostKey(t), newHostKey(t)
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	lines := []string{
+		knownhosts.Line([]string{host}, known),
+		"@revoked " + knownhosts.Line([]string{"*"}, revoked),
+	}
+	if err := ioutil.WriteFile(knownHosts, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		knownHosts      []string
+		fingerprints    []string
+		trustOnFirstUse bool
+		insecure        bool
+		trusted         string // the key trusted on first use
+		key             ssh.PublicKey
+		wantErr         bool
+	}{{
+		name:       "listed in known_hosts",
+		knownHosts: []string{knownHosts},
+		key:        known,
+	}, {
+		name:       "other key than the one in known_hosts",
+		knownHosts: []string{knownHosts},
+		key:        other,
+		wantErr:    true,
+	}, {
+		name:            "mismatch in known_hosts isn't trusted on first use",
+		knownHosts:      []string{knownHosts},
+		trustOnFirstUse: true,
+		key:             other,
+		wantErr:         true,
+	}, {
+		name:            "revoked",
+		knownHosts:      []string{knownHosts},
+		trustOnFirstUse: true,
+		key:             revoked,
+		wantErr:         true,
+	}, {
+		name:         "pinned fingerprint",
+		fingerprints: []string{ssh.FingerprintSHA256(other)},
+		key:          other,
+	}, {
+		name:         "pinned fingerprint without prefix",
+		fingerprints: []string{strings.TrimPrefix(ssh.FingerprintSHA256(other), "SHA256:")},
+		key:          other,
+	}, {
+		name:         "pinned fingerprint next to known_hosts",
+		knownHosts:   []string{knownHosts},
+		fingerprints: []string{ssh.FingerprintSHA256(other)},
+		key:          other,
+	}, {
+		name:         "not pinned",
+		fingerprints: []string{ssh.FingerprintSHA256(known)},
+		key:          other,
+		wantErr:      true,
+	}, {
+		name:            "pinned fingerprints disable trust on first use",
+		fingerprints:    []string{ssh.FingerprintSHA256(known)},
+		trustOnFirstUse: true,
+		key:             other,
+		wantErr:         true,
+	}, {
+		name:            "first use",
+		trustOnFirstUse: true,
+		key:             other,
+	}, {
+		name:            "trusted on first use",
+		trustOnFirstUse: true,
+		trusted:         ssh.FingerprintSHA256(other),
+		key:             other,
+	}, {
+		name:            "other key than the one trusted on first use",
+		trustOnFirstUse: true,
+		trusted:         ssh.FingerprintSHA256(known),
+		key:             other,
+		wantErr:         true,
+	}, {
+		name:     "insecure",
+		insecure: true,
+		key:      other,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := &memStore{}
+			if test.trusted != "" {
+				if err := store.Save(ctx, &configdata{HostKeys: map[string]string{host: test.trusted}}); err != nil {
+					t.Fatalf("Save() = %v", err)
+				}
+			}
+			v, err := newHostKeyVerifier(test.knownHosts, test.fingerprints, test.trustOnFirstUse, test.insecure, store)
+			if err != nil {
+				t.Fatalf("newHostKeyVerifier() = %v", err)
+			}
+			err = v.callback(ctx)(host, remote, test.key)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("callback() = %v, want error: %v", err, test.wantErr)
+			}
+			if err != nil || !test.trustOnFirstUse || len(test.fingerprints) > 0 || len(test.knownHosts) > 0 {
+				return
+			}
+			// The key is now trusted.
+			data, err := store.Load(ctx)
+			if err != nil {
+				t.Fatalf("Load() = %v", err)
+			}
+			if got, want := data.HostKeys[host], ssh.FingerprintSHA256(test.key); got != want {
+				t.Errorf("trusted host key = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestNewHostKeyVerifierNothingToVerifyWith(t *testing.T) {
+	if _, err := newHostKeyVerifier(nil, nil, false, false, &memStore{}); err == nil {
+		t.Error("newHostKeyVerifier() = nil, want an error")
+	}
+}
+
+func TestHostKeyTrustedWhileSavingState(t *testing.T) {
+	const host = "sftp.example.com:22"
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+	key := newHostKey(t)
+	ctx := context.Background()
+	// The target configured by the flags.
+	var target watchTarget
+	s := &supervisor{store: &memStore{}}
+	v, err := newHostKeyVerifier(nil, nil, true, false, s.hostKeyStoreFor(target))
+	if err != nil {
+		t.Fatalf("newHostKeyVerifier() = %v", err)
+	}
+	v.legacy = s.store
+
+	// The watcher of the directory saves its state while the key is
+	// trusted.
+	dir := s.storeFor(target, "/in")
+	data, err := dir.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	errc := make(chan error)
+	go func() {
+		errc <- v.callback(ctx)(host, remote, key)
+	}()
+	data.Files = map[string]fileState{"a.csv": {Size: 1, ModTime: t0}}
+	if err := dir.Save(ctx, data); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("callback() = %v", err)
+	}
+
+	if data, err = dir.Load(ctx); err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if _, ok := data.Files["a.csv"]; !ok {
+		t.Errorf("Files = %v, want a.csv", data.Files)
+	}
+	// The key is trusted from now on.
+	if err := v.callback(ctx)(host, remote, newHostKey(t)); err == nil {
+		t.Error("callback() = nil for another key, want an error")
+	}
+	if err := v.callback(ctx)(host, remote, key); err != nil {
+		t.Errorf("callback() = %v", err)
+	}
+}
+
+func TestHostKeyTrustedByOlderVersions(t *testing.T) {
+	const host = "sftp.example.com:22"
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+	trusted := newHostKey(t)
+	ctx := context.Background()
+	var target watchTarget
+	s := &supervisor{store: &memStore{}}
+	if err := s.store.Save(ctx, &configdata{HostKeys: map[string]string{host: ssh.FingerprintSHA256(trusted)}}); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+	v, err := newHostKeyVerifier(nil, nil, true, false, s.hostKeyStoreFor(target))
+	if err != nil {
+		t.Fatalf("newHostKeyVerifier() = %v", err)
+	}
+	v.legacy = s.store
+
+	if err := v.callback(ctx)(host, remote, newHostKey(t)); err == nil {
+		t.Error("callback() = nil for another key than the one trusted before, want an error")
+	}
+	if err := v.callback(ctx)(host, remote, trusted); err != nil {
+		t.Errorf("callback() = %v", err)
+	}
+}
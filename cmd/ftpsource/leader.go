@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
+	"knative.dev/pkg/leaderelection"
+	"knative.dev/pkg/reconciler"
+)
+
+// leaderConfig configures the election of the replica of the adapter that
+// watches the servers, so that replicas can be added for availability
+// without sending every event once per replica.
+type leaderConfig struct {
+	enabled bool
+	// Name of the Lease the replicas compete for.
+	name string
+	// The shards the work is split into, each with a Lease of its own, nil
+	// if it isn't sharded.
+	shards []reconciler.Bucket
+	// How long the Lease is valid for after it was last renewed, how long
+	// the leader keeps trying to renew it before giving up, and how often
+	// the replicas try to acquire or renew it. The other replicas take over
+	// within leaseDuration of the leader failing, and right away when the
+	// leader shuts down.
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+}
+
+// newElector returns the elector promoting la once this replica holds the
+// Lease, and demoting it once it doesn't anymore. When sharded, la is
+// promoted and demoted for every shard separately. Without leader election,
+// la is promoted as soon as the elector runs.
+func newElector(ctx context.Context, cfg leaderConfig, la reconciler.LeaderAware) (leaderelection.Elector, error) {
+	if len(cfg.shards) > 0 && !cfg.enabled {
+		return nil, fmt.Errorf("sharding needs leader election")
+	}
+	if cfg.enabled {
+		switch {
+		case cfg.name == "":
+			return nil, fmt.Errorf("missing Lease name")
+		case cfg.renewDeadline <= 0 || cfg.renewDeadline >= cfg.leaseDuration:
+			return nil, fmt.Errorf("the renew deadline %v must be shorter than the lease duration %v", cfg.renewDeadline, cfg.leaseDuration)
+		case cfg.retryPeriod <= 0 || cfg.retryPeriod >= cfg.renewDeadline:
+			return nil, fmt.Errorf("the retry period %v must be shorter than the renew deadline %v", cfg.retryPeriod, cfg.renewDeadline)
+		}
+		if len(cfg.shards) > 0 {
+			id, err := leaderelection.UniqueID()
+			if err != nil {
+				return nil, err
+			}
+			return newShardElector(ctx, cfg, la, id)
+		}
+		ctx = leaderelection.WithStandardLeaderElectorBuilder(ctx, kubeclient.Get(ctx), leaderelection.ComponentConfig{
+			Component:     "ftpsource",
+			Buckets:       1,
+			LeaseDuration: cfg.leaseDuration,
+			RenewDeadline: cfg.renewDeadline,
+			RetryPeriod:   cfg.retryPeriod,
+			LeaseName:     func(uint32) string { return cfg.name },
+		})
+	}
+	// We have no work queue to resync, the watchers list everything in every
+	// probe anyway.
+	return leaderelection.BuildElector(ctx, la, "", nil)
+}
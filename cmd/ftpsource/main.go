@@ -3,19 +3,17 @@ package main
 import (
 	"context"
 	"flag"
-	"path"
 	"time"
 
+	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"go.uber.org/zap"
 	"knative.dev/eventing/pkg/adapter/v2"
-	kubeclient "knative.dev/pkg/client/injection/kube/client"
-	configmap "knative.dev/pkg/configmap/informer"
 	"knative.dev/pkg/injection"
 	"knative.dev/pkg/injection/sharedmain"
 	"knative.dev/pkg/logging"
+	"knative.dev/pkg/metrics"
 	"knative.dev/pkg/signals"
 	"knative.dev/pkg/source"
-	"knative.dev/pkg/system"
 )
 
 const (
@@ -40,15 +38,76 @@ const (
 					        }
       					}`
 
-	event_type = "org.aikas.ftp.fileadded"
+	// The types of the events we send start with the prefix, unless
+	// configured otherwise.
+	defaultTypePrefix   = "org.aikas.ftp"
+	event_type          = "org.aikas.ftp.fileadded"
+	event_type_modified = "org.aikas.ftp.filemodified"
+	event_type_deleted  = "org.aikas.ftp.filedeleted"
+
+	event_type_checksum_mismatch = "org.aikas.ftp.checksummismatch"
 )
 
 var (
 	dir            string
 	sftpServer     string
-	secure         bool
+	protocol       string
 	storename      string
 	probeFrequency int
+	stateRetention time.Duration
+	maxStateFiles  int
+	recursive      bool
+	maxDepth       int
+	include        stringsFlag
+	exclude        stringsFlag
+	includeRegex   stringsFlag
+	excludeRegex   stringsFlag
+	stableProbes   int
+	quietPeriod    time.Duration
+	markerSuffixes stringsFlag
+	authMethods    stringsFlag
+	knownHosts     stringsFlag
+	fingerprints   stringsFlag
+	trustFirstUse  bool
+	ignoreHostKey  bool
+	tlsDir         string
+	tlsSkipVerify  bool
+	keepalive      time.Duration
+	maxBackoff     time.Duration
+	stateBackend   string
+	stateFile      string
+	redisAddress   string
+	redisDB        int
+	maxAttempts    int
+	retryBackoff   time.Duration
+	maxRetryDelay  time.Duration
+	backoffPolicy  string
+	deadLetterSink string
+	afterDelivery  string
+	archiveDir     string
+	renameSuffix   string
+	inlineMaxSize  int64
+	inlineEncoding string
+	claimBackend   string
+	claimMinSize   int64
+	claimPrefix    string
+	claimDir       string
+	s3Bucket       string
+	s3Endpoint     string
+	s3Region       string
+	s3PathStyle    bool
+	checksumAlgo   string
+	verifyChecksum stringsFlag
+	typePrefix     string
+	sourceTemplate string
+	extensions     stringsFlag
+	configFile     string
+	leaderElection bool
+	leaseName      string
+	leaseDuration  time.Duration
+	renewDeadline  time.Duration
+	retryPeriod    time.Duration
+	shardCount     int
 )
 
 type EnvConfig struct {
@@ -56,14 +115,77 @@ type EnvConfig struct {
 
 	User     string `envconfig:"FTP_USER" required:"false"`
 	Password string `envconfig:"FTP_PASSWORD" required:"false"`
+
+	// Private key, its passphrase and an optional OpenSSH certificate for
+	// it, for publickey authentication to SFTP servers.
+	PrivateKey           string `envconfig:"FTP_PRIVATE_KEY" required:"false"`
+	PrivateKeyPassphrase string `envconfig:"FTP_PRIVATE_KEY_PASSPHRASE" required:"false"`
+	Certificate          string `envconfig:"FTP_CERTIFICATE" required:"false"`
+
+	// Password of the redis server for the redis state store.
+	RedisPassword string `envconfig:"REDIS_PASSWORD" required:"false"`
 }
 
 func init() {
 	flag.StringVar(&dir, "dir", ".", "directory to watch files in")
 	flag.StringVar(&sftpServer, "sftpServer", "", "server to connect to")
-	flag.StringVar(&storename, "storename", "", "ConfigMap name to use for storing state")
+	flag.StringVar(&storename, "storename", "", "ConfigMap name to use for storing state, also names the state in the other backends")
+	flag.StringVar(&stateBackend, "stateStore", stateBackendConfigMap, "where to store state, one of configmap, file or redis")
+	flag.StringVar(&stateFile, "stateFile", "/var/lib/ftpsource/state.db", "database file to store state in with the file state store")
+	flag.StringVar(&redisAddress, "redisAddress", "", "host:port of the redis server to store state in with the redis state store")
+	flag.IntVar(&redisDB, "redisDB", 0, "redis database number to store state in with the redis state store")
 	flag.IntVar(&probeFrequency, "probeFrequency", 10, "interval in seconds between two probes")
-	flag.BoolVar(&secure, "secure", true, "if set to true, use sftp")
+	flag.StringVar(&protocol, "protocol", protocolSFTP, "protocol to connect with, one of ftp, ftps-explicit, ftps-implicit or sftp")
+	flag.StringVar(&tlsDir, "tlsDir", "", "directory holding the ca.crt CA bundle to verify FTPS servers with, and the tls.crt and tls.key client certificate, all optional")
+	flag.BoolVar(&tlsSkipVerify, "tlsInsecureSkipVerify", false, "if set to true, do not verify the certificate of FTPS servers. Only meant for testing")
+	flag.BoolVar(&recursive, "recursive", false, "if set to true, also watch files in subdirectories")
+	flag.IntVar(&maxDepth, "maxDepth", 0, "maximum number of subdirectory levels to descend into in recursive mode, 0 for no limit")
+	flag.Var(&include, "include", "only send events for files matching this glob, may be repeated")
+	flag.Var(&exclude, "exclude", "do not send events for files matching this glob, may be repeated")
+	flag.Var(&includeRegex, "includeRegex", "only send events for files whose relative path matches this regular expression, may be repeated")
+	flag.Var(&excludeRegex, "excludeRegex", "do not send events for files whose relative path matches this regular expression, may be repeated")
+	flag.IntVar(&stableProbes, "stableProbes", 1, "number of consecutive probes the size and modification time of a file must be unchanged in before sending an event")
+	flag.DurationVar(&quietPeriod, "quietPeriod", 0, "how long the size and modification time of a file must be unchanged for before sending an event")
+	flag.Var(&markerSuffixes, "markerSuffix", "only send an event for a file once a marker file with this suffix appended to its name exists, for example .done. May be repeated")
+	flag.Var(&authMethods, "authMethod", "SFTP authentication method to try, one of publickey, password or keyboard-interactive. May be repeated, methods are tried in order. Defaults to every method there are credentials for")
+	flag.Var(&knownHosts, "knownHosts", "known_hosts file to verify SFTP host keys with, may be repeated")
+	flag.Var(&fingerprints, "hostKeyFingerprint", "SHA256 fingerprint of an SFTP host key to accept, may be repeated")
+	flag.BoolVar(&trustFirstUse, "trustOnFirstUse", false, "if set to true, trust the SFTP host key seen on first connection and remember it in the state store")
+	flag.BoolVar(&ignoreHostKey, "insecureIgnoreHostKey", false, "if set to true, accept any SFTP host key. Only meant for testing")
+	flag.DurationVar(&keepalive, "keepalive", 30*time.Second, "interval between keepalives sent to the server, 0 to disable them")
+	flag.DurationVar(&maxBackoff, "maxReconnectBackoff", 5*time.Minute, "maximum time to wait between attempts to reconnect to the server")
+	flag.IntVar(&maxAttempts, "maxAttempts", 5, "maximum number of attempts to send an event, including the first")
+	flag.DurationVar(&retryBackoff, "retryBackoff", time.Second, "delay before retrying to send an event")
+	flag.StringVar(&backoffPolicy, "backoffPolicy", "exponential", "how the delay grows for every further retry, exponential or linear")
+	flag.DurationVar(&maxRetryDelay, "maxRetryBackoff", time.Minute, "maximum delay between two attempts to send an event")
+	flag.StringVar(&deadLetterSink, "deadLetterSink", "", "URI to send events to that failed to be delivered, after which their files are recorded as processed")
+	flag.StringVar(&afterDelivery, "postAction", postActionNone, "what to do with files on the server once their events have been delivered, one of none, delete, move or rename")
+	flag.StringVar(&archiveDir, "archiveDir", "", "directory to move files to with the move post action, relative to dir unless absolute, and outside of dir when recursive. A text/template, for example archive/{{ .Time.Format \"2006-01-02\" }}")
+	flag.StringVar(&renameSuffix, "renameSuffix", ".processed", "suffix to append to the names of files with the rename post action")
+	flag.Int64Var(&inlineMaxSize, "inlineMaxSize", 0, "embed the content of files up to this many bytes in their events, 0 to never embed it")
+	flag.StringVar(&inlineEncoding, "inlineEncoding", inlineBinary, "how to embed the content of files, binary as the event data or base64 in the JSON event data")
+	flag.StringVar(&claimBackend, "claimCheck", claimCheckNone, "copy files to s3 or a volume before sending their events, which reference the copies. Off if empty")
+	flag.Int64Var(&claimMinSize, "claimCheckMinSize", 0, "only copy files of at least this many bytes in claim-check mode")
+	flag.StringVar(&claimPrefix, "claimCheckPrefix", "", "prefix of the keys of the copies in claim-check mode, followed by the server, dir and the path of the file relative to dir")
+	flag.StringVar(&claimDir, "claimCheckDir", "", "directory to copy files to with the volume claim-check store")
+	flag.StringVar(&s3Bucket, "s3Bucket", "", "bucket to copy files to with the s3 claim-check store")
+	flag.StringVar(&s3Endpoint, "s3Endpoint", "", "endpoint of the S3 compatible store to copy files to, defaults to AWS")
+	flag.StringVar(&s3Region, "s3Region", "us-east-1", "region of the bucket to copy files to")
+	flag.BoolVar(&s3PathStyle, "s3PathStyle", false, "if set to true, address the bucket in the path of URLs instead of the host name, as MinIO needs")
+	flag.StringVar(&checksumAlgo, "checksum", "", "compute the checksum of files with sha256, md5 or crc32 and include it in their events. Off if empty")
+	flag.Var(&verifyChecksum, "verifyChecksum", "verify the checksum of files against a sidecar file named like the file with the algorithm appended, or the server, and send a failure event instead on mismatch. May be repeated, sidecars are tried first")
+	flag.StringVar(&typePrefix, "typePrefix", defaultTypePrefix, "prefix of the types of the events, followed by .fileadded, .filemodified and so on")
+	flag.StringVar(&sourceTemplate, "sourceTemplate", "", "text/template of the source of the events, executed with .Server, .Host, .Port, .Directory and .Protocol. Defaults to //<server>/<dir>")
+	flag.Var(&extensions, "extension", "name=value of an extension to set on every event, may be repeated. The extensions of the CloudEvent overrides take precedence")
+	flag.StringVar(&configFile, "config", "", "YAML file listing the servers and directories to watch, instead of the server and directory of the flags, which the settings of the file default to")
+	flag.BoolVar(&leaderElection, "leaderElection", false, "if set to true, only the replica holding a Lease watches the servers, so the adapter can run with several replicas")
+	flag.StringVar(&leaseName, "leaseName", "", "name of the Lease the replicas compete for, defaults to storename")
+	flag.DurationVar(&leaseDuration, "leaseDuration", 15*time.Second, "how long the Lease is valid for after it was last renewed, after which another replica takes over")
+	flag.DurationVar(&renewDeadline, "renewDeadline", 10*time.Second, "how long the leader tries to renew the Lease for before it stops watching")
+	flag.DurationVar(&retryPeriod, "retryPeriod", 2*time.Second, "interval between two attempts to acquire or renew the Lease")
+	flag.IntVar(&shardCount, "shards", 1, "number of shards to split the work into, which are spread over the replicas with leader election. The subdirectories of recursively watched directories are sharded, other directories as a whole")
+	flag.DurationVar(&stateRetention, "stateRetention", 24*time.Hour, "how long to remember files that have been removed from the server")
+	flag.IntVar(&maxStateFiles, "maxStateFiles", 10000, "maximum number of files to remember, files still on the server are always remembered. 0 for no limit")
 }
 
 func main() {
@@ -78,26 +200,84 @@ func main() {
 	e := adapter.ConstructEnvOrDie(NewEnvConfig)
 	env := e.(*EnvConfig)
 
-	if sftpServer == "" {
-		logger.Error("Need to specify server string")
+	if err := setupMetrics(ctx, env); err != nil {
+		logger.Error("Failed to create the metrics exporter", zap.Error(err))
+	}
+	defer metrics.FlushExporter()
+
+	// Tracing is configured by the controller from its config-tracing
+	// ConfigMap.
+	if env.TracingConfigJson != "" {
+		if env.Component == "" {
+			env.SetComponent(component)
+		}
+		if err := env.SetupTracing(logger); err != nil {
+			logger.Error("Failed to set up tracing", zap.Error(err))
+		}
+	}
+
+	if sftpServer == "" && configFile == "" {
+		logger.Error("Need to specify server string or config file")
+		return
+	}
+
+	checksums, err := newChecksums(checksumAlgo, verifyChecksum)
+	if err != nil {
+		logger.Error("Invalid checksum configuration", zap.Error(err))
 		return
 	}
 
-	logger.Info("Storing state in  ", zap.String("ConfigMap", storename))
-	logger.Info("Using sftp", zap.Bool("sftp", secure))
-	logger.Info("watching ", zap.String("server", sftpServer), zap.String("dir", dir))
-	logger.Info("Probing frequency  ", zap.Int("probeFrequency", probeFrequency))
+	targets := []watchTarget{flagTarget()}
+	if configFile != "" {
+		config, err := loadWatchConfig(configFile)
+		if err != nil {
+			logger.Error("Invalid config file", zap.Error(err))
+			return
+		}
+		targets = config.Targets
+	}
 
-	ctx, _ = injection.Default.SetupInformers(ctx, sharedmain.ParseAndGetConfigOrDie())
-	// initialize config store
-	initConfigStore(ctx, storename)
+	postAction, err := newPostAction(afterDelivery, archiveDir, renameSuffix)
+	if err != nil {
+		logger.Error("Invalid post delivery action", zap.Error(err))
+		return
+	}
+
+	inline, err := newInlineContent(inlineMaxSize, inlineEncoding)
+	if err != nil {
+		logger.Error("Invalid inline content", zap.Error(err))
+		return
+	}
 
-	configMapWatcher := configmap.NewInformedWatcher(kubeclient.Get(ctx), system.Namespace())
-	configStore := NewConfigStore(logger)
-	configStore.WatchConfigs(configMapWatcher)
-	logger.Info("Starting configuration manager...")
-	if err := configMapWatcher.Start(ctx.Done()); err != nil {
-		logger.Fatalw("Failed to start configuration manager", err)
+	claimCheck, err := newClaimCheck(claimCheckConfig{
+		backend:     claimBackend,
+		minSize:     claimMinSize,
+		prefix:      claimPrefix,
+		s3Bucket:    s3Bucket,
+		s3Endpoint:  s3Endpoint,
+		s3Region:    s3Region,
+		s3PathStyle: s3PathStyle,
+		dir:         claimDir,
+	})
+	if err != nil {
+		logger.Error("Invalid claim-check configuration", zap.Error(err))
+		return
+	}
+
+	logger.Info("Storing state in  ", zap.String("backend", stateBackend), zap.String("name", storename))
+
+	ctx, _ = injection.Default.SetupInformers(ctx, sharedmain.ParseAndGetConfigOrDie())
+	stateStore, err := newStateStore(ctx, stateStoreConfig{
+		backend:       stateBackend,
+		name:          storename,
+		file:          stateFile,
+		redisAddress:  redisAddress,
+		redisPassword: env.RedisPassword,
+		redisDB:       redisDB,
+	})
+	if err != nil {
+		logger.Error("Failed to initialize the state store", zap.Error(err))
+		return
 	}
 
 	ceOverrides, err := env.GetCloudEventOverrides()
@@ -116,25 +296,80 @@ func main() {
 		return
 	}
 
-	publisher := publisher{ceClient: ceClient, sourceServer: path.Join(sftpServer, dir)}
+	var deadLetter cloudevents.Client
+	if deadLetterSink != "" {
+		deadLetter, err = adapter.NewCloudEventsClient(deadLetterSink, ceOverrides, reporter)
+		if err != nil {
+			logger.Error("failed to create dead letter sink client", zap.Error(err))
+			return
+		}
+	}
+
+	publisher := publisher{
+		ceClient: ceClient,
+		retry: retryPolicy{
+			maxAttempts: maxAttempts,
+			backoff:     retryBackoff,
+			linear:      backoffPolicy == "linear",
+			maxBackoff:  maxRetryDelay,
+		},
+		deadLetter: deadLetter,
+		inline:     inline,
+		claimCheck: claimCheck,
+		checksums:  checksums,
+	}
+
+	if leaseName == "" {
+		leaseName = storename
+	}
+	shards := newShards(leaseName, shardCount)
 
-	done := make(chan bool)
+	supervisor := &supervisor{
+		targets:        targets,
+		env:            env,
+		store:          stateStore,
+		publisher:      publisher,
+		postAction:     postAction,
+		stableProbes:   stableProbes,
+		quietPeriod:    quietPeriod,
+		markerSuffixes: markerSuffixes,
+		retention:      stateRetention,
+		maxEntries:     maxStateFiles,
+		keepalive:      keepalive,
+		maxBackoff:     maxBackoff,
+		shards:         shards,
+	}
+	if err := supervisor.setup(ctx); err != nil {
+		logger.Error("Invalid watch target", zap.Error(err))
+		return
+	}
 
-	searcher := NewWatcher(
-		sftpServer,
-		dir,
-		env.User,
-		env.Password,
-		secure,
-		time.Duration(probeFrequency)*time.Second,
-		publisher.postMessage,
-		done,
-		configStore,
-	)
+	elector, err := newElector(ctx, leaderConfig{
+		enabled:       leaderElection,
+		name:          leaseName,
+		leaseDuration: leaseDuration,
+		renewDeadline: renewDeadline,
+		retryPeriod:   retryPeriod,
+		shards:        shards,
+	}, supervisor)
+	if err != nil {
+		logger.Error("Invalid leader election", zap.Error(err))
+		return
+	}
 
-	searcher.run(ctx)
+	electorCtx, cancel := context.WithCancel(ctx)
+	elected := make(chan struct{})
+	go func() {
+		elector.Run(electorCtx)
+		close(elected)
+	}()
 	<-stopCh
-	done <- true
+
+	// Stop the watchers, which save their state, before we give up the
+	// Lease, so the next leader carries on where we stopped.
+	supervisor.shutdown()
+	cancel()
+	<-elected
 }
 
 func NewEnvConfig() adapter.EnvConfigAccessor {
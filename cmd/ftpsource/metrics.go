@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/metrics"
+	"knative.dev/pkg/metrics/metricskey"
+)
+
+// Metrics are reported under this domain and component, like the ones of the
+// knative sources, unless the controller configures otherwise. Spans are
+// reported under the component as well.
+const (
+	metricsDomain = "knative.dev/sources"
+	component     = "ftpsource"
+)
+
+var (
+	probeDurationM = stats.Float64(
+		"probe_duration",
+		"How long it took to list the directory and process its files",
+		stats.UnitMilliseconds)
+	listingSizeM = stats.Int64(
+		"listing_size",
+		"Number of files in the last listing of the directory",
+		stats.UnitDimensionless)
+	filesDetectedM = stats.Int64(
+		"files_detected_count",
+		"Number of added, modified and deleted files found",
+		stats.UnitDimensionless)
+	filesFilteredM = stats.Int64(
+		"files_filtered_count",
+		"Number of files skipped by the filters, in every probe",
+		stats.UnitDimensionless)
+	deliveryLatencyM = stats.Float64(
+		"delivery_latency",
+		"Time from the modification of a file to the ACK of its event",
+		stats.UnitMilliseconds)
+	sendFailuresM = stats.Int64(
+		"send_failures_count",
+		"Number of failed attempts to send an event",
+		stats.UnitDimensionless)
+	reconnectsM = stats.Int64(
+		"reconnects_count",
+		"Number of times the connection to the server was established again",
+		stats.UnitDimensionless)
+	stateSaveFailuresM = stats.Int64(
+		"state_save_failures_count",
+		"Number of times the state failed to be saved",
+		stats.UnitDimensionless)
+
+	serverKey       = tag.MustNewKey("server")
+	directoryKey    = tag.MustNewKey("directory")
+	eventTypeKey    = tag.MustNewKey(metricskey.LabelEventType)
+	responseCodeKey = tag.MustNewKey(metricskey.LabelResponseCode)
+)
+
+func init() {
+	if err := registerViews(); err != nil {
+		panic(err)
+	}
+}
+
+// registerViews registers the views of the metrics we record.
+func registerViews() error {
+	keys := []tag.Key{serverKey, directoryKey}
+	return view.Register(
+		&view.View{
+			Description: probeDurationM.Description(),
+			Measure:     probeDurationM,
+			Aggregation: view.Distribution(metrics.Buckets125(1, 1000000)...),
+			TagKeys:     keys,
+		},
+		&view.View{
+			Description: listingSizeM.Description(),
+			Measure:     listingSizeM,
+			Aggregation: view.LastValue(),
+			TagKeys:     keys,
+		},
+		&view.View{
+			Description: filesDetectedM.Description(),
+			Measure:     filesDetectedM,
+			Aggregation: view.Count(),
+			TagKeys:     append(keys, eventTypeKey),
+		},
+		&view.View{
+			Description: filesFilteredM.Description(),
+			Measure:     filesFilteredM,
+			Aggregation: view.Count(),
+			TagKeys:     keys,
+		},
+		&view.View{
+			// Files can sit on the server for a long time before we see
+			// them, after a restart or a disconnect.
+			Description: deliveryLatencyM.Description(),
+			Measure:     deliveryLatencyM,
+			Aggregation: view.Distribution(metrics.Buckets125(10, 100000000)...),
+			TagKeys:     keys,
+		},
+		&view.View{
+			Description: sendFailuresM.Description(),
+			Measure:     sendFailuresM,
+			Aggregation: view.Count(),
+			TagKeys:     append(keys, responseCodeKey),
+		},
+		&view.View{
+			Description: reconnectsM.Description(),
+			Measure:     reconnectsM,
+			Aggregation: view.Count(),
+			TagKeys:     keys,
+		},
+		&view.View{
+			Description: stateSaveFailuresM.Description(),
+			Measure:     stateSaveFailuresM,
+			Aggregation: view.Count(),
+			TagKeys:     keys,
+		},
+	)
+}
+
+// setupMetrics sets up the exporter of the metrics, as configured by the
+// controller from its config-observability ConfigMap. Without configuration,
+// the metrics are served to Prometheus.
+func setupMetrics(ctx context.Context, env *EnvConfig) error {
+	opts, err := env.GetMetricsConfig()
+	if err != nil {
+		return err
+	}
+	if opts.Domain == "" {
+		opts.Domain = metricsDomain
+	}
+	if opts.Component == "" {
+		opts.Component = component
+	}
+	if opts.ConfigMap == nil {
+		opts.ConfigMap = map[string]string{}
+	}
+	return metrics.UpdateExporter(ctx, *opts, logging.FromContext(ctx))
+}
+
+// withMetricTags returns a context recording the metrics of the watcher of
+// the directory on the server.
+func withMetricTags(ctx context.Context, server, dir string) context.Context {
+	tagged, err := tag.New(ctx, tag.Upsert(serverKey, server), tag.Upsert(directoryKey, dir))
+	if err != nil {
+		logging.FromContext(ctx).Warn("Failed to tag the metrics of the directory:", zap.Error(err))
+		return ctx
+	}
+	return tagged
+}
+
+// recordFileDetected records a file we're sending an event of the given
+// default type for.
+func recordFileDetected(ctx context.Context, eventType string) {
+	ctx, err := tag.New(ctx, tag.Upsert(eventTypeKey, eventType))
+	if err != nil {
+		return
+	}
+	metrics.Record(ctx, filesDetectedM.M(1))
+}
+
+// recordSendFailure records a failed attempt to send an event, with the
+// status code of the response if the sink responded.
+func recordSendFailure(ctx context.Context, result error) {
+	code := 0
+	var httpResult *cehttp.Result
+	if cloudevents.ResultAs(result, &httpResult) {
+		code = httpResult.StatusCode
+	}
+	ctx, err := tag.New(ctx, metrics.MaybeInsertIntTag(responseCodeKey, code, code > 0))
+	if err != nil {
+		return
+	}
+	metrics.Record(ctx, sendFailuresM.M(1))
+}
+
+// millis returns the duration in milliseconds, as our latencies are recorded.
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.opencensus.io/trace"
+	"knative.dev/pkg/metrics"
+	"knative.dev/pkg/metrics/metricstest"
+)
+
+// resetMetrics throws away the metrics recorded so far.
+func resetMetrics(t *testing.T) {
+	t.Helper()
+	metrics.InitForTesting()
+	metricstest.Unregister(
+		probeDurationM.Name(),
+		listingSizeM.Name(),
+		filesDetectedM.Name(),
+		filesFilteredM.Name(),
+		deliveryLatencyM.Name(),
+		sendFailuresM.Name(),
+		reconnectsM.Name(),
+		stateSaveFailuresM.Name(),
+	)
+	if err := registerViews(); err != nil {
+		t.Fatalf("registerViews() = %v", err)
+	}
+}
+
+func TestSendFailureMetrics(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   cloudevents.Result
+		wantTags map[string]string
+	}{{
+		name:   "sink responded",
+		result: cehttp.NewResult(http.StatusServiceUnavailable, "unavailable"),
+		wantTags: map[string]string{
+			"server":        "ftp.example.com:21",
+			"directory":     "/in",
+			"response_code": "503",
+		},
+	}, {
+		name:   "sink didn't respond",
+		result: errors.New("connection refused"),
+		wantTags: map[string]string{
+			"server":    "ftp.example.com:21",
+			"directory": "/in",
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetMetrics(t)
+			sink := &fakeSink{results: []cloudevents.Result{test.result}}
+			p := &publisher{ceClient: sink, retry: retryPolicy{maxAttempts: 3, backoff: time.Millisecond}}
+			event := cloudevents.NewEvent()
+			event.SetID("1")
+
+			ctx := withMetricTags(context.Background(), "ftp.example.com:21", "/in")
+			if err := p.send(ctx, event); err == nil {
+				t.Fatal("send() = nil, want error")
+			}
+			metricstest.CheckCountData(t, sendFailuresM.Name(), test.wantTags, 3)
+		})
+	}
+}
+
+func TestSendFailureMetricsNotRecordedWhenACKed(t *testing.T) {
+	resetMetrics(t)
+	sink := &fakeSink{results: []cloudevents.Result{cloudevents.ResultACK}}
+	p := &publisher{ceClient: sink, retry: retryPolicy{maxAttempts: 3}}
+	event := cloudevents.NewEvent()
+	event.SetID("1")
+
+	if err := p.send(withMetricTags(context.Background(), "ftp.example.com:21", "/in"), event); err != nil {
+		t.Fatalf("send() = %v", err)
+	}
+	metricstest.CheckStatsNotReported(t, sendFailuresM.Name())
+}
+
+// droppingRemote is a remote failing its keepalives once its connection
+// dropped.
+type droppingRemote struct {
+	fakeRemote
+	dropped bool
+}
+
+func (r *droppingRemote) keepalive() error {
+	if r.dropped {
+		return errors.New("connection reset by peer")
+	}
+	return nil
+}
+
+func TestReconnectMetrics(t *testing.T) {
+	resetMetrics(t)
+	ctx := withMetricTags(context.Background(), "sftp.example.com:22", "/in")
+	var dialed []*droppingRemote
+	c := newConnection(func(context.Context) (remote, error) {
+		r := &droppingRemote{}
+		dialed = append(dialed, r)
+		return r, nil
+	}, 0, time.Second)
+	c.acquire()
+	defer c.release()
+
+	// Connecting the first time isn't a reconnect, and neither is reusing
+	// the connection.
+	for i := 0; i < 2; i++ {
+		if _, err := c.get(ctx); err != nil {
+			t.Fatalf("get() = %v", err)
+		}
+	}
+	metricstest.CheckStatsNotReported(t, reconnectsM.Name())
+
+	dialed[0].dropped = true
+	if _, err := c.get(ctx); err != nil {
+		t.Fatalf("get() = %v", err)
+	}
+	metricstest.CheckCountData(t, reconnectsM.Name(), map[string]string{
+		"server":    "sftp.example.com:22",
+		"directory": "/in",
+	}, 1)
+}
+
+// failingStore is a store failing to save the state.
+type failingStore struct {
+	*memStore
+}
+
+func (s failingStore) Save(ctx context.Context, data *configdata) error {
+	return errors.New("configmaps is forbidden")
+}
+
+func TestStateSaveFailureMetrics(t *testing.T) {
+	resetMetrics(t)
+	ctx := withMetricTags(context.Background(), "sftp.example.com:22", "/in")
+	w := &watcher{
+		dir:        "/in",
+		retention:  time.Hour,
+		filter:     &fileFilter{},
+		stability:  newStabilityCheck(0, 0, nil),
+		postAction: &postAction{},
+		store:      failingStore{&memStore{}},
+		handler: func(ctx context.Context, eventType string, e os.FileInfo, f *remoteFile) error {
+			return nil
+		},
+	}
+
+	w.processFiles(ctx, &fakeRemote{}, []os.FileInfo{file("a.csv", 1, t0)}, nil, trace.SpanContext{})
+
+	metricstest.CheckCountData(t, stateSaveFailuresM.Name(), map[string]string{
+		"server":    "sftp.example.com:22",
+		"directory": "/in",
+	}, 1)
+}
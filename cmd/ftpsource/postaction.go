@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// The actions we can take on a file once the sink has ACKed its event.
+const (
+	postActionNone   = "none"
+	postActionDelete = "delete"
+	postActionMove   = "move"
+	postActionRename = "rename"
+)
+
+// postAction is what we do with a file on the server once the sink has ACKed
+// its event, so that partners can tell which files have been picked up.
+type postAction struct {
+	action string
+	// Directory to move files to, a text/template executed with
+	// archiveData. Relative directories are relative to the watched one.
+	archiveDir *template.Template
+	// Suffix to append to the name of files.
+	suffix string
+}
+
+// archiveData is what the archive directory template is executed with.
+type archiveData struct {
+	// When the file is moved, in UTC.
+	Time time.Time
+}
+
+func newPostAction(action, archiveDir, suffix string) (*postAction, error) {
+	a := &postAction{action: action, suffix: suffix}
+	switch action {
+	case postActionNone, postActionDelete:
+	case postActionMove:
+		if archiveDir == "" {
+			return nil, fmt.Errorf("missing archive directory to move files to")
+		}
+		t, err := template.New("archiveDir").Option("missingkey=error").Parse(archiveDir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive directory: %w", err)
+		}
+		a.archiveDir = t
+	case postActionRename:
+		if suffix == "" {
+			return nil, fmt.Errorf("missing suffix to rename files with")
+		}
+	default:
+		return nil, fmt.Errorf("unknown post delivery action %q", action)
+	}
+	return a, nil
+}
+
+// enabled returns true if we do anything with files at all.
+func (a *postAction) enabled() bool {
+	return a.action != postActionNone
+}
+
+// archivesInto returns true if files may be moved into the directory, or its
+// subdirectories. Since the archive directory is a template, its path is only
+// known up to the first action, beyond which we assume the worst.
+func (a *postAction) archivesInto(dir string) bool {
+	if a.action != postActionMove {
+		return false
+	}
+	archive := a.archiveDir.Root.String()
+	if !path.IsAbs(archive) {
+		return true
+	}
+	static := archive
+	if i := strings.Index(archive, "{{"); i >= 0 {
+		static = archive[:i]
+	}
+	dir = strings.TrimSuffix(path.Clean(dir), "/") + "/"
+	if strings.HasPrefix(static, dir) || path.Clean(static) == path.Clean(dir) {
+		return true
+	}
+	return static != archive && strings.HasPrefix(dir, static)
+}
+
+// ignores returns true for files we renamed ourselves, which we must not
+// send events for.
+func (a *postAction) ignores(name string) bool {
+	return a.action == postActionRename && strings.HasSuffix(name, a.suffix)
+}
+
+// apply applies the action to the file with the given path relative to dir,
+// and returns a description of what it did.
+func (a *postAction) apply(client remote, dir, rel string, now time.Time) (string, error) {
+	from := path.Join(dir, rel)
+	switch a.action {
+	case postActionDelete:
+		if err := client.Remove(from); err != nil {
+			return "", fmt.Errorf("deleting %s: %w", from, err)
+		}
+		return "deleted", nil
+	case postActionMove:
+		var b bytes.Buffer
+		if err := a.archiveDir.Execute(&b, archiveData{Time: now.UTC()}); err != nil {
+			return "", fmt.Errorf("executing archive directory template: %w", err)
+		}
+		archive := b.String()
+		if !path.IsAbs(archive) {
+			archive = path.Join(dir, archive)
+		}
+		to := path.Join(archive, rel)
+		if err := client.MkdirAll(path.Dir(to)); err != nil {
+			return "", fmt.Errorf("creating %s: %w", path.Dir(to), err)
+		}
+		if err := client.Rename(from, to); err != nil {
+			return "", fmt.Errorf("moving %s to %s: %w", from, to, err)
+		}
+		return "moved to " + to, nil
+	case postActionRename:
+		to := from + a.suffix
+		if err := client.Rename(from, to); err != nil {
+			return "", fmt.Errorf("renaming %s to %s: %w", from, to, err)
+		}
+		return "renamed to " + to, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeRemote is a server with the given files, and the checksums it
+// computes, keyed by path. It records what's done to the files.
+type fakeRemote struct {
+	files     map[string]string
+	checksums map[string]string
+	ops       []string
+}
+
+func (r *fakeRemote) ReadDir(dir string) ([]os.FileInfo, error) { return nil, nil }
+func (r *fakeRemote) Close() error                              { return nil }
+func (r *fakeRemote) keepalive() error                          { return nil }
+
+func (r *fakeRemote) Rename(from, to string) error {
+	r.ops = append(r.ops, "rename "+from+" "+to)
+	return nil
+}
+
+func (r *fakeRemote) Remove(path string) error {
+	r.ops = append(r.ops, "remove "+path)
+	return nil
+}
+
+func (r *fakeRemote) MkdirAll(dir string) error {
+	r.ops = append(r.ops, "mkdir "+dir)
+	return nil
+}
+
+func (r *fakeRemote) open(path string) (io.ReadCloser, error) {
+	content, ok := r.files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(strings.NewReader(content)), nil
+}
+
+func (r *fakeRemote) checksum(path, algorithm string) (string, error) {
+	sum, ok := r.checksums[path]
+	if !ok {
+		return "", errChecksumUnsupported
+	}
+	return sum, nil
+}
+
+func TestPostActionApply(t *testing.T) {
+	now := time.Date(2021, 1, 20, 23, 30, 0, 0, time.FixedZone("CET", 3600))
+	tests := []struct {
+		name       string
+		action     string
+		archiveDir string
+		suffix     string
+		want       string
+		wantOps    []string
+	}{{
+		name:   "none",
+		action: postActionNone,
+	}, {
+		name:    "delete",
+		action:  postActionDelete,
+		want:    "deleted",
+		wantOps: []string{"remove /in/sub/a.csv"},
+	}, {
+		name:       "move",
+		action:     postActionMove,
+		archiveDir: `/archive/{{ .Time.Format "2006/01/02" }}`,
+		want:       "moved to /archive/2021/01/20/sub/a.csv",
+		wantOps: []string{
+			"mkdir /archive/2021/01/20/sub",
+			"rename /in/sub/a.csv /archive/2021/01/20/sub/a.csv",
+		},
+	}, {
+		name:       "move relative to the directory",
+		action:     postActionMove,
+		archiveDir: "done",
+		want:       "moved to /in/done/sub/a.csv",
+		wantOps: []string{
+			"mkdir /in/done/sub",
+			"rename /in/sub/a.csv /in/done/sub/a.csv",
+		},
+	}, {
+		name:    "rename",
+		action:  postActionRename,
+		suffix:  ".processed",
+		want:    "renamed to /in/sub/a.csv.processed",
+		wantOps: []string{"rename /in/sub/a.csv /in/sub/a.csv.processed"},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := newPostAction(test.action, test.archiveDir, test.suffix)
+			if err != nil {
+				t.Fatalf("newPostAction() = %v", err)
+			}
+			r := &fakeRemote{}
+			got, err := a.apply(r, "/in", "sub/a.csv", now)
+			if err != nil {
+				t.Fatalf("apply() = %v", err)
+			}
+			if got != test.want {
+				t.Errorf("apply() = %q, want %q", got, test.want)
+			}
+			if diff := cmp.Diff(test.wantOps, r.ops); diff != "" {
+				t.Errorf("operations (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestNewPostActionInvalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     string
+		archiveDir string
+		suffix     string
+	}{
+		{name: "unknown action", action: "copy"},
+		{name: "move without archive directory", action: postActionMove},
+		{name: "invalid archive directory", action: postActionMove, archiveDir: "/archive/{{ .Time"},
+		{name: "rename without suffix", action: postActionRename},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newPostAction(test.action, test.archiveDir, test.suffix); err == nil {
+				t.Error("newPostAction() = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestPostActionArchivesInto(t *testing.T) {
+	tests := []struct {
+		archiveDir string
+		dir        string
+		want       bool
+	}{
+		{archiveDir: "archive", dir: "/in", want: true},
+		{archiveDir: `{{ .Time.Format "2006" }}`, dir: "/in", want: true},
+		{archiveDir: "/archive", dir: "/in", want: false},
+		{archiveDir: "/in/archive", dir: "/in", want: true},
+		{archiveDir: "/in", dir: "/in/", want: true},
+		{archiveDir: "/inbox", dir: "/in", want: false},
+		{archiveDir: "/archive", dir: "/", want: true},
+		{archiveDir: `/archive/{{ .Time.Format "2006" }}`, dir: "/in", want: false},
+		{archiveDir: `/in/{{ .Time.Format "2006" }}`, dir: "/in", want: true},
+		// For all we know, the template could make it /in/.
+		{archiveDir: `/{{ .Time.Format "2006" }}`, dir: "/in", want: true},
+	}
+	for _, test := range tests {
+		a, err := newPostAction(postActionMove, test.archiveDir, "")
+		if err != nil {
+			t.Fatalf("newPostAction() = %v", err)
+		}
+		if got := a.archivesInto(test.dir); got != test.want {
+			t.Errorf("archivesInto(%q) with archive directory %q = %v, want %v", test.dir, test.archiveDir, got, test.want)
+		}
+	}
+	for _, action := range []string{postActionNone, postActionDelete} {
+		a, err := newPostAction(action, "", "")
+		if err != nil {
+			t.Fatalf("newPostAction() = %v", err)
+		}
+		if a.archivesInto("/in") {
+			t.Errorf("archivesInto() = true for %s, want false", action)
+		}
+	}
+}
+
+func TestSupervisorRejectsArchiveInRecursiveDirectory(t *testing.T) {
+	a, err := newPostAction(postActionMove, "archive", "")
+	if err != nil {
+		t.Fatalf("newPostAction() = %v", err)
+	}
+	s := &supervisor{
+		env:        &EnvConfig{},
+		publisher:  publisher{},
+		postAction: a,
+		runs:       map[string][]func(<-chan struct{}){},
+	}
+	target := watchTarget{
+		Server:      "ftp.example.com:21",
+		Directories: []string{"/in"},
+		Protocol:    protocolFTP,
+		Recursive:   true,
+		Interval:    &metav1.Duration{Duration: time.Minute},
+		TypePrefix:  defaultTypePrefix,
+	}
+	if err := s.add(context.Background(), target); err == nil || !strings.Contains(err.Error(), "archive directory") {
+		t.Errorf("add() = %v, want an error about the archive directory", err)
+	}
+}
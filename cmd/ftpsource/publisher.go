@@ -2,48 +2,250 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/google/uuid"
+	"github.com/pkg/sftp"
+	"go.opencensus.io/trace"
 	"go.uber.org/zap"
 	"knative.dev/pkg/logging"
+	"knative.dev/pkg/metrics"
 )
 
+// dataSchema is the JSON Schema of FTPFileEvent. Fields are only ever added
+// to a version of the schema, anything else makes for a new version.
+const dataSchema = "https://raw.githubusercontent.com/vaikas/ftp/main/schemas/ftpfileevent/v1.json"
+
 type publisher struct {
-	ceClient     cloudevents.Client
-	sourceServer string
+	ceClient cloudevents.Client
+	// The configurable attributes of the events.
+	attributes *eventAttributes
+	// The server we watch, the directory and the protocol we watch it with.
+	server   string
+	dir      string
+	protocol string
+	retry    retryPolicy
+	// Where events that fail to be delivered go, nil if nowhere.
+	deadLetter cloudevents.Client
+	// Which files we embed the content of in their events.
+	inline inlineContent
+	// Where we copy files to before sending their events, nil if nowhere.
+	claimCheck *claimCheck
+	// How we checksum and verify files, nil if we don't.
+	checksums *checksums
 }
 
 type FTPFileEvent struct {
-	Name    string
+	Name string
+	// Path of the file relative to the watched directory. Only differs
+	// from Name for files found in subdirectories in recursive mode.
+	Path    string
 	Size    int64
 	ModTime time.Time
+	// FullPath of the file on the server, and the Directory it's in.
+	FullPath  string
+	Directory string
+	// Mode holds the permission bits of the file in octal, like 0644. UID
+	// and GID are the owner of the file, which only SFTP servers tell.
+	// None of them are known for deleted files.
+	Mode string  `json:",omitempty"`
+	UID  *uint32 `json:",omitempty"`
+	GID  *uint32 `json:",omitempty"`
+	// MIMEType of the file, guessed from its extension.
+	MIMEType string
+	// Server is the host:port of the server, and Protocol the protocol it
+	// was watched with.
+	Server   string
+	Protocol string
+	// Content of the file and its guessed media type, for small files when
+	// inlining with the base64 encoding.
+	Content     []byte `json:",omitempty"`
+	ContentType string `json:",omitempty"`
+	// Object is the copy of the file in claim-check mode.
+	Object *FTPObject `json:",omitempty"`
+	// Checksum of the file, in hex, when computing them. Verified is set
+	// if the file was verified against a sidecar file or the server, and
+	// ExpectedChecksum to what it was verified against if that failed.
+	ChecksumAlgorithm string `json:",omitempty"`
+	Checksum          string `json:",omitempty"`
+	ChecksumVerified  bool   `json:",omitempty"`
+	ExpectedChecksum  string `json:",omitempty"`
+}
+
+// FTPObject references the copy of a file in an object store.
+type FTPObject struct {
+	// URL of the copy. For copies on a volume, a file URL in the receive
+	// adapter, where Key is the path relative to the root of the volume.
+	URL       string
+	Key       string
+	ETag      string
+	Size      int64
+	VersionID string `json:",omitempty"`
 }
 
-func (p *publisher) postMessage(ctx context.Context, fileEntry os.FileInfo) error {
+// postMessage sends the event for the file. f is nil for files that are no
+// longer on the server. When the file fails checksum verification, a
+// failure event is sent instead, and errChecksumMismatch returned once it's
+// delivered.
+func (p *publisher) postMessage(ctx context.Context, eventType string, fileEntry os.FileInfo, f *remoteFile) error {
 	logger := logging.FromContext(ctx)
-	d := FTPFileEvent{Name: fileEntry.Name(), Size: fileEntry.Size(), ModTime: fileEntry.ModTime()}
+	d := p.fileEvent(fileEntry)
 	event := cloudevents.NewEvent(cloudevents.VersionV1)
 
-	id, _ := uuid.NewUUID()
-	event.SetID(id.String())
 	event.SetTime(time.Now())
-	event.SetType(event_type)
-	//adding slashes to avoid parse url errors
-	event.SetSource("//" + p.sourceServer)
+	p.attributes.apply(&event)
+	event.SetType(p.attributes.eventType(eventType))
+	event.SetSubject(d.Path)
+	setTraceParent(ctx, &event)
+
+	// The checksum is computed while the file is read for inlining or
+	// copying it, and only downloaded again if it wasn't.
+	checksum := p.checksums != nil && f != nil
+	if checksum {
+		f.sum = &fileHash{hash: p.checksums.newHash()}
+	}
+
+	var content []byte
+	var err error
+	if p.inline.applies(fileEntry, f) {
+		_, span := trace.StartSpan(ctx, spanDownload)
+		content, err = p.inline.read(f)
+		endSpan(span, err)
+		if err != nil {
+			return fmt.Errorf("downloading %s: %w", fileEntry.Name(), err)
+		}
+	}
+	if content == nil && p.claimCheck.applies(fileEntry, f) {
+		cctx, span := trace.StartSpan(ctx, spanCopy)
+		d.Object, err = p.claimCheck.copy(cctx, p.server, p.dir, fileEntry.Name(), f)
+		endSpan(span, err)
+		if err != nil {
+			return fmt.Errorf("copying %s: %w", fileEntry.Name(), err)
+		}
+		logger.Info("Copied file", zap.String("file", fileEntry.Name()), zap.String("url", d.Object.URL))
+	}
+
+	mismatch := false
+	if checksum {
+		// The file is only downloaded here if it wasn't read already.
+		_, span := trace.StartSpan(ctx, spanChecksum)
+		if d.Checksum, err = p.checksums.compute(f); err != nil {
+			endSpan(span, err)
+			return fmt.Errorf("computing the checksum of %s: %w", fileEntry.Name(), err)
+		}
+		d.ChecksumAlgorithm = p.checksums.algorithm
+		f.checksum = d.ChecksumAlgorithm + ":" + d.Checksum
+		if f.unchanged != nil && f.unchanged(f.checksum) {
+			endSpan(span, nil)
+			return errUnchanged
+		}
+		event.SetExtension(extensionChecksum, f.checksum)
+
+		expected, from, err := p.checksums.expected(f)
+		endSpan(span, err)
+		if err != nil {
+			return fmt.Errorf("getting the checksum to verify %s against: %w", fileEntry.Name(), err)
+		}
+		switch {
+		case expected == "":
+			logger.Info("No checksum to verify file against", zap.String("file", fileEntry.Name()))
+		case expected == d.Checksum:
+			d.ChecksumVerified = true
+		default:
+			logger.Warn("Checksum mismatch", zap.String("file", fileEntry.Name()), zap.String("from", from), zap.String("expected", expected), zap.String("checksum", d.Checksum))
+			mismatch = true
+			d.ExpectedChecksum = expected
+			event.SetType(p.attributes.eventType(event_type_checksum_mismatch))
+			event.SetExtension(extensionExpectedChecksum, d.ChecksumAlgorithm+":"+expected)
+			// Consumers must not use the content of the file.
+			content = nil
+		}
+	}
 
-	if err := event.SetData(cloudevents.ApplicationJSON, d); err != nil {
+	// The ID is only known once we know the checksum, if we compute them.
+	event.SetID(eventID(event.Type(), d))
+
+	switch {
+	case content != nil && p.inline.encoding == inlineBinary:
+		event.SetExtension(extensionPath, d.Path)
+		event.SetExtension(extensionSize, d.Size)
+		event.SetExtension(extensionModTime, d.ModTime)
+		err = event.SetData(contentType(d.Path, content), content)
+	case content != nil:
+		d.Content = content
+		d.ContentType = contentType(d.Path, content)
+		fallthrough
+	default:
+		event.SetDataSchema(dataSchema)
+		err = event.SetData(cloudevents.ApplicationJSON, d)
+	}
+	if err != nil {
 		logger.Error("Error setting data ", zap.Error(err))
 		return err
 	}
 
 	logger.Info("posting message to sink")
 
-	if err := p.ceClient.Send(ctx, event); !cloudevents.IsACK(err) {
-		logger.Error("failed to send cloudevent", zap.Error(err))
+	sctx, span := trace.StartSpan(ctx, spanSend)
+	err = p.send(sctx, event)
+	endSpan(span, err)
+	if err != nil {
 		return err
 	}
+	// Deleted files are gone, whenever they were last modified.
+	if f != nil {
+		metrics.Record(ctx, deliveryLatencyM.M(millis(time.Since(fileEntry.ModTime()))))
+	}
+	if mismatch {
+		return errChecksumMismatch
+	}
 	return nil
 }
+
+// fileEvent describes the file, whose name is its path relative to the
+// watched directory.
+func (p *publisher) fileEvent(fileEntry os.FileInfo) FTPFileEvent {
+	fullPath := path.Join(p.dir, fileEntry.Name())
+	d := FTPFileEvent{
+		Name:      path.Base(fileEntry.Name()),
+		Path:      fileEntry.Name(),
+		Size:      fileEntry.Size(),
+		ModTime:   fileEntry.ModTime(),
+		FullPath:  fullPath,
+		Directory: path.Dir(fullPath),
+		MIMEType:  contentType(fileEntry.Name(), nil),
+		Server:    p.server,
+		Protocol:  p.protocol,
+	}
+	if mode := fileEntry.Mode(); mode != 0 {
+		d.Mode = fmt.Sprintf("%04o", mode.Perm())
+	}
+	if stat, ok := fileEntry.Sys().(*sftp.FileStat); ok {
+		d.UID, d.GID = &stat.UID, &stat.GID
+	}
+	return d
+}
+
+// eventID derives the ID of the event from what identifies the file, so
+// that every event sent for the same file, whether retried, sent again after
+// a restart or found again in a later listing, has the same ID, and
+// consumers can deduplicate them. The type is part of it, since a file is
+// still identified the same way when it's deleted.
+func eventID(eventType string, d FTPFileEvent) string {
+	name := strings.Join([]string{
+		eventType,
+		d.Server,
+		d.FullPath,
+		strconv.FormatInt(d.Size, 10),
+		d.ModTime.UTC().Format(time.RFC3339Nano),
+		d.ChecksumAlgorithm,
+		d.Checksum,
+	}, "\x00")
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(name)).String()
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cetest "github.com/cloudevents/sdk-go/v2/client/test"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	cetypes "github.com/cloudevents/sdk-go/v2/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/pkg/sftp"
+	"knative.dev/eventing/pkg/adapter/v2"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/source"
+)
+
+// sftpFileInfo is the listing entry of a file on an SFTP server, which also
+// tells its mode and owner.
+type sftpFileInfo struct{ os.FileInfo }
+
+func (fi sftpFileInfo) Mode() os.FileMode { return 0640 }
+func (fi sftpFileInfo) Sys() interface{}  { return &sftp.FileStat{UID: 1000, GID: 100} }
+
+// memObjectStore keeps what's stored in it, by key.
+type memObjectStore map[string]string
+
+func (s memObjectStore) put(ctx context.Context, key string, r io.Reader, contentType string) (*FTPObject, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	s[key] = string(b)
+	return &FTPObject{URL: "mem://" + key, Key: key, ETag: "etag", Size: int64(len(b))}, nil
+}
+
+func newTestPublisher(t *testing.T) publisher {
+	t.Helper()
+	attributes, err := newEventAttributes("com.example.ftp", "", []string{"team=billing"}, sourceData{
+		Server:    "sftp.example.com:22",
+		Directory: "/in",
+		Protocol:  protocolSFTP,
+	})
+	if err != nil {
+		t.Fatalf("newEventAttributes() = %v", err)
+	}
+	return publisher{
+		attributes: attributes,
+		server:     "sftp.example.com:22",
+		dir:        "/in",
+		protocol:   protocolSFTP,
+		retry:      retryPolicy{maxAttempts: 1},
+	}
+}
+
+// sendOne has the publisher send the event of the file to a mock client,
+// and returns the event sent. f is nil for files that are no longer on the
+// server.
+func sendOne(t *testing.T, p publisher, eventType string, entry os.FileInfo, f *remoteFile) cloudevents.Event {
+	t.Helper()
+	client, sent := cetest.NewMockSenderClient(t, 1)
+	p.ceClient = client
+	if err := p.postMessage(context.Background(), eventType, entry, f); err != nil {
+		t.Fatalf("postMessage() = %v", err)
+	}
+	select {
+	case event := <-sent:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event sent")
+	}
+	return cloudevents.Event{}
+}
+
+func loadSchema(t *testing.T) map[string]interface{} {
+	t.Helper()
+	b, err := ioutil.ReadFile("../../schemas/ftpfileevent/v1.json")
+	if err != nil {
+		t.Fatalf("Reading the schema: %v", err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatalf("Parsing the schema: %v", err)
+	}
+	return schema
+}
+
+// validate checks v, decoded from JSON, against the schema, and returns
+// what doesn't conform. Only the keywords our schemas use are supported.
+// Properties the schema doesn't describe are reported as well, since our
+// schemas describe every field of the data.
+func validate(schema map[string]interface{}, v interface{}, at string) []string {
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, at+": "+fmt.Sprintf(format, args...))
+	}
+	switch schema["type"] {
+	case "object":
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			fail("%v is not an object", v)
+			break
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := o[name.(string)]; !ok {
+				fail("missing %s", name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, value := range o {
+			property, ok := properties[name].(map[string]interface{})
+			if !ok {
+				fail("%s is not in the schema", name)
+				continue
+			}
+			errs = append(errs, validate(property, value, at+"."+name)...)
+		}
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			fail("%v is not a string", v)
+			break
+		}
+		switch schema["format"] {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				fail("%q is not a date-time", s)
+			}
+		case "uri":
+			if u, err := url.Parse(s); err != nil || !u.IsAbs() {
+				fail("%q is not a URI", s)
+			}
+		}
+		if schema["contentEncoding"] == "base64" {
+			if _, err := base64.StdEncoding.DecodeString(s); err != nil {
+				fail("%q is not base64", s)
+			}
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(s) {
+			fail("%q doesn't match %s", s, pattern)
+		}
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) {
+			fail("%v is not an integer", v)
+			break
+		}
+		if min, ok := schema["minimum"].(float64); ok && n < min {
+			fail("%v is less than %v", n, min)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("%v is not a boolean", v)
+		}
+	default:
+		fail("unsupported type %v", schema["type"])
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || e == v
+		}
+		if !found {
+			fail("%v is not one of %v", v, enum)
+		}
+	}
+	return errs
+}
+
+func TestSchema(t *testing.T) {
+	schema := loadSchema(t)
+	if got := schema["$id"]; got != dataSchema {
+		t.Errorf("$id = %v, want %s", got, dataSchema)
+	}
+
+	var invalid interface{}
+	if err := json.Unmarshal([]byte(`{"Name": 1, "Size": -1, "Mode": "644", "Protocol": "http", "Other": true}`), &invalid); err != nil {
+		t.Fatal(err)
+	}
+	if errs := validate(schema, invalid, "data"); len(errs) != 11 {
+		t.Errorf("validate() = %q, want 6 missing fields and 5 invalid ones", errs)
+	}
+}
+
+func TestPostMessage(t *testing.T) {
+	uid, gid := uint32(1000), uint32(100)
+	added := FTPFileEvent{
+		Name:      "report.json",
+		Path:      "sub/report.json",
+		Size:      7,
+		ModTime:   t0,
+		FullPath:  "/in/sub/report.json",
+		Directory: "/in/sub",
+		Mode:      "0640",
+		UID:       &uid,
+		GID:       &gid,
+		MIMEType:  "application/json",
+		Server:    "sftp.example.com:22",
+		Protocol:  protocolSFTP,
+	}
+	deleted := added
+	deleted.Mode, deleted.UID, deleted.GID = "", nil, nil
+	inlined := added
+	inlined.Content, inlined.ContentType = []byte(`{"a":1}`), "application/json"
+	grown := added
+	grown.Size = 5
+	const key = "partner/sftp.example.com:22/in/sub/report.json"
+	claimChecked := added
+	claimChecked.Object = &FTPObject{URL: "mem://" + key, Key: key, ETag: "etag", Size: 7}
+
+	tests := []struct {
+		name      string
+		eventType string
+		entry     os.FileInfo
+		// The file is no longer on the server.
+		deleted bool
+		inline  inlineContent
+		// Files are copied to a memObjectStore.
+		claimCheck bool
+		wantType   string
+		// The data is the FTPFileEvent, unless the content of the file is
+		// the data. The ID is derived from the FTPFileEvent either way.
+		want       FTPFileEvent
+		wantBinary string
+		wantStored map[string]string
+	}{{
+		name:      "added",
+		eventType: event_type,
+		entry:     sftpFileInfo{file("sub/report.json", 7, t0)},
+		wantType:  "com.example.ftp.fileadded",
+		want:      added,
+	}, {
+		name:      "modified",
+		eventType: event_type_modified,
+		entry:     sftpFileInfo{file("sub/report.json", 7, t0)},
+		wantType:  "com.example.ftp.filemodified",
+		want:      added,
+	}, {
+		name:      "deleted",
+		eventType: event_type_deleted,
+		entry:     file("sub/report.json", 7, t0),
+		deleted:   true,
+		wantType:  "com.example.ftp.filedeleted",
+		want:      deleted,
+	}, {
+		name:      "inlined",
+		eventType: event_type,
+		entry:     sftpFileInfo{file("sub/report.json", 7, t0)},
+		inline:    inlineContent{maxSize: 7, encoding: inlineBase64},
+		wantType:  "com.example.ftp.fileadded",
+		want:      inlined,
+	}, {
+		name:      "larger than the maximum size to inline",
+		eventType: event_type,
+		entry:     sftpFileInfo{file("sub/report.json", 7, t0)},
+		inline:    inlineContent{maxSize: 6, encoding: inlineBase64},
+		wantType:  "com.example.ftp.fileadded",
+		want:      added,
+	}, {
+		name:      "grown past the maximum size to inline since it was listed",
+		eventType: event_type,
+		entry:     sftpFileInfo{file("sub/report.json", 5, t0)},
+		inline:    inlineContent{maxSize: 6, encoding: inlineBase64},
+		wantType:  "com.example.ftp.fileadded",
+		want:      grown,
+	}, {
+		name:      "deleted files aren't inlined",
+		eventType: event_type_deleted,
+		entry:     file("sub/report.json", 7, t0),
+		deleted:   true,
+		inline:    inlineContent{maxSize: 7, encoding: inlineBase64},
+		wantType:  "com.example.ftp.filedeleted",
+		want:      deleted,
+	}, {
+		name:       "inlined as the data",
+		eventType:  event_type,
+		entry:      sftpFileInfo{file("sub/report.json", 7, t0)},
+		inline:     inlineContent{maxSize: 7, encoding: inlineBinary},
+		wantType:   "com.example.ftp.fileadded",
+		want:       added,
+		wantBinary: `{"a":1}`,
+	}, {
+		name:       "claim-checked",
+		eventType:  event_type,
+		entry:      sftpFileInfo{file("sub/report.json", 7, t0)},
+		claimCheck: true,
+		wantType:   "com.example.ftp.fileadded",
+		want:       claimChecked,
+		wantStored: map[string]string{key: `{"a":1}`},
+	}, {
+		name:       "inlined rather than claim-checked",
+		eventType:  event_type,
+		entry:      sftpFileInfo{file("sub/report.json", 7, t0)},
+		inline:     inlineContent{maxSize: 7, encoding: inlineBase64},
+		claimCheck: true,
+		wantType:   "com.example.ftp.fileadded",
+		want:       inlined,
+	}, {
+		name:       "deleted files aren't claim-checked",
+		eventType:  event_type_deleted,
+		entry:      file("sub/report.json", 7, t0),
+		deleted:    true,
+		claimCheck: true,
+		wantType:   "com.example.ftp.filedeleted",
+		want:       deleted,
+	}}
+	schema := loadSchema(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newTestPublisher(t)
+			p.inline = test.inline
+			stored := memObjectStore{}
+			if test.claimCheck {
+				p.claimCheck = &claimCheck{store: stored, prefix: "partner"}
+			}
+			var f *remoteFile
+			if !test.deleted {
+				remote := &fakeRemote{files: map[string]string{"/in/sub/report.json": `{"a":1}`}}
+				f = &remoteFile{client: remote, path: "/in/sub/report.json"}
+			}
+
+			event := sendOne(t, p, test.eventType, test.entry, f)
+			if diff := cmp.Diff(test.wantStored, map[string]string(stored), cmpopts.EquateEmpty()); diff != "" {
+				t.Error("Stored (-want, +got):", diff)
+			}
+
+			if got := event.Type(); got != test.wantType {
+				t.Errorf("Type() = %s, want %s", got, test.wantType)
+			}
+			if got, want := event.Source(), "//sftp.example.com:22/in"; got != want {
+				t.Errorf("Source() = %s, want %s", got, want)
+			}
+			if got, want := event.Subject(), "sub/report.json"; got != want {
+				t.Errorf("Subject() = %s, want %s", got, want)
+			}
+			if got, want := event.Extensions()["team"], "billing"; got != want {
+				t.Errorf("team extension = %v, want %s", got, want)
+			}
+			if got, want := event.ID(), eventID(test.wantType, test.want); got != want {
+				t.Errorf("ID() = %s, want %s", got, want)
+			}
+			// Inlined as the data, the content of report.json is JSON too.
+			if got := event.DataContentType(); got != cloudevents.ApplicationJSON {
+				t.Errorf("DataContentType() = %s, want %s", got, cloudevents.ApplicationJSON)
+			}
+
+			if test.wantBinary != "" {
+				if got := string(event.Data()); got != test.wantBinary {
+					t.Errorf("Data() = %s, want %s", got, test.wantBinary)
+				}
+				if got := event.DataSchema(); got != "" {
+					t.Errorf("DataSchema() = %s, want none", got)
+				}
+				// What's known about the file is left to the extensions.
+				want := map[string]interface{}{
+					"team":       "billing",
+					"ftppath":    test.want.Path,
+					"ftpsize":    int32(test.want.Size),
+					"ftpmodtime": cetypes.Timestamp{Time: test.want.ModTime},
+				}
+				if diff := cmp.Diff(want, event.Extensions()); diff != "" {
+					t.Error("Extensions (-want, +got):", diff)
+				}
+				return
+			}
+			if got := event.DataSchema(); got != dataSchema {
+				t.Errorf("DataSchema() = %s, want %s", got, dataSchema)
+			}
+
+			var got FTPFileEvent
+			if err := json.Unmarshal(event.Data(), &got); err != nil {
+				t.Fatalf("Decoding the data: %v", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Error("Data (-want, +got):", diff)
+			}
+			var data interface{}
+			if err := json.Unmarshal(event.Data(), &data); err != nil {
+				t.Fatalf("Decoding the data: %v", err)
+			}
+			for _, err := range validate(schema, data, "data") {
+				t.Error("Data doesn't validate against the schema:", err)
+			}
+		})
+	}
+}
+
+func TestEventID(t *testing.T) {
+	file := FTPFileEvent{
+		Server:   "ftp.example.com:21",
+		FullPath: "/in/a.csv",
+		Size:     10,
+		ModTime:  t0,
+	}
+	id := eventID(event_type, file)
+
+	// The same file is sent with the same ID, wherever the ModTime is.
+	same := file
+	same.ModTime = t0.In(time.FixedZone("CET", 3600))
+	if got := eventID(event_type, same); got != id {
+		t.Errorf("eventID() = %s for the same file, want %s", got, id)
+	}
+
+	others := map[string]func(*FTPFileEvent) (string, FTPFileEvent){
+		"other type": func(d *FTPFileEvent) (string, FTPFileEvent) {
+			return event_type_deleted, *d
+		},
+		"other server": func(d *FTPFileEvent) (string, FTPFileEvent) {
+			d.Server = "ftp.example.org:21"
+			return event_type, *d
+		},
+		"other path": func(d *FTPFileEvent) (string, FTPFileEvent) {
+			d.FullPath = "/in/b.csv"
+			return event_type, *d
+		},
+		"other size": func(d *FTPFileEvent) (string, FTPFileEvent) {
+			d.Size = 11
+			return event_type, *d
+		},
+		"other ModTime": func(d *FTPFileEvent) (string, FTPFileEvent) {
+			d.ModTime = t1
+			return event_type, *d
+		},
+		"other checksum": func(d *FTPFileEvent) (string, FTPFileEvent) {
+			d.ChecksumAlgorithm = checksumSHA256
+			d.Checksum = testSHA256
+			return event_type, *d
+		},
+		// The fields are separated, so they can't be shifted into each other.
+		"fields shifted": func(d *FTPFileEvent) (string, FTPFileEvent) {
+			d.Server = "ftp.example.com:21/in"
+			d.FullPath = "/a.csv"
+			return event_type, *d
+		},
+	}
+	for name, change := range others {
+		t.Run(name, func(t *testing.T) {
+			d := file
+			if got := eventID(change(&d)); got == id {
+				t.Errorf("eventID() = %s, want another ID", got)
+			}
+		})
+	}
+}
+
+func TestPostMessageIDs(t *testing.T) {
+	p := newTestPublisher(t)
+	remote := &fakeRemote{files: map[string]string{"/in/a.csv": "a,b"}}
+	f := &remoteFile{client: remote, path: "/in/a.csv"}
+	send := func(eventType string, entry os.FileInfo) string {
+		return sendOne(t, p, eventType, entry, f).ID()
+	}
+
+	id := send(event_type, file("a.csv", 3, t0))
+	// Sent again, like after a restart, the event keeps its ID.
+	if got := send(event_type, file("a.csv", 3, t0)); got != id {
+		t.Errorf("ID() = %s when sent again, want %s", got, id)
+	}
+	if got := send(event_type_modified, file("a.csv", 3, t1)); got == id {
+		t.Errorf("ID() = %s for the modified file, want another ID", got)
+	}
+	if got := send(event_type_deleted, file("a.csv", 3, t0)); got == id {
+		t.Errorf("ID() = %s for the deleted file, want another ID", got)
+	}
+}
+
+func TestPublisherForTargets(t *testing.T) {
+	setFlags(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+targets:
+- name: acme
+  server: sftp.acme.com:22
+  directories: [/out]
+- name: globex
+  server: ftp.globex.com:21
+  protocol: ftp
+  directories: [/reports]
+  typePrefix: com.globex.ftp
+  sourceTemplate: "ftp://{{ .Host }}{{ .Directory }}"
+  extensions:
+    team: billing
+`
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadWatchConfig(path)
+	if err != nil {
+		t.Fatalf("loadWatchConfig() = %v", err)
+	}
+
+	type attributes struct {
+		Type, Source string
+		Extensions   map[string]interface{}
+	}
+	want := []attributes{{
+		// The flags apply to targets that don't override them.
+		Type:       "org.example.ftp.fileadded",
+		Source:     "ftp://sftp.acme.com:22",
+		Extensions: map[string]interface{}{"team": "ops", "env": "prod"},
+	}, {
+		Type:       "com.globex.ftp.fileadded",
+		Source:     "ftp://ftp.globex.com/reports",
+		Extensions: map[string]interface{}{"team": "billing", "env": "prod"},
+	}}
+	s := &supervisor{publisher: publisher{retry: retryPolicy{maxAttempts: 1}}}
+	var got []attributes
+	for _, target := range c.Targets {
+		p, err := s.publisherFor(target, target.Directories[0])
+		if err != nil {
+			t.Fatalf("publisherFor(%s) = %v", target.Name, err)
+		}
+		event := sendOne(t, p, event_type, file("a.csv", 3, t0), nil)
+		got = append(got, attributes{event.Type(), event.Source(), event.Extensions()})
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error("Event attributes (-want, +got):", diff)
+	}
+}
+
+// nopReporter is a source.StatsReporter reporting nothing.
+type nopReporter struct{}
+
+func (nopReporter) ReportEventCount(*source.ReportArgs, int) error { return nil }
+
+func TestPostMessageCEOverrides(t *testing.T) {
+	received := make(chan cloudevents.Event, 1)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event, err := binding.ToEvent(r.Context(), cehttp.NewMessageFromHttpRequest(r))
+		if err != nil {
+			t.Errorf("Receiving the event: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- *event
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer sink.Close()
+
+	// The client the SinkBinding configures, from K_SINK and K_CE_OVERRIDES.
+	client, err := adapter.NewCloudEventsClient(sink.URL, &duckv1.CloudEventOverrides{
+		Extensions: map[string]string{"team": "platform", "cluster": "east"},
+	}, nopReporter{})
+	if err != nil {
+		t.Fatalf("NewCloudEventsClient() = %v", err)
+	}
+	p := newTestPublisher(t)
+	p.attributes.extensions["env"] = "prod"
+	p.ceClient = client
+	if err := p.postMessage(context.Background(), event_type, file("a.csv", 3, t0), nil); err != nil {
+		t.Fatalf("postMessage() = %v", err)
+	}
+
+	event := <-received
+	// The overrides take precedence over the extensions of the source.
+	want := map[string]string{"team": "platform", "cluster": "east", "env": "prod"}
+	got := map[string]string{}
+	for name := range want {
+		got[name], _ = cetypes.ToString(event.Extensions()[name])
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error("Extensions (-want, +got):", diff)
+	}
+}
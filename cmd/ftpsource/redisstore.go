@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// redisStore keeps the state in a Redis key, which isn't limited in size
+// like ConfigMaps and can be shared by many sources.
+type redisStore struct {
+	pool *redis.Pool
+	name string
+	key  string
+}
+
+var _ keyedStore = (*redisStore)(nil)
+
+func newRedisStore(address, password string, db int, name string) (*redisStore, error) {
+	if address == "" {
+		return nil, fmt.Errorf("missing redis address")
+	}
+	pool := &redis.Pool{
+		MaxIdle:     1,
+		IdleTimeout: 5 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", address,
+				redis.DialPassword(password),
+				redis.DialDatabase(db),
+				redis.DialConnectTimeout(10*time.Second),
+				redis.DialReadTimeout(30*time.Second),
+				redis.DialWriteTimeout(30*time.Second))
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+	return &redisStore{pool: pool, name: name, key: "ftpsource/" + name + "/" + configdatakey}, nil
+}
+
+// withKey implements keyedStore.
+func (s *redisStore) withKey(key string) sharedStore {
+	return &redisStore{pool: s.pool, name: s.name, key: "ftpsource/" + s.name + "/" + key}
+}
+
+// Load implements StateStore.
+func (s *redisStore) Load(ctx context.Context) (*configdata, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	b, err := redis.Bytes(conn.Do("GET", s.key))
+	if err != nil && err != redis.ErrNil {
+		return nil, err
+	}
+	return unmarshalState(b)
+}
+
+// Save implements StateStore.
+func (s *redisStore) Save(ctx context.Context, data *configdata) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Do("SET", s.key, b)
+	return err
+}
+
+// update implements sharedStore, with a transaction that's retried when the
+// key was saved concurrently.
+func (s *redisStore) update(ctx context.Context, fn func(*configdata)) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	// Closing the connection unwatches the key.
+	defer conn.Close()
+	for {
+		if _, err := conn.Do("WATCH", s.key); err != nil {
+			return err
+		}
+		b, err := redis.Bytes(conn.Do("GET", s.key))
+		if err != nil && err != redis.ErrNil {
+			return err
+		}
+		data, err := unmarshalState(b)
+		if err != nil {
+			return err
+		}
+		fn(data)
+		if b, err = json.Marshal(data); err != nil {
+			return err
+		}
+		if err := conn.Send("MULTI"); err != nil {
+			return err
+		}
+		if err := conn.Send("SET", s.key, b); err != nil {
+			return err
+		}
+		reply, err := conn.Do("EXEC")
+		if err != nil {
+			return err
+		}
+		if reply != nil {
+			return nil
+		}
+		// The key was saved since we watched it.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/secsy/goftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// remoteFile is a file on the server, for handlers that need more than its
+// metadata.
+type remoteFile struct {
+	client remote
+	// Path of the file on the server.
+	path string
+	// Checksum computed while the file is read, if we compute them.
+	sum *fileHash
+	// unchanged is given the checksum of a modified file, like
+	// sha256:9f86d0..., and returns true if it's the one the file was
+	// processed with before, in which case no event is sent for it. Nil
+	// if the file is new.
+	unchanged func(checksum string) bool
+	// checksum is set to the checksum of the file once it's computed.
+	checksum string
+}
+
+// open opens the file for reading.
+func (f *remoteFile) open() (io.ReadCloser, error) {
+	r, err := f.client.open(f.path)
+	if err != nil || f.sum == nil {
+		return r, err
+	}
+	return f.sum.reader(r), nil
+}
+
+// notFound returns true if the error says a file doesn't exist.
+func notFound(err error) bool {
+	var ftpErr goftp.Error
+	if errors.As(err, &ftpErr) {
+		return ftpErr.Code() == 550
+	}
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// remote is an open connection to the server we watch.
+type remote interface {
+	ReadDir(dir string) ([]os.FileInfo, error)
+	Rename(from, to string) error
+	Remove(path string) error
+	MkdirAll(dir string) error
+	Close() error
+
+	// open opens the file for reading.
+	open(path string) (io.ReadCloser, error)
+	// checksum asks the server for the checksum of the file with the given
+	// algorithm, and returns errChecksumUnsupported if it can't tell.
+	checksum(path, algorithm string) (string, error)
+
+	// keepalive checks the connection is still usable, and keeps the
+	// server from closing it for being idle.
+	keepalive() error
+}
+
+// ftpRemote is a connection to an FTP or FTPS server. goftp keeps a pool of
+// control connections open itself, and reuses them between commands.
+type ftpRemote struct {
+	*goftp.Client
+}
+
+func (r *ftpRemote) Remove(path string) error {
+	return r.Delete(path)
+}
+
+// MkdirAll creates dir along with any missing parents. FTP has no portable
+// way to tell whether a directory exists, so we create every level and
+// ignore the errors for the ones that already do. Creating the last level
+// failing shows up when using the directory.
+func (r *ftpRemote) MkdirAll(dir string) error {
+	p := ""
+	for _, elem := range strings.Split(path.Clean(dir), "/") {
+		if elem == "" {
+			p = "/"
+			continue
+		}
+		p = path.Join(p, elem)
+		r.Mkdir(p)
+	}
+	return nil
+}
+
+// open streams the file through a pipe, since goftp only retrieves files
+// into writers. Closing the reader early aborts the transfer.
+func (r *ftpRemote) open(path string) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(r.Retrieve(path, pw))
+	}()
+	return pr, nil
+}
+
+// The names of the checksum algorithms in the HASH command, and the older
+// commands for them.
+var (
+	ftpHashNames    = map[string]string{checksumSHA256: "SHA-256", checksumMD5: "MD5", checksumCRC32: "CRC32"}
+	ftpHashCommands = map[string]string{checksumSHA256: "XSHA256", checksumMD5: "XMD5", checksumCRC32: "XCRC"}
+)
+
+// checksum tries the HASH command first, and falls back to the older X
+// commands. They run on a connection of their own, since selecting the
+// algorithm for HASH changes the state of the connection.
+func (r *ftpRemote) checksum(path, algorithm string) (string, error) {
+	conn, err := r.OpenRawConn()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if code, _, err := conn.SendCommand("OPTS HASH %s", ftpHashNames[algorithm]); err != nil {
+		return "", err
+	} else if code == 200 {
+		code, msg, err := conn.SendCommand("HASH %s", path)
+		if err != nil {
+			return "", err
+		}
+		// 213 <algorithm> <start>-<end> <checksum> <path>
+		if f := strings.Fields(msg); code == 213 && len(f) >= 3 {
+			if sum := parseChecksumColumn(f[2], algorithm); sum != "" {
+				return sum, nil
+			}
+		}
+	}
+
+	code, msg, err := conn.SendCommand("%s %s", ftpHashCommands[algorithm], path)
+	if err != nil {
+		return "", err
+	}
+	if code/100 == 2 {
+		// The reply is either just the checksum, or has the name of the
+		// file, or the range the checksum is of, next to it.
+		f := strings.Fields(msg)
+		sum := parseChecksum(f, algorithm)
+		if len(f) == 1 {
+			sum = parseChecksumColumn(f[0], algorithm)
+		}
+		if sum != "" {
+			return sum, nil
+		}
+	}
+	return "", errChecksumUnsupported
+}
+
+func (r *ftpRemote) keepalive() error {
+	_, err := r.Getwd()
+	return err
+}
+
+// sftpRemote is a connection to an SFTP server, multiplexed over an SSH
+// connection that's closed along with it.
+type sftpRemote struct {
+	*sftp.Client
+	conn *ssh.Client
+	// Set once the server told us it can't compute checksums, so we don't
+	// ask again for every file.
+	noCheckFile int32
+}
+
+func (r *sftpRemote) Close() error {
+	err := r.Client.Close()
+	if cerr := r.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (r *sftpRemote) open(path string) (io.ReadCloser, error) {
+	return r.Open(path)
+}
+
+// checksum asks the server with the check-file extension, on an SFTP session
+// of its own. Servers like ProFTPD support it, OpenSSH doesn't.
+func (r *sftpRemote) checksum(path, algorithm string) (string, error) {
+	if atomic.LoadInt32(&r.noCheckFile) != 0 {
+		return "", errChecksumUnsupported
+	}
+	session, err := r.conn.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return "", err
+	}
+	sum, err := checkFile(struct {
+		io.Reader
+		io.Writer
+	}{stdout, stdin}, path, algorithm)
+	if errors.Is(err, errChecksumUnsupported) {
+		atomic.StoreInt32(&r.noCheckFile, 1)
+	}
+	return sum, err
+}
+
+// keepalive waits at most dialTimeout for the reply, since the server doesn't
+// always answer once the connection has silently dropped.
+func (r *sftpRemote) keepalive() error {
+	errc := make(chan error, 1)
+	go func() {
+		_, _, err := r.conn.SendRequest("keepalive@openssh.com", true, nil)
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(dialTimeout):
+		return errors.New("keepalive timed out")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// s3Store copies files to a bucket on S3 or an S3 compatible store. The
+// credentials are taken from the environment, AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY, like every other AWS client does.
+type s3Store struct {
+	client   *s3.S3
+	uploader *s3manager.Uploader
+	bucket   string
+}
+
+var _ objectStore = (*s3Store)(nil)
+
+func newS3Store(bucket, endpoint, region string, pathStyle bool) (*s3Store, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("missing bucket to copy files to")
+	}
+	config := aws.NewConfig().WithS3ForcePathStyle(pathStyle)
+	if endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+	if region != "" {
+		config = config.WithRegion(region)
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating s3 session: %w", err)
+	}
+	client := s3.New(sess)
+	return &s3Store{client: client, uploader: s3manager.NewUploaderWithClient(client), bucket: bucket}, nil
+}
+
+// put streams the content to the bucket, in several parts for large files,
+// and reads back the ETag and size of the object.
+func (s *s3Store) put(ctx context.Context, key string, r io.Reader, contentType string) (*FTPObject, error) {
+	out, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        r,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("uploading s3://%s/%s: %w", s.bucket, key, err)
+	}
+	head, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:    aws.String(s.bucket),
+		Key:       aws.String(key),
+		VersionId: out.VersionID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting s3://%s/%s: %w", s.bucket, key, err)
+	}
+	return &FTPObject{
+		URL:       out.Location,
+		Key:       key,
+		ETag:      aws.StringValue(head.ETag),
+		Size:      aws.Int64Value(head.ContentLength),
+		VersionID: aws.StringValue(out.VersionID),
+	}, nil
+}
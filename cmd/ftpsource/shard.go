@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
+	"knative.dev/pkg/hash"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/reconciler"
+	"knative.dev/pkg/system"
+)
+
+// membersLabel labels the Leases the replicas of an adapter announce
+// themselves with, with the name of the Lease of the adapter.
+const membersLabel = "ftpsource.aikas.org/members"
+
+// newShards returns the buckets the work is sharded into, named after their
+// Leases, like ftpsource-shard-03-of-10. Work is assigned to buckets by
+// consistent hashing, so the assignment only depends on the number of
+// shards, not on the number of replicas.
+func newShards(leaseName string, n int) []reconciler.Bucket {
+	if n <= 1 {
+		return nil
+	}
+	names := make(sets.String, n)
+	for i := 0; i < n; i++ {
+		names.Insert(fmt.Sprintf("%s-shard-%02d-of-%02d", leaseName, i, n))
+	}
+	return hash.NewBucketSet(names).Buckets()
+}
+
+// shardElector spreads the shards over the replicas of the adapter. Every
+// shard has a Lease, and a replica watches the shards it holds the Lease of.
+// Every replica also holds a Lease of its own, so the replicas know how many
+// of them there are, and only compete for shards while they hold less than
+// their share of them. Replicas holding more than their share, since others
+// were added, release the extra shards, so the shards are rebalanced when
+// scaling up as well as down.
+//
+// Unlike the electors of knative.dev/pkg/leaderelection, which hold on to
+// their buckets until they fail, this one can give up single shards.
+type shardElector struct {
+	client   kubernetes.Interface
+	cfg      leaderConfig
+	la       reconciler.LeaderAware
+	identity string
+	// The name of the Pod, which names the Lease of the replica.
+	member string
+
+	mu      sync.Mutex
+	held    map[string]bool
+	members int
+}
+
+func newShardElector(ctx context.Context, cfg leaderConfig, la reconciler.LeaderAware, identity string) (*shardElector, error) {
+	member, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return &shardElector{
+		client:   kubeclient.Get(ctx),
+		cfg:      cfg,
+		la:       la,
+		identity: identity,
+		member:   strings.ToLower(member),
+		held:     make(map[string]bool, len(cfg.shards)),
+		members:  1,
+	}, nil
+}
+
+// Run competes for the shards until ctx is done.
+func (e *shardElector) Run(ctx context.Context) {
+	logging.FromContext(ctx).Info("Sharding the work", zap.Int("shards", len(e.cfg.shards)), zap.String("identity", e.identity))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		e.announce(ctx)
+	}()
+	for _, b := range e.cfg.shards {
+		b := b
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.elect(ctx, b)
+		}()
+	}
+	wg.Wait()
+}
+
+// share returns the number of shards we should hold.
+func (e *shardElector) share() int {
+	n := len(e.cfg.shards)
+	return (n + e.members - 1) / e.members
+}
+
+// wantsMore returns true while we hold less than our share of the shards.
+func (e *shardElector) wantsMore() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.held) < e.share()
+}
+
+// holds returns true if we hold the shard.
+func (e *shardElector) holds(b reconciler.Bucket) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.held[b.Name()]
+}
+
+// elect competes for the Lease of the shard until ctx is done, as long as
+// we hold less than our share.
+func (e *shardElector) elect(ctx context.Context, b reconciler.Bucket) {
+	logger := logging.FromContext(ctx).With(zap.String("shard", b.Name()))
+	for {
+		if wait.PollImmediateUntil(e.cfg.retryPeriod, func() (bool, error) { return e.wantsMore(), nil }, ctx.Done()) != nil {
+			return
+		}
+
+		term, cancel := context.WithCancel(ctx)
+		le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock: &resourcelock.LeaseLock{
+				LeaseMeta:  metav1.ObjectMeta{Namespace: system.Namespace(), Name: b.Name()},
+				Client:     e.client.CoordinationV1(),
+				LockConfig: resourcelock.ResourceLockConfig{Identity: e.identity},
+			},
+			LeaseDuration: e.cfg.leaseDuration,
+			RenewDeadline: e.cfg.renewDeadline,
+			RetryPeriod:   e.cfg.retryPeriod,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(lctx context.Context) { e.promote(lctx, logger, b) },
+				OnStoppedLeading: func() { e.demote(logger, b) },
+			},
+			ReleaseOnCancel: true,
+			Name:            e.identity,
+		})
+		if err != nil {
+			cancel()
+			logger.Error("Failed to create the elector of the shard:", zap.Error(err))
+			return
+		}
+
+		// Stop competing once we hold our share, and give up the shard
+		// once we hold more than that. The watchers of the shard are
+		// stopped, saving their state, before the Lease is released, so
+		// whoever takes over carries on where we stopped.
+		go func() {
+			wait.PollUntil(e.cfg.retryPeriod, func() (bool, error) {
+				if e.holds(b) {
+					return e.shed(logger, b), nil
+				}
+				return !e.wantsMore(), nil
+			}, term.Done())
+			cancel()
+		}()
+		le.Run(term)
+		cancel()
+	}
+}
+
+// promote starts watching the shard, unless we lost its Lease again in the
+// meantime.
+func (e *shardElector) promote(ctx context.Context, logger *zap.SugaredLogger, b reconciler.Bucket) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	logger.Info("Acquired the shard")
+	e.held[b.Name()] = true
+	if err := e.la.Promote(b, nil); err != nil {
+		logger.Error("Failed to promote:", zap.Error(err))
+	}
+}
+
+// demote stops watching the shard, if we still do.
+func (e *shardElector) demote(logger *zap.SugaredLogger, b reconciler.Bucket) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.held[b.Name()] {
+		return
+	}
+	logger.Info("Lost the shard")
+	delete(e.held, b.Name())
+	e.la.Demote(b)
+}
+
+// shed stops watching the shard if we hold more than our share, returning
+// true if we did.
+func (e *shardElector) shed(logger *zap.SugaredLogger, b reconciler.Bucket) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.held[b.Name()] || len(e.held) <= e.share() {
+		return false
+	}
+	logger.Info("Releasing the shard to rebalance", zap.Int("members", e.members), zap.Int("held", len(e.held)))
+	delete(e.held, b.Name())
+	e.la.Demote(b)
+	return true
+}
+
+// announce renews the Lease of this replica until ctx is done, counting the
+// replicas that renewed theirs within the lease duration. Leases of replicas
+// that are long gone are deleted.
+func (e *shardElector) announce(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	leases := e.client.CoordinationV1().Leases(system.Namespace())
+	name := e.cfg.name + "-member-" + e.member
+	seconds := int32(e.cfg.leaseDuration.Seconds())
+
+	wait.Until(func() {
+		now := metav1.NewMicroTime(time.Now())
+		lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			_, err = leases.Create(ctx, &coordinationv1.Lease{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{membersLabel: e.cfg.name}},
+				Spec: coordinationv1.LeaseSpec{
+					HolderIdentity:       &e.identity,
+					LeaseDurationSeconds: &seconds,
+					RenewTime:            &now,
+				},
+			}, metav1.CreateOptions{})
+		case err == nil:
+			lease.Spec.HolderIdentity = &e.identity
+			lease.Spec.LeaseDurationSeconds = &seconds
+			lease.Spec.RenewTime = &now
+			_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			logger.Error("Failed to renew the Lease of the replica:", zap.Error(err))
+			return
+		}
+
+		list, err := leases.List(ctx, metav1.ListOptions{LabelSelector: membersLabel + "=" + e.cfg.name})
+		if err != nil {
+			logger.Error("Failed to list the replicas:", zap.Error(err))
+			return
+		}
+		members := 0
+		for _, l := range list.Items {
+			if l.Spec.RenewTime == nil || l.Spec.LeaseDurationSeconds == nil {
+				continue
+			}
+			expiry := l.Spec.RenewTime.Add(time.Duration(*l.Spec.LeaseDurationSeconds) * time.Second)
+			switch {
+			case now.Time.Before(expiry):
+				members++
+			case now.Time.Sub(expiry) > 10*e.cfg.leaseDuration:
+				if err := leases.Delete(ctx, l.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+					logger.Warn("Failed to delete the Lease of a departed replica:", zap.String("lease", l.Name), zap.Error(err))
+				}
+			}
+		}
+		if members == 0 {
+			members = 1
+		}
+
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if members != e.members {
+			logger.Info("Replicas changed, rebalancing", zap.Int("members", members), zap.Int("shards", len(e.cfg.shards)))
+			e.members = members
+		}
+	}, e.cfg.retryPeriod, ctx.Done())
+
+	// We're shutting down, so the others don't need to wait for our Lease
+	// to expire to rebalance.
+	dctx, cancel := context.WithTimeout(context.Background(), e.cfg.renewDeadline)
+	defer cancel()
+	if err := leases.Delete(dctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		logger.Warn("Failed to delete the Lease of the replica:", zap.Error(err))
+	}
+}
+
+// shardUnit returns the key of the unit of work the files of a subdirectory
+// of a watched directory belong to, which is sharded as a whole. The files
+// directly in the watched directory make the unit of sub "".
+func shardUnit(target, dir, sub string) types.NamespacedName {
+	return types.NamespacedName{Namespace: target, Name: path.Join(dir, sub)}
+}
+
+// shardStore keeps the state of the files of a shard of a directory in the
+// state of the whole directory, which all its shards share, each updating
+// the files of its own subdirectories. So the state doesn't depend on the
+// number of shards, which can be changed without sending events again, and
+// sharding a directory picks up where watching it as a whole left off.
+//
+// The high water mark older versions kept is the whole directory's, so the
+// state is migrated once for the whole directory, before any of its shards
+// update it.
+type shardStore struct {
+	whole sharedStore
+	// owns returns true for the paths of the files of the shard.
+	owns func(name string) bool
+}
+
+var _ StateStore = (*shardStore)(nil)
+
+// Load implements StateStore, returning the state of the files of the shard,
+// or the state of the whole directory as it is if it wasn't migrated yet.
+func (s *shardStore) Load(ctx context.Context) (*configdata, error) {
+	data, err := s.whole.Load(ctx)
+	if err != nil || data.Files == nil {
+		return data, err
+	}
+	files := data.Files
+	data.Files = make(map[string]fileState)
+	for name, fs := range files {
+		if s.owns(name) {
+			data.Files[name] = fs
+		}
+	}
+	return data, nil
+}
+
+// Save implements StateStore, replacing the files of the shard in the state
+// of the whole directory, next to the files of the other shards.
+func (s *shardStore) Save(ctx context.Context, data *configdata) error {
+	return s.whole.update(ctx, func(whole *configdata) {
+		if whole.Files == nil {
+			whole.Files = make(map[string]fileState, len(data.Files))
+		}
+		// The directory has been migrated by now.
+		whole.LastFileProcessed = ""
+		whole.LastModTime = nil
+		for name := range whole.Files {
+			if s.owns(name) {
+				delete(whole.Files, name)
+			}
+		}
+		for name, fs := range data.Files {
+			if s.owns(name) {
+				whole.Files[name] = fs
+			}
+		}
+	})
+}
+
+// migrate migrates the state older versions kept for the whole directory,
+// given the listing of all of its files, unless another shard got to it
+// first.
+func (s *shardStore) migrate(ctx context.Context, entries []os.FileInfo) error {
+	return s.whole.update(ctx, func(whole *configdata) {
+		whole.migrate(entries)
+	})
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.opencensus.io/trace"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// shardStores returns the stores of the n shards of /in, sharing whole.
+func shardStores(whole sharedStore, n int) []*shardStore {
+	var stores []*shardStore
+	for _, b := range newShards("ftpsource", n) {
+		b := b
+		owns := func(sub string) bool { return b.Has(shardUnit("target", "/in", sub)) }
+		stores = append(stores, &shardStore{
+			whole: whole,
+			owns:  func(name string) bool { return owns(shardOf(name)) },
+		})
+	}
+	return stores
+}
+
+// processAll has every shard process the files it owns, all at once.
+func processAll(t *testing.T, stores []*shardStore, files []string) {
+	t.Helper()
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make([]error, len(stores))
+	for i, s := range stores {
+		i, s := i, s
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := s.Load(ctx)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if data.Files == nil {
+				data.Files = make(map[string]fileState)
+			}
+			for _, name := range files {
+				if s.owns(name) {
+					data.Files[name] = fileState{Size: 1, ModTime: t0}
+				}
+			}
+			errs[i] = s.Save(ctx, data)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("processing the files of a shard: %v", err)
+		}
+	}
+}
+
+func TestNewShards(t *testing.T) {
+	if got := newShards("ftpsource", 1); got != nil {
+		t.Errorf("newShards(1) = %v, want no shards", got)
+	}
+
+	shards := newShards("ftpsource", 3)
+	var got []string
+	for _, b := range shards {
+		got = append(got, b.Name())
+	}
+	sort.Strings(got)
+	want := []string{"ftpsource-shard-00-of-03", "ftpsource-shard-01-of-03", "ftpsource-shard-02-of-03"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("newShards() (-want, +got): %s", diff)
+	}
+
+	// Every unit of work belongs to exactly one shard, and units spread
+	// over all of them.
+	used := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		unit := shardUnit("target", "/in", fmt.Sprintf("sub%02d", i))
+		var owners []string
+		for _, b := range shards {
+			if b.Has(unit) {
+				owners = append(owners, b.Name())
+			}
+		}
+		if len(owners) != 1 {
+			t.Errorf("%v belongs to %v, want one shard", unit, owners)
+			continue
+		}
+		used[owners[0]] = true
+	}
+	if len(used) != len(shards) {
+		t.Errorf("units spread over %d shards, want %d", len(used), len(shards))
+	}
+}
+
+func TestShardUnit(t *testing.T) {
+	tests := []struct {
+		target, dir, sub string
+		want             types.NamespacedName
+	}{
+		{"target", "/in", "x", types.NamespacedName{Namespace: "target", Name: "/in/x"}},
+		{"target", "/in", "", types.NamespacedName{Namespace: "target", Name: "/in"}},
+		{"", "/in/", "x", types.NamespacedName{Name: "/in/x"}},
+	}
+	for _, test := range tests {
+		if got := shardUnit(test.target, test.dir, test.sub); got != test.want {
+			t.Errorf("shardUnit(%q, %q, %q) = %v, want %v", test.target, test.dir, test.sub, got, test.want)
+		}
+	}
+	// The same subdirectory of other targets or directories is another unit.
+	if shardUnit("a", "/in", "x") == shardUnit("b", "/in", "x") || shardUnit("a", "/in", "x") == shardUnit("a", "/out", "x") {
+		t.Error("units of different targets or directories are the same")
+	}
+}
+
+func TestShardStoreResharding(t *testing.T) {
+	ctx := context.Background()
+	whole := &memStore{}
+	before := []string{"top.csv"}
+	after := []string{"top2.csv"}
+	for i := 0; i < 20; i++ {
+		before = append(before, fmt.Sprintf("sub%02d/a.csv", i))
+		after = append(after, fmt.Sprintf("sub%02d/b.csv", i))
+	}
+	all := append(append([]string{}, before...), after...)
+	sort.Strings(all)
+
+	processAll(t, shardStores(whole, 2), before)
+
+	// After resharding, every file processed before belongs to exactly
+	// one of the new shards, so none is sent again.
+	stores := shardStores(whole, 3)
+	seen := make(map[string]int)
+	for _, s := range stores {
+		data, err := s.Load(ctx)
+		if err != nil {
+			t.Fatalf("Load() = %v", err)
+		}
+		for name := range data.Files {
+			if !s.owns(name) {
+				t.Errorf("shard loaded %q it doesn't own", name)
+			}
+			seen[name]++
+		}
+	}
+	for _, name := range before {
+		if seen[name] != 1 {
+			t.Errorf("%q loaded by %d shards, want 1", name, seen[name])
+		}
+	}
+
+	// The new shards save next to each other.
+	processAll(t, stores, after)
+	data, err := whole.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	var got []string
+	for name := range data.Files {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	if diff := cmp.Diff(all, got); diff != "" {
+		t.Errorf("Files (-want, +got): %s", diff)
+	}
+}
+
+func TestShardStoreForgetsFiles(t *testing.T) {
+	ctx := context.Background()
+	whole := &memStore{}
+	stores := shardStores(whole, 2)
+	files := []string{"top.csv", "sub00/a.csv", "sub01/a.csv", "sub02/a.csv", "sub03/a.csv"}
+	processAll(t, stores, files)
+
+	// A shard deleting its files from its state leaves the others alone.
+	s := stores[0]
+	data, err := s.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	data.Files = map[string]fileState{}
+	if err := s.Save(ctx, data); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+	if data, err = whole.Load(ctx); err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	for _, name := range files {
+		if _, ok := data.Files[name]; ok == s.owns(name) {
+			t.Errorf("%q kept: %v, want %v", name, ok, !s.owns(name))
+		}
+	}
+}
+
+func TestShardsMigrateOnce(t *testing.T) {
+	ctx := context.Background()
+	whole := &memStore{}
+	// Older versions sent everything up to t1.
+	if err := whole.Save(ctx, &configdata{LastFileProcessed: "sub00/a.csv", LastModTime: &t1}); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+	buckets := newShards("ftpsource", 2)
+	owner := func(sub string) int {
+		for i, b := range buckets {
+			if b.Has(shardUnit("target", "/in", sub)) {
+				return i
+			}
+		}
+		return -1
+	}
+	// Find a subdirectory of each shard.
+	subs := make([]string, len(buckets))
+	for i := 0; subs[0] == "" || subs[1] == ""; i++ {
+		sub := fmt.Sprintf("sub%02d", i)
+		if subs[owner(sub)] == "" {
+			subs[owner(sub)] = sub
+		}
+	}
+
+	// Only the first shard has files.
+	tree := map[string][]os.FileInfo{
+		"/in":            {dirInfo(subs[0]), dirInfo(subs[1])},
+		"/in/" + subs[0]: {file("a.csv", 1, t0)},
+		"/in/" + subs[1]: nil,
+	}
+	client := &treeRemote{tree: tree}
+	var sent []string
+	watchers := make([]*watcher, len(buckets))
+	for i, b := range buckets {
+		b := b
+		owns := func(sub string) bool { return b.Has(shardUnit("target", "/in", sub)) }
+		watchers[i] = &watcher{
+			dir:        "/in",
+			recursive:  true,
+			shard:      owns,
+			filter:     &fileFilter{},
+			stability:  newStabilityCheck(0, 0, nil),
+			postAction: &postAction{action: postActionNone},
+			store: &shardStore{
+				whole: whole,
+				owns:  func(name string) bool { return owns(shardOf(name)) },
+			},
+			handler: func(ctx context.Context, eventType string, e os.FileInfo, f *remoteFile) error {
+				sent = append(sent, e.Name())
+				return nil
+			},
+		}
+	}
+	probe := func(w *watcher) {
+		t.Helper()
+		entries, unlisted, err := w.listFiles(ctx, client.ReadDir)
+		if err != nil {
+			t.Fatalf("listFiles() = %v", err)
+		}
+		w.processFiles(ctx, client, entries, unlisted, trace.SpanContext{})
+	}
+
+	// Both shards probe, the files sent by older versions aren't sent again.
+	probe(watchers[0])
+	probe(watchers[1])
+	if len(sent) != 0 {
+		t.Errorf("sent %v, want nothing", sent)
+	}
+	data, err := whole.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if data.LastModTime != nil || data.LastFileProcessed != "" {
+		t.Errorf("high water mark %v, %q still there after migrating", data.LastModTime, data.LastFileProcessed)
+	}
+
+	// A file older than the old high water mark is uploaded to the shard
+	// without files, and sent all the same, every time.
+	for i := 0; i < 2; i++ {
+		sent = nil
+		name := fmt.Sprintf("old%d.csv", i)
+		tree["/in/"+subs[1]] = append(tree["/in/"+subs[1]], file(name, 1, t0))
+		probe(watchers[1])
+		if diff := cmp.Diff([]string{subs[1] + "/" + name}, sent); diff != "" {
+			t.Errorf("sent (-want, +got): %s", diff)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// pendingFile is a file waiting for its upload to complete.
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	// When we first saw the file with this size and ModTime, and in how
+	// many consecutive probes we've seen it unchanged since.
+	since  time.Time
+	probes int
+}
+
+// stabilityCheck detects when the upload of a file has completed, so that
+// we don't send events for half uploaded files. A file is considered
+// complete once its size and ModTime have been unchanged for the given
+// number of consecutive probes and for the quiet period, and, if marker
+// suffixes are given, a marker file (for example foo.csv.done for foo.csv)
+// exists next to it.
+//
+// Pending files are only tracked in memory, after a restart files just wait
+// for their stability window again.
+type stabilityCheck struct {
+	probes         int
+	quietPeriod    time.Duration
+	markerSuffixes []string
+
+	pending map[string]pendingFile
+}
+
+func newStabilityCheck(probes int, quietPeriod time.Duration, markerSuffixes []string) *stabilityCheck {
+	return &stabilityCheck{
+		probes:         probes,
+		quietPeriod:    quietPeriod,
+		markerSuffixes: markerSuffixes,
+		pending:        make(map[string]pendingFile),
+	}
+}
+
+// isMarker returns true if the file is a marker file. We never send events
+// for marker files themselves.
+func (c *stabilityCheck) isMarker(name string) bool {
+	for _, suffix := range c.markerSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// complete returns true if the upload of the file has completed. It must be
+// called once per probe for every file that's waiting to be sent.
+func (c *stabilityCheck) complete(e os.FileInfo, present map[string]bool, now time.Time) bool {
+	name := e.Name()
+	p, ok := c.pending[name]
+	if !ok || p.size != e.Size() || !p.modTime.Equal(e.ModTime()) {
+		p = pendingFile{size: e.Size(), modTime: e.ModTime(), since: now}
+	}
+	p.probes++
+	c.pending[name] = p
+
+	if p.probes < c.probes || now.Sub(p.since) < c.quietPeriod {
+		return false
+	}
+	if len(c.markerSuffixes) == 0 {
+		return true
+	}
+	for _, suffix := range c.markerSuffixes {
+		if present[name+suffix] {
+			return true
+		}
+	}
+	return false
+}
+
+// done forgets about a file once it has been sent.
+func (c *stabilityCheck) done(name string) {
+	delete(c.pending, name)
+}
+
+// prune forgets about pending files that are no longer on the server.
+func (c *stabilityCheck) prune(present map[string]bool) {
+	for name := range c.pending {
+		if !present[name] {
+			delete(c.pending, name)
+		}
+	}
+}
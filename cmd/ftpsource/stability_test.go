@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStabilityCheckComplete(t *testing.T) {
+	// A probe of the stability check: the listing entry of the file at the
+	// given time, and whether its upload should be complete.
+	type probe struct {
+		entry   os.FileInfo
+		at      time.Duration
+		markers []string
+		want    bool
+	}
+	tests := []struct {
+		name           string
+		probes         int
+		quietPeriod    time.Duration
+		markerSuffixes []string
+		seq            []probe
+	}{{
+		name:   "complete when first seen",
+		probes: 1,
+		seq: []probe{
+			{entry: file("a.csv", 10, t0), want: true},
+		},
+	}, {
+		name:   "unchanged for consecutive probes",
+		probes: 3,
+		seq: []probe{
+			{entry: file("a.csv", 10, t0)},
+			{entry: file("a.csv", 10, t0), at: time.Second},
+			{entry: file("a.csv", 10, t0), at: 2 * time.Second, want: true},
+		},
+	}, {
+		name:   "growing file starts over",
+		probes: 2,
+		seq: []probe{
+			{entry: file("a.csv", 10, t0)},
+			{entry: file("a.csv", 20, t0), at: time.Second},
+			{entry: file("a.csv", 20, t0), at: 2 * time.Second, want: true},
+		},
+	}, {
+		name:   "touched file starts over",
+		probes: 2,
+		seq: []probe{
+			{entry: file("a.csv", 10, t0)},
+			{entry: file("a.csv", 10, t1), at: time.Second},
+			{entry: file("a.csv", 10, t1), at: 2 * time.Second, want: true},
+		},
+	}, {
+		name:        "quiet period",
+		probes:      1,
+		quietPeriod: time.Minute,
+		seq: []probe{
+			{entry: file("a.csv", 10, t0)},
+			{entry: file("a.csv", 10, t0), at: 30 * time.Second},
+			{entry: file("a.csv", 10, t0), at: time.Minute, want: true},
+		},
+	}, {
+		name:           "waits for the marker",
+		probes:         1,
+		markerSuffixes: []string{".done", ".ok"},
+		seq: []probe{
+			{entry: file("a.csv", 10, t0)},
+			{entry: file("a.csv", 10, t0), at: time.Second, markers: []string{"b.csv.ok"}},
+			{entry: file("a.csv", 10, t0), at: 2 * time.Second, markers: []string{"a.csv.ok"}, want: true},
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newStabilityCheck(test.probes, test.quietPeriod, test.markerSuffixes)
+			for i, p := range test.seq {
+				present := map[string]bool{p.entry.Name(): true}
+				for _, m := range p.markers {
+					present[m] = true
+				}
+				if got := c.complete(p.entry, present, t2.Add(p.at)); got != p.want {
+					t.Errorf("probe %d: complete() = %v, want %v", i, got, p.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStabilityCheckForgets(t *testing.T) {
+	c := newStabilityCheck(2, 0, nil)
+	a, b := file("a.csv", 10, t0), file("b.csv", 10, t0)
+	present := map[string]bool{"a.csv": true, "b.csv": true}
+	c.complete(a, present, t2)
+	c.complete(b, present, t2)
+
+	// Once sent, a file uploaded again waits for its own stability window.
+	c.done("a.csv")
+	if c.complete(a, present, t2) {
+		t.Error("complete() = true for a file that was sent, want false")
+	}
+	// Files that disappear while pending start over if they come back.
+	c.prune(map[string]bool{"a.csv": true})
+	if _, ok := c.pending["b.csv"]; ok {
+		t.Error("prune() kept b.csv, which is no longer on the server")
+	}
+	if _, ok := c.pending["a.csv"]; !ok {
+		t.Error("prune() dropped a.csv, which is still on the server")
+	}
+}
+
+func TestStabilityCheckIsMarker(t *testing.T) {
+	c := newStabilityCheck(1, 0, []string{".done"})
+	for name, want := range map[string]bool{
+		"a.csv.done":     true,
+		"sub/a.csv.done": true,
+		"a.csv":          false,
+		"done":           false,
+	} {
+		if got := c.isMarker(name); got != want {
+			t.Errorf("isMarker(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// fileState is what we remember about a file we have already processed.
+// Together with the path it's keyed by, Size and ModTime make up the identity
+// of the file.
+type fileState struct {
+	Size    int64
+	ModTime time.Time
+	// Hash is the checksum of the file, like sha256:9f86d0..., when we
+	// compute them. A file whose size or ModTime changed but whose checksum
+	// didn't, like one that was touched or uploaded again as is, isn't sent
+	// again.
+	Hash string `json:",omitempty"`
+
+	// GoneSince is set to when we noticed the file was deleted. Entries
+	// for files that have been gone for longer than the retention period
+	// are compacted away.
+	GoneSince *time.Time `json:",omitempty"`
+
+	// Action describes what the post delivery action did with the file.
+	// PendingAction is set while the action failed, and is retried.
+	Action        string `json:",omitempty"`
+	PendingAction bool   `json:",omitempty"`
+}
+
+type configdata struct {
+	// Files we have already processed, keyed by path.
+	Files map[string]fileState
+
+	// SHA256 fingerprints of the host keys we trusted on first use, keyed
+	// by host:port.
+	HostKeys map[string]string `json:",omitempty"`
+
+	// LastFileProcessed and LastModTime are the high water mark kept by
+	// older versions. They are only read to seed Files when migrating.
+	LastFileProcessed string     `json:",omitempty"`
+	LastModTime       *time.Time `json:",omitempty"`
+
+	// source is whatever the store the state was loaded from needs to only
+	// save it over the state it was loaded from, like the ConfigMap it was
+	// read from. It isn't saved itself.
+	source interface{}
+}
+
+// migrate converts state saved by older versions into the per file state.
+// Every file in the listing that the old high water mark considers processed
+// is recorded as seen, so that upgrading doesn't resend them.
+func (d *configdata) migrate(entries []os.FileInfo) {
+	if d.Files != nil {
+		return
+	}
+	d.Files = make(map[string]fileState, len(entries))
+	if d.LastModTime == nil {
+		return
+	}
+	for _, e := range entries {
+		if e.ModTime().Before(*d.LastModTime) || e.Name() == d.LastFileProcessed {
+			d.Files[e.Name()] = fileState{Size: e.Size(), ModTime: e.ModTime()}
+		}
+	}
+	d.LastFileProcessed = ""
+	d.LastModTime = nil
+}
+
+// change returns the event type describing how the file changed since we
+// last processed it, or "" if it didn't change.
+func (d *configdata) change(name string, e os.FileInfo) string {
+	fs, ok := d.Files[name]
+	switch {
+	case !ok || fs.GoneSince != nil:
+		return event_type
+	case fs.Size != e.Size() || !fs.ModTime.Equal(e.ModTime()):
+		return event_type_modified
+	}
+	return ""
+}
+
+// unchanged returns true if the file we found to be modified still has the
+// checksum we recorded for it. The checksum is "" if we didn't compute it.
+func (d *configdata) unchanged(name, checksum string) bool {
+	fs, ok := d.Files[name]
+	return ok && fs.GoneSince == nil && fs.Hash != "" && fs.Hash == checksum
+}
+
+// markProcessed records the file as processed, with its checksum if we
+// computed it.
+func (d *configdata) markProcessed(name string, e os.FileInfo, checksum string) {
+	d.Files[name] = fileState{Size: e.Size(), ModTime: e.ModTime(), Hash: checksum}
+}
+
+// markActioned records the post delivery action taken on the file. The file
+// is gone under its name after any action, and we don't send a deleted
+// event for it since we removed it ourselves.
+func (d *configdata) markActioned(name, action string, now time.Time) {
+	fs := d.Files[name]
+	fs.Action = action
+	fs.PendingAction = false
+	fs.GoneSince = &now
+	d.Files[name] = fs
+}
+
+// markActionPending records that the post delivery action failed.
+func (d *configdata) markActionPending(name string) {
+	fs := d.Files[name]
+	fs.PendingAction = true
+	d.Files[name] = fs
+}
+
+// deleted returns the files we know about that are missing from the
+// listing and that we haven't sent a deleted event for yet.
+func (d *configdata) deleted(present map[string]bool) []os.FileInfo {
+	var deleted []os.FileInfo
+	for name, fs := range d.Files {
+		if !present[name] && fs.GoneSince == nil {
+			deleted = append(deleted, &stateFileInfo{name: name, state: fs})
+		}
+	}
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].Name() < deleted[j].Name()
+	})
+	return deleted
+}
+
+// markGone records the file as deleted.
+func (d *configdata) markGone(name string, now time.Time) {
+	fs := d.Files[name]
+	fs.GoneSince = &now
+	d.Files[name] = fs
+}
+
+// compact bounds the size of the state. Entries for files that are still
+// present are always kept, since dropping them would resend the file. Files
+// that have been deleted are kept for the retention period, and beyond that,
+// the ones gone the longest are dropped first until at most maxEntries
+// remain. Returns true if the state was modified.
+func (d *configdata) compact(now time.Time, retention time.Duration, maxEntries int) bool {
+	modified := false
+	var gone []string
+	for name, fs := range d.Files {
+		if fs.GoneSince == nil {
+			continue
+		}
+		if now.Sub(*fs.GoneSince) > retention {
+			delete(d.Files, name)
+			modified = true
+			continue
+		}
+		gone = append(gone, name)
+	}
+
+	if excess := len(d.Files) - maxEntries; maxEntries > 0 && excess > 0 {
+		sort.Slice(gone, func(i, j int) bool {
+			return d.Files[gone[i]].GoneSince.Before(*d.Files[gone[j]].GoneSince)
+		})
+		if excess > len(gone) {
+			excess = len(gone)
+		}
+		for _, name := range gone[:excess] {
+			delete(d.Files, name)
+			modified = true
+		}
+	}
+	return modified
+}
+
+// stateFileInfo describes a file as we last saw it, for files that are no
+// longer on the server.
+type stateFileInfo struct {
+	name  string
+	state fileState
+}
+
+func (fi *stateFileInfo) Name() string       { return fi.name }
+func (fi *stateFileInfo) Size() int64        { return fi.state.Size }
+func (fi *stateFileInfo) Mode() os.FileMode  { return 0 }
+func (fi *stateFileInfo) ModTime() time.Time { return fi.state.ModTime }
+func (fi *stateFileInfo) IsDir() bool        { return false }
+func (fi *stateFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	t0 = time.Date(2021, 1, 20, 10, 0, 0, 0, time.UTC)
+	t1 = t0.Add(time.Minute)
+	t2 = t0.Add(2 * time.Minute)
+)
+
+// file returns the listing entry of a file.
+func file(name string, size int64, modTime time.Time) os.FileInfo {
+	return &stateFileInfo{name: name, state: fileState{Size: size, ModTime: modTime}}
+}
+
+func names(entries []os.FileInfo) []string {
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestChange(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]fileState
+		entry os.FileInfo
+		want  string
+	}{{
+		name:  "new file",
+		files: map[string]fileState{},
+		entry: file("a.csv", 10, t0),
+		want:  event_type,
+	}, {
+		name:  "unchanged file",
+		files: map[string]fileState{"a.csv": {Size: 10, ModTime: t0}},
+		entry: file("a.csv", 10, t0),
+	}, {
+		name:  "same ModTime in another location",
+		files: map[string]fileState{"a.csv": {Size: 10, ModTime: t0.In(time.FixedZone("CET", 3600))}},
+		entry: file("a.csv", 10, t0),
+	}, {
+		name:  "older file with another name",
+		files: map[string]fileState{"b.csv": {Size: 10, ModTime: t1}},
+		entry: file("a.csv", 10, t0),
+		want:  event_type,
+	}, {
+		name:  "size changed",
+		files: map[string]fileState{"a.csv": {Size: 10, ModTime: t0}},
+		entry: file("a.csv", 20, t0),
+		want:  event_type_modified,
+	}, {
+		name:  "ModTime changed",
+		files: map[string]fileState{"a.csv": {Size: 10, ModTime: t0}},
+		entry: file("a.csv", 10, t1),
+		want:  event_type_modified,
+	}, {
+		name:  "uploaded again after it was deleted",
+		files: map[string]fileState{"a.csv": {Size: 10, ModTime: t0, GoneSince: &t1}},
+		entry: file("a.csv", 10, t0),
+		want:  event_type,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := &configdata{Files: test.files}
+			if got := d.change(test.entry.Name(), test.entry); got != test.want {
+				t.Errorf("change() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUnchanged(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]fileState
+		checksum string
+		want     bool
+	}{{
+		name:     "same checksum",
+		files:    map[string]fileState{"a.csv": {Hash: "sha256:aa"}},
+		checksum: "sha256:aa",
+		want:     true,
+	}, {
+		name:     "other checksum",
+		files:    map[string]fileState{"a.csv": {Hash: "sha256:aa"}},
+		checksum: "sha256:bb",
+	}, {
+		name:     "no recorded checksum",
+		files:    map[string]fileState{"a.csv": {}},
+		checksum: "sha256:aa",
+	}, {
+		name:  "no checksums at all",
+		files: map[string]fileState{"a.csv": {}},
+	}, {
+		name:     "deleted since",
+		files:    map[string]fileState{"a.csv": {Hash: "sha256:aa", GoneSince: &t0}},
+		checksum: "sha256:aa",
+	}, {
+		name:     "unknown file",
+		files:    map[string]fileState{},
+		checksum: "sha256:aa",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := &configdata{Files: test.files}
+			if got := d.unchanged("a.csv", test.checksum); got != test.want {
+				t.Errorf("unchanged() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDeleted(t *testing.T) {
+	d := &configdata{Files: map[string]fileState{
+		"c.csv":      {Size: 3, ModTime: t0},
+		"a.csv":      {Size: 1, ModTime: t0},
+		"b.csv":      {Size: 2, ModTime: t0},
+		"gone.csv":   {Size: 4, ModTime: t0, GoneSince: &t1},
+		"sub/d.csv":  {Size: 5, ModTime: t0},
+		"kept.csv":   {Size: 6, ModTime: t0},
+		"sub/e.csv":  {Size: 7, ModTime: t0},
+		"moved.csv":  {Size: 8, ModTime: t0, Action: "moved to /archive/moved.csv", GoneSince: &t1},
+		"remain.csv": {Size: 9, ModTime: t0},
+	}}
+	present := map[string]bool{"kept.csv": true, "sub/e.csv": true, "remain.csv": true}
+
+	deleted := d.deleted(present)
+	want := []string{"a.csv", "b.csv", "c.csv", "sub/d.csv"}
+	if diff := cmp.Diff(want, names(deleted)); diff != "" {
+		t.Errorf("deleted() (-want, +got): %s", diff)
+	}
+	// Deleted events describe the file as it was last seen.
+	if got := deleted[1].Size(); got != 2 {
+		t.Errorf("Size() = %d, want 2", got)
+	}
+	if got := deleted[1].ModTime(); !got.Equal(t0) {
+		t.Errorf("ModTime() = %v, want %v", got, t0)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	now := t0.Add(24 * time.Hour)
+	gone := func(ago time.Duration) *time.Time {
+		t := now.Add(-ago)
+		return &t
+	}
+	tests := []struct {
+		name       string
+		files      map[string]fileState
+		retention  time.Duration
+		maxEntries int
+		want       []string
+		modified   bool
+	}{{
+		name: "nothing to compact",
+		files: map[string]fileState{
+			"a.csv": {},
+			"b.csv": {GoneSince: gone(time.Minute)},
+		},
+		retention:  time.Hour,
+		maxEntries: 10,
+		want:       []string{"a.csv", "b.csv"},
+	}, {
+		name: "past retention",
+		files: map[string]fileState{
+			"a.csv": {},
+			"b.csv": {GoneSince: gone(time.Minute)},
+			"c.csv": {GoneSince: gone(2 * time.Hour)},
+		},
+		retention: time.Hour,
+		want:      []string{"a.csv", "b.csv"},
+		modified:  true,
+	}, {
+		name: "too many entries drops the ones gone the longest",
+		files: map[string]fileState{
+			"a.csv": {},
+			"b.csv": {GoneSince: gone(time.Minute)},
+			"c.csv": {GoneSince: gone(3 * time.Minute)},
+			"d.csv": {GoneSince: gone(2 * time.Minute)},
+		},
+		retention:  time.Hour,
+		maxEntries: 2,
+		want:       []string{"a.csv", "b.csv"},
+		modified:   true,
+	}, {
+		name: "files on the server are always kept",
+		files: map[string]fileState{
+			"a.csv": {},
+			"b.csv": {},
+			"c.csv": {GoneSince: gone(time.Minute)},
+		},
+		retention:  time.Hour,
+		maxEntries: 1,
+		want:       []string{"a.csv", "b.csv"},
+		modified:   true,
+	}, {
+		name: "no limit",
+		files: map[string]fileState{
+			"a.csv": {},
+			"b.csv": {GoneSince: gone(time.Minute)},
+			"c.csv": {GoneSince: gone(2 * time.Minute)},
+		},
+		retention: time.Hour,
+		want:      []string{"a.csv", "b.csv", "c.csv"},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := &configdata{Files: test.files}
+			if got := d.compact(now, test.retention, test.maxEntries); got != test.modified {
+				t.Errorf("compact() = %v, want %v", got, test.modified)
+			}
+			var got []string
+			for name := range d.Files {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Files (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	entries := []os.FileInfo{
+		file("older.csv", 1, t0),
+		file("last.csv", 2, t1),
+		file("same-time.csv", 3, t1),
+		file("newer.csv", 4, t2),
+	}
+	tests := []struct {
+		name string
+		data configdata
+		want map[string]fileState
+	}{{
+		name: "high water mark",
+		data: configdata{LastFileProcessed: "last.csv", LastModTime: &t1},
+		want: map[string]fileState{
+			"older.csv": {Size: 1, ModTime: t0},
+			"last.csv":  {Size: 2, ModTime: t1},
+		},
+	}, {
+		name: "no state yet",
+		want: map[string]fileState{},
+	}, {
+		name: "already migrated",
+		data: configdata{Files: map[string]fileState{"newer.csv": {Size: 4, ModTime: t2}}},
+		want: map[string]fileState{"newer.csv": {Size: 4, ModTime: t2}},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := test.data
+			d.migrate(entries)
+			if diff := cmp.Diff(test.want, d.Files); diff != "" {
+				t.Errorf("Files (-want, +got): %s", diff)
+			}
+			if d.LastFileProcessed != "" || d.LastModTime != nil {
+				t.Errorf("high water mark not cleared: %q, %v", d.LastFileProcessed, d.LastModTime)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// The backends we can keep state in.
+const (
+	stateBackendConfigMap = "configmap"
+	stateBackendFile      = "file"
+	stateBackendRedis     = "redis"
+)
+
+// StateStore persists the state of the watcher across probes and restarts.
+type StateStore interface {
+	// Load returns the saved state, or empty state if none was saved yet.
+	Load(ctx context.Context) (*configdata, error)
+	// Save replaces the saved state.
+	Save(ctx context.Context, data *configdata) error
+}
+
+// sharedStore is a StateStore several watchers can save to, like the
+// shards of a directory, each updating its part of the state.
+type sharedStore interface {
+	StateStore
+	// update applies fn to the saved state, or to empty state if none was
+	// saved yet, and saves the result, without losing concurrent updates.
+	// fn may be called more than once.
+	update(ctx context.Context, fn func(*configdata)) error
+}
+
+// keyedStore is a StateStore that can keep other states next to its own,
+// under keys of their own, so that the watchers of a supervisor can share
+// the backend.
+type keyedStore interface {
+	sharedStore
+	// withKey returns the store of the state kept under key.
+	withKey(key string) sharedStore
+}
+
+// stateStoreConfig selects and configures the StateStore backend.
+type stateStoreConfig struct {
+	backend string
+	// Name of the ConfigMap, also used to name the state in the other
+	// backends, so several sources can share a database.
+	name string
+	// Path of the database file for the file backend.
+	file string
+	// Address, password and database number for the redis backend.
+	redisAddress  string
+	redisPassword string
+	redisDB       int
+}
+
+// newStateStore returns the StateStore for the configured backend.
+func newStateStore(ctx context.Context, cfg stateStoreConfig) (keyedStore, error) {
+	switch cfg.backend {
+	case stateBackendConfigMap:
+		return newConfigMapStore(ctx, cfg.name)
+	case stateBackendFile:
+		return newBoltStore(cfg.file, cfg.name)
+	case stateBackendRedis:
+		return newRedisStore(cfg.redisAddress, cfg.redisPassword, cfg.redisDB, cfg.name)
+	default:
+		return nil, fmt.Errorf("unknown state backend %q", cfg.backend)
+	}
+}
+
+// unmarshalState decodes saved state, where no state decodes as empty state.
+func unmarshalState(b []byte) (*configdata, error) {
+	data := &configdata{}
+	if len(b) == 0 {
+		return data, nil
+	}
+	if err := json.Unmarshal(b, data); err != nil {
+		return nil, fmt.Errorf("decoding state: %w", err)
+	}
+	return data, nil
+}
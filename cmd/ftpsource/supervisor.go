@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/reconciler"
+)
+
+// supervisor runs a watcher for every directory of every target. The
+// watchers of a target share its connection to the server, and all of them
+// share the publishing settings and the state backend, keeping their state
+// under keys of their own.
+//
+// When sharded, the subdirectories of recursively watched directories are
+// spread over the shards, and every shard of such a directory has a watcher
+// of its own, listing only the subdirectories of the shard. Directories that
+// aren't watched recursively are assigned to a shard as a whole. The
+// watchers of a shard are run while we hold it.
+type supervisor struct {
+	targets []watchTarget
+	env     *EnvConfig
+	store   keyedStore
+	// Copied for every directory, with the attributes, server and
+	// directory filled in.
+	publisher  publisher
+	postAction *postAction
+
+	// Settings of the stability checks, which track the files of each
+	// directory on their own.
+	stableProbes   int
+	quietPeriod    time.Duration
+	markerSuffixes []string
+
+	retention  time.Duration
+	maxEntries int
+	keepalive  time.Duration
+	maxBackoff time.Duration
+
+	// The shards the work is split into, nil if it isn't.
+	shards []reconciler.Bucket
+
+	// The watchers of every shard, under "" if the work isn't sharded.
+	runs map[string][]func(stop <-chan struct{})
+
+	logger *zap.SugaredLogger
+
+	mu      sync.Mutex
+	running map[string]*runningShard
+	// Set once we're shutting down, after which the watchers aren't run
+	// anymore.
+	done bool
+}
+
+// stopTimeout bounds how long we wait for the watchers of a shard to stop.
+// They give up on the event they're sending right away, but saving their
+// state may still hang on an unresponsive backend, and we must not keep the
+// other shards, or the handover, waiting on them.
+const stopTimeout = 20 * time.Second
+
+// runningShard are the running watchers of a shard.
+type runningShard struct {
+	// Closed to stop the watchers.
+	stop     chan struct{}
+	watchers sync.WaitGroup
+}
+
+var _ reconciler.LeaderAware = (*supervisor)(nil)
+
+// setup sets up the watchers of every target, which are run once the
+// supervisor is promoted. Nothing is run unless all of them could be set up,
+// so that a mistake in the configuration of one target doesn't go unnoticed
+// while the others are running.
+func (s *supervisor) setup(ctx context.Context) error {
+	s.logger = logging.FromContext(ctx)
+	s.runs = make(map[string][]func(stop <-chan struct{}))
+	s.running = make(map[string]*runningShard)
+	for _, t := range s.targets {
+		if err := s.add(ctx, t); err != nil {
+			if t.Name == "" {
+				return err
+			}
+			return fmt.Errorf("target %q: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// shardOf returns the name the watchers of the bucket are kept under. The
+// bucket is the one of the leader when the work isn't sharded.
+func (s *supervisor) shardOf(b reconciler.Bucket) string {
+	if len(s.shards) == 0 {
+		return ""
+	}
+	return b.Name()
+}
+
+// Promote implements reconciler.LeaderAware, running the watchers of the
+// shard once we hold it, or all of them once we're the leader.
+func (s *supervisor) Promote(b reconciler.Bucket, _ func(reconciler.Bucket, types.NamespacedName)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shard := s.shardOf(b)
+	if s.done || s.running[shard] != nil {
+		return nil
+	}
+	r := &runningShard{stop: make(chan struct{})}
+	for _, run := range s.runs[shard] {
+		run := run
+		r.watchers.Add(1)
+		go func() {
+			defer r.watchers.Done()
+			run(r.stop)
+		}()
+	}
+	s.running[shard] = r
+	return nil
+}
+
+// Demote implements reconciler.LeaderAware, stopping the watchers of the
+// shard once we no longer hold it, or all of them once we're no longer the
+// leader. The watchers save their state before they stop, for whoever takes
+// over to pick up.
+func (s *supervisor) Demote(b reconciler.Bucket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopLocked(s.shardOf(b))
+}
+
+// shutdown stops the watchers for good, returning once they've saved their
+// state, so we can hand over to another replica, or once stopTimeout passed.
+func (s *supervisor) shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	for shard := range s.running {
+		s.stopLocked(shard)
+	}
+}
+
+func (s *supervisor) stopLocked(shard string) {
+	r := s.running[shard]
+	if r == nil {
+		return
+	}
+	close(r.stop)
+	stopped := make(chan struct{})
+	go func() {
+		r.watchers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(stopTimeout):
+		s.logger.Warn("Timed out waiting for the watchers to stop, their state may not be saved:", zap.String("shard", shard))
+	}
+	delete(s.running, shard)
+}
+
+// storeFor returns the store of the state of a directory of the target, or
+// of the target itself for dir "". The target configured by the flags keeps
+// its state where it always did.
+func (s *supervisor) storeFor(t watchTarget, dir string) sharedStore {
+	if t.Name == "" {
+		return s.store
+	}
+	return s.store.withKey(stateKey(t.Name, dir))
+}
+
+// hostKeyStoreFor returns the store of the host keys the target trusts. The
+// target configured by the flags keeps its directory's state where it always
+// did, so its host keys are kept next to it, where watchers saving their
+// state don't overwrite them.
+func (s *supervisor) hostKeyStoreFor(t watchTarget) sharedStore {
+	if t.Name == "" {
+		return s.store.withKey(hostKeysKey)
+	}
+	return s.storeFor(t, "")
+}
+
+// shardFor returns the shard the unit of work belongs to, "" if the work
+// isn't sharded.
+func (s *supervisor) shardFor(unit types.NamespacedName) string {
+	for _, b := range s.shards {
+		if b.Has(unit) {
+			return b.Name()
+		}
+	}
+	return ""
+}
+
+// add sets up the watchers of the directories of the target.
+func (s *supervisor) add(ctx context.Context, t watchTarget) error {
+	logger := logging.FromContext(ctx)
+	if t.Name != "" {
+		logger = logger.With(zap.String("target", t.Name))
+	}
+
+	user, creds, err := t.credentials(s.env)
+	if err != nil {
+		return err
+	}
+
+	var tlsConfig *tls.Config
+	var sshAuth []ssh.AuthMethod
+	var hostKeys *hostKeyVerifier
+	switch t.Protocol {
+	case protocolFTP:
+	case protocolFTPSExplicit, protocolFTPSImplicit:
+		tlsConfig, err = newTLSConfig(t.Server, t.TLSDir, *t.TLSInsecureSkipVerify)
+		if err != nil {
+			return fmt.Errorf("invalid TLS configuration: %w", err)
+		}
+	case protocolSFTP:
+		sshAuth, err = sshAuthMethods(creds, t.AuthMethods)
+		if err != nil {
+			return fmt.Errorf("invalid SFTP credentials: %w", err)
+		}
+		hostKeys, err = newHostKeyVerifier(t.KnownHosts, t.HostKeyFingerprints, *t.TrustOnFirstUse, *t.InsecureIgnoreHostKey, s.hostKeyStoreFor(t))
+		if err != nil {
+			return fmt.Errorf("invalid host key verification: %w", err)
+		}
+		if t.Name == "" {
+			hostKeys.legacy = s.store
+		}
+	default:
+		return fmt.Errorf("unknown protocol %q", t.Protocol)
+	}
+
+	// We don't send events for the sidecar files we verify against.
+	exclude := t.Exclude
+	if s.publisher.checksums != nil && s.publisher.checksums.sidecar {
+		exclude = append(append([]string{}, exclude...), "*"+s.publisher.checksums.sidecarSuffix())
+	}
+	filter, err := newFileFilter(t.Include, exclude, t.IncludeRegex, t.ExcludeRegex)
+	if err != nil {
+		return fmt.Errorf("invalid file filter: %w", err)
+	}
+
+	logger.Info("Using protocol", zap.String("protocol", t.Protocol))
+	logger.Info("Probing frequency  ", zap.Duration("interval", t.Interval.Duration))
+
+	var conn *connection
+	for _, d := range t.Directories {
+		p, err := s.publisherFor(t, d)
+		if err != nil {
+			return err
+		}
+		// The files we'd move into the directory would be sent again,
+		// and moved again, forever.
+		if t.Recursive && s.postAction.archivesInto(d) {
+			return fmt.Errorf("the archive directory to move files to is in the recursively watched directory %s", d)
+		}
+
+		watch := func(shard string, store StateStore, owns func(sub string) bool) {
+			w := &watcher{
+				server:     t.Server,
+				dir:        d,
+				user:       user,
+				password:   creds.password,
+				protocol:   t.Protocol,
+				frequency:  t.Interval.Duration,
+				handler:    p.postMessage,
+				keepalive:  s.keepalive,
+				maxBackoff: s.maxBackoff,
+				tlsConfig:  tlsConfig,
+				sshAuth:    sshAuth,
+				hostKeys:   hostKeys,
+				retention:  s.retention,
+				maxEntries: s.maxEntries,
+				recursive:  t.Recursive,
+				maxDepth:   t.MaxDepth,
+				shard:      owns,
+				filter:     filter,
+				stability:  newStabilityCheck(s.stableProbes, s.quietPeriod, s.markerSuffixes),
+				postAction: s.postAction,
+				store:      store,
+			}
+			wlogger := logger.With(zap.String("dir", d))
+			if shard != "" {
+				wlogger = wlogger.With(zap.String("shard", shard))
+			}
+			wctx := withMetricTags(logging.WithLogger(ctx, wlogger), t.Server, d)
+			if conn == nil {
+				conn = newConnection(w.dialer(wctx), s.keepalive, s.maxBackoff)
+			}
+			w.conn = conn
+			s.runs[shard] = append(s.runs[shard], func(stop <-chan struct{}) { w.run(wctx, stop) })
+		}
+
+		logger.Info("watching ", zap.String("server", t.Server), zap.String("dir", d), zap.Bool("recursive", t.Recursive), zap.Int("maxDepth", t.MaxDepth))
+		if len(s.shards) == 0 || !t.Recursive {
+			watch(s.shardFor(shardUnit(t.Name, d, "")), s.storeFor(t, d), nil)
+			continue
+		}
+		for _, b := range s.shards {
+			b := b
+			owns := func(sub string) bool { return b.Has(shardUnit(t.Name, d, sub)) }
+			store := &shardStore{
+				whole: s.storeFor(t, d),
+				owns:  func(name string) bool { return owns(shardOf(name)) },
+			}
+			watch(b.Name(), store, owns)
+		}
+	}
+	return nil
+}
+
+// publisherFor returns the publisher of the events of the directory of the
+// target, with the event attributes of the target.
+func (s *supervisor) publisherFor(t watchTarget, dir string) (publisher, error) {
+	attributes, err := newEventAttributes(t.TypePrefix, t.SourceTemplate, t.extensions(), sourceData{
+		Server:    t.Server,
+		Directory: dir,
+		Protocol:  t.Protocol,
+	})
+	if err != nil {
+		return publisher{}, fmt.Errorf("invalid event attributes: %w", err)
+	}
+	p := s.publisher
+	p.attributes = attributes
+	p.server = t.Server
+	p.dir = dir
+	p.protocol = t.Protocol
+	return p, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// Files newTLSConfig looks for in the TLS directory, named like the keys of
+// Kubernetes TLS Secrets.
+const (
+	tlsCAFile   = "ca.crt"
+	tlsCertFile = "tls.crt"
+	tlsKeyFile  = "tls.key"
+)
+
+// newTLSConfig returns the TLS configuration to connect to the FTPS server
+// with. The optional tlsDir holds the CA bundle to verify the server with
+// (instead of the system roots), and the client certificate and key.
+func newTLSConfig(server, tlsDir string, insecureSkipVerify bool) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		host = server
+	}
+	config := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: insecureSkipVerify,
+		// Many FTPS servers require the data connections to resume the
+		// TLS session of the control connection.
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+	if tlsDir == "" {
+		return config, nil
+	}
+
+	ca, err := readOptional(filepath.Join(tlsDir, tlsCAFile))
+	if err != nil {
+		return nil, err
+	}
+	if ca != nil {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", tlsCAFile)
+		}
+	}
+
+	cert, err := readOptional(filepath.Join(tlsDir, tlsCertFile))
+	if err != nil {
+		return nil, err
+	}
+	key, err := readOptional(filepath.Join(tlsDir, tlsKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case cert != nil && key != nil:
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	case cert != nil || key != nil:
+		return nil, errors.New("client certificate and key must be given together")
+	}
+	return config, nil
+}
+
+// readOptional reads a file, returning nil if it doesn't exist.
+func readOptional(name string) ([]byte, error) {
+	b, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return b, err
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newClientCertificate returns a PEM encoded self-signed client certificate
+// and its key.
+func newClientCertificate(t *testing.T) (cert, key []byte) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() = %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "partner"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("CreateCertificate() = %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() = %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeTLSDir writes the files to a new TLS directory.
+func writeTLSDir(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, b := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	// The certificate of the server is valid for example.com.
+	server := httptest.NewTLSServer(nil)
+	defer server.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	cert, key := newClientCertificate(t)
+
+	tests := []struct {
+		name       string
+		server     string
+		serverName string
+		files      map[string][]byte
+		skipVerify bool
+		wantErr    bool
+	}{{
+		name:       "verified with the CA bundle",
+		server:     "example.com:990",
+		serverName: "example.com",
+		files:      map[string][]byte{tlsCAFile: ca},
+	}, {
+		name:       "with a client certificate",
+		server:     "example.com:990",
+		serverName: "example.com",
+		files:      map[string][]byte{tlsCAFile: ca, tlsCertFile: cert, tlsKeyFile: key},
+	}, {
+		name:       "server without a port",
+		server:     "example.com",
+		serverName: "example.com",
+		files:      map[string][]byte{tlsCAFile: ca},
+	}, {
+		name:       "not verified by the system roots",
+		server:     "example.com:990",
+		serverName: "example.com",
+		wantErr:    true,
+	}, {
+		name:       "certificate for another server",
+		server:     "ftp.example.org:990",
+		serverName: "ftp.example.org",
+		files:      map[string][]byte{tlsCAFile: ca},
+		wantErr:    true,
+	}, {
+		name:       "verification skipped",
+		server:     "ftp.example.org:990",
+		serverName: "ftp.example.org",
+		skipVerify: true,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var dir string
+			if test.files != nil {
+				dir = writeTLSDir(t, test.files)
+			}
+			config, err := newTLSConfig(test.server, dir, test.skipVerify)
+			if err != nil {
+				t.Fatalf("newTLSConfig() = %v", err)
+			}
+			if config.ServerName != test.serverName {
+				t.Errorf("ServerName = %q, want %q", config.ServerName, test.serverName)
+			}
+			if config.ClientSessionCache == nil {
+				t.Error("ClientSessionCache = nil, want a cache to resume the session with")
+			}
+			if _, ok := test.files[tlsCertFile]; ok {
+				if len(config.Certificates) != 1 {
+					t.Fatalf("Certificates = %d, want 1", len(config.Certificates))
+				}
+				block, _ := pem.Decode(cert)
+				if !bytes.Equal(config.Certificates[0].Certificate[0], block.Bytes) {
+					t.Error("Certificates[0] isn't the client certificate")
+				}
+			}
+
+			conn, err := tls.Dial("tcp", server.Listener.Addr().String(), config)
+			if err == nil {
+				conn.Close()
+			}
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("Dialing the server = %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	cert, key := newClientCertificate(t)
+	otherCert, _ := newClientCertificate(t)
+
+	tests := []struct {
+		name  string
+		files map[string][]byte
+	}{{
+		name:  "no certificates in the CA bundle",
+		files: map[string][]byte{tlsCAFile: []byte("not a certificate")},
+	}, {
+		name:  "certificate without a key",
+		files: map[string][]byte{tlsCertFile: cert},
+	}, {
+		name:  "key without a certificate",
+		files: map[string][]byte{tlsKeyFile: key},
+	}, {
+		name:  "key of another certificate",
+		files: map[string][]byte{tlsCertFile: otherCert, tlsKeyFile: key},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newTLSConfig("ftp.example.com:990", writeTLSDir(t, test.files), false); err == nil {
+				t.Error("newTLSConfig() = nil, want error")
+			}
+		})
+	}
+}
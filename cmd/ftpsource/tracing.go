@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	ceextensions "github.com/cloudevents/sdk-go/v2/extensions"
+	"go.opencensus.io/trace"
+)
+
+// Names of the spans of the processing of a file. Every file found in a
+// listing gets a span of its own, a child of the span of the listing, whose
+// trace the sink joins through the traceparent extension of the event.
+const (
+	spanListing  = "ftpsource.listing"
+	spanFile     = "ftpsource.file"
+	spanDownload = "ftpsource.download"
+	spanCopy     = "ftpsource.copy"
+	spanChecksum = "ftpsource.checksum"
+	spanSend     = "ftpsource.send"
+)
+
+// startFileSpan starts the span of a file found in the listing, for an event
+// of the given default type. The listing has ended by then, so its span is
+// given as the parent rather than taken from ctx.
+func startFileSpan(ctx context.Context, listing trace.SpanContext, server, dir, name, eventType string) (context.Context, *trace.Span) {
+	ctx, span := trace.StartSpanWithRemoteParent(ctx, spanFile, listing)
+	span.AddAttributes(
+		trace.StringAttribute("ftp.server", server),
+		trace.StringAttribute("ftp.directory", dir),
+		trace.StringAttribute("ftp.file", name),
+		trace.StringAttribute("ftp.event_type", eventType),
+	)
+	return ctx, span
+}
+
+// endSpan ends the span, with the outcome of what it spans.
+func endSpan(span *trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+	}
+	span.End()
+}
+
+// setTraceParent sets the traceparent extension of the event to the span of
+// the file in ctx, if there is one, so the services the event is sent to
+// join its trace.
+func setTraceParent(ctx context.Context, event *cloudevents.Event) {
+	span := trace.FromContext(ctx)
+	if span == nil {
+		return
+	}
+	ceextensions.FromSpanContext(span.SpanContext()).AddTracingAttributes(event)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/go-cmp/cmp"
+	"go.opencensus.io/trace"
+)
+
+// spanRecorder is an exporter keeping the spans that ended, by name.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans map[string]*trace.SpanData
+}
+
+func (r *spanRecorder) ExportSpan(s *trace.SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans[s.Name] = s
+}
+
+func recordSpans(t *testing.T) *spanRecorder {
+	r := &spanRecorder{spans: map[string]*trace.SpanData{}}
+	trace.RegisterExporter(r)
+	t.Cleanup(func() { trace.UnregisterExporter(r) })
+	return r
+}
+
+func TestFileSpanIsAChildOfTheListing(t *testing.T) {
+	spans := recordSpans(t)
+	ctx := context.Background()
+
+	// The listing ends before the files are processed.
+	_, listing := trace.StartSpan(ctx, spanListing, trace.WithSampler(trace.AlwaysSample()))
+	listing.End()
+
+	fctx, file := startFileSpan(ctx, listing.SpanContext(), "ftp.example.com:22", "incoming", "a.csv", event_type)
+	_, send := trace.StartSpan(fctx, spanSend)
+	event := cloudevents.NewEvent()
+	setTraceParent(fctx, &event)
+	send.End()
+	file.End()
+
+	l, f, s := spans.spans[spanListing], spans.spans[spanFile], spans.spans[spanSend]
+	if l == nil || f == nil || s == nil {
+		t.Fatalf("Exported spans = %v, want %s, %s and %s", spans.spans, spanListing, spanFile, spanSend)
+	}
+	type ids struct {
+		TraceID      trace.TraceID
+		ParentSpanID trace.SpanID
+	}
+	if diff := cmp.Diff(ids{l.TraceID, l.SpanID}, ids{f.TraceID, f.ParentSpanID}); diff != "" {
+		t.Error("File span (-want, +got):", diff)
+	}
+	if diff := cmp.Diff(ids{f.TraceID, f.SpanID}, ids{s.TraceID, s.ParentSpanID}); diff != "" {
+		t.Error("Send span (-want, +got):", diff)
+	}
+	if !f.HasRemoteParent {
+		t.Error("HasRemoteParent = false, want true")
+	}
+	if len(f.Links) != 0 {
+		t.Errorf("Links = %v, want none", f.Links)
+	}
+	if diff := cmp.Diff(map[string]interface{}{
+		"ftp.server":     "ftp.example.com:22",
+		"ftp.directory":  "incoming",
+		"ftp.file":       "a.csv",
+		"ftp.event_type": event_type,
+	}, f.Attributes); diff != "" {
+		t.Error("Attributes (-want, +got):", diff)
+	}
+
+	want := fmt.Sprintf("00-%s-%s-01", f.TraceID, f.SpanID)
+	if got := event.Extensions()["traceparent"]; got != want {
+		t.Errorf("traceparent = %v, want %s", got, want)
+	}
+}
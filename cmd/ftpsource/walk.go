@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+	"knative.dev/pkg/logging"
+)
+
+// relFileInfo is a file found while walking the watched directory. Name
+// returns the path of the file relative to the watched directory.
+type relFileInfo struct {
+	os.FileInfo
+	relPath string
+}
+
+func (fi *relFileInfo) Name() string { return fi.relPath }
+
+// listFiles lists the regular files in the watched directory using the
+// given readDir. In recursive mode it descends into subdirectories, at
+// most maxDepth levels deep (no limit if maxDepth is 0). The names of the
+// returned entries are relative to the watched directory. When sharded, only
+// the files of the shard are listed.
+//
+// Subdirectories that can't be listed, like ones deleted while we walk the
+// tree, are skipped and returned, relative to the watched directory, so that
+// their files aren't taken for deleted. Only failing to list the watched
+// directory itself fails the listing.
+func (s *watcher) listFiles(ctx context.Context, readDir func(string) ([]os.FileInfo, error)) ([]os.FileInfo, []string, error) {
+	return s.list(ctx, readDir, s.owns)
+}
+
+// list lists the files of the subdirectories owns returns true for, and of
+// the watched directory itself if it returns true for "".
+func (s *watcher) list(ctx context.Context, readDir func(string) ([]os.FileInfo, error), owns func(sub string) bool) ([]os.FileInfo, []string, error) {
+	logger := logging.FromContext(ctx)
+	var files []os.FileInfo
+	var unlisted []string
+	var walk func(rel string, depth int) error
+	walk = func(rel string, depth int) error {
+		entries, err := readDir(path.Join(s.dir, rel))
+		if err != nil {
+			if rel == "" {
+				return err
+			}
+			logger.Warn("Failed to list subdirectory, skipping it:", zap.String("subdirectory", rel), zap.Error(err))
+			unlisted = append(unlisted, rel)
+			return nil
+		}
+		for _, e := range entries {
+			name := path.Join(rel, e.Name())
+			switch {
+			case depth == 0 && e.Mode().IsRegular() && !owns(""):
+				// Left to the other shards.
+			case depth == 0 && e.IsDir() && !owns(e.Name()):
+			case e.Mode().IsRegular():
+				files = append(files, &relFileInfo{FileInfo: e, relPath: name})
+			case e.IsDir() && s.recursive && (s.maxDepth == 0 || depth < s.maxDepth):
+				// Some FTP servers include these in listings.
+				if e.Name() == "." || e.Name() == ".." {
+					continue
+				}
+				if err := walk(name, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk("", 0); err != nil {
+		return nil, nil, err
+	}
+	return files, unlisted, nil
+}
+
+// inDirs returns true if the file with the given relative path is in one of
+// the directories, or their subdirectories.
+func inDirs(name string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// shardOf returns the subdirectory of the watched directory the file with
+// the given relative path is in, the unit of work directories are sharded
+// by, or "" for files directly in the watched directory.
+func shardOf(name string) string {
+	if i := strings.Index(name, "/"); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// dirInfo is the listing entry of a directory.
+type dirInfo string
+
+func (fi dirInfo) Name() string       { return string(fi) }
+func (fi dirInfo) Size() int64        { return 0 }
+func (fi dirInfo) Mode() os.FileMode  { return os.ModeDir | 0755 }
+func (fi dirInfo) ModTime() time.Time { return t0 }
+func (fi dirInfo) IsDir() bool        { return true }
+func (fi dirInfo) Sys() interface{}   { return nil }
+
+var errNoSuchDir = errors.New("no such directory")
+
+// readDir returns a readDir listing the directories of the tree, rooted at
+// /in, and failing for the ones that aren't in it.
+func readDir(tree map[string][]os.FileInfo) func(string) ([]os.FileInfo, error) {
+	return func(dir string) ([]os.FileInfo, error) {
+		entries, ok := tree[dir]
+		if !ok {
+			return nil, errNoSuchDir
+		}
+		return entries, nil
+	}
+}
+
+// treeRemote is a fakeRemote listing the directories of the tree.
+type treeRemote struct {
+	fakeRemote
+	tree map[string][]os.FileInfo
+}
+
+func (r *treeRemote) ReadDir(dir string) ([]os.FileInfo, error) { return readDir(r.tree)(dir) }
+
+func TestListFiles(t *testing.T) {
+	tree := map[string][]os.FileInfo{
+		"/in": {
+			file("a.csv", 1, t0),
+			dirInfo("."),
+			dirInfo(".."),
+			dirInfo("x"),
+			dirInfo("y"),
+		},
+		"/in/x": {
+			file("b.csv", 2, t0),
+			dirInfo("deep"),
+		},
+		"/in/x/deep": {
+			file("c.csv", 3, t0),
+		},
+		"/in/y": {
+			file("d.csv", 4, t0),
+		},
+	}
+	tests := []struct {
+		name      string
+		recursive bool
+		maxDepth  int
+		shard     func(string) bool
+		remove    string
+		want      []string
+		unlisted  []string
+	}{{
+		name: "not recursive",
+		want: []string{"a.csv"},
+	}, {
+		name:      "no depth limit",
+		recursive: true,
+		want:      []string{"a.csv", "x/b.csv", "x/deep/c.csv", "y/d.csv"},
+	}, {
+		name:      "one level deep",
+		recursive: true,
+		maxDepth:  1,
+		want:      []string{"a.csv", "x/b.csv", "y/d.csv"},
+	}, {
+		name:      "two levels deep",
+		recursive: true,
+		maxDepth:  2,
+		want:      []string{"a.csv", "x/b.csv", "x/deep/c.csv", "y/d.csv"},
+	}, {
+		name:      "shard of a subdirectory",
+		recursive: true,
+		shard:     func(sub string) bool { return sub == "x" },
+		want:      []string{"x/b.csv", "x/deep/c.csv"},
+	}, {
+		name:      "shard of the files in the directory",
+		recursive: true,
+		shard:     func(sub string) bool { return sub == "" },
+		want:      []string{"a.csv"},
+	}, {
+		name:      "subdirectory deleted while listing",
+		recursive: true,
+		remove:    "/in/x",
+		want:      []string{"a.csv", "y/d.csv"},
+		unlisted:  []string{"x"},
+	}, {
+		name:      "nested subdirectory deleted while listing",
+		recursive: true,
+		remove:    "/in/x/deep",
+		want:      []string{"a.csv", "x/b.csv", "y/d.csv"},
+		unlisted:  []string{"x/deep"},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listed := make(map[string][]os.FileInfo, len(tree))
+			for dir, entries := range tree {
+				if dir != test.remove {
+					listed[dir] = entries
+				}
+			}
+			w := &watcher{dir: "/in", recursive: test.recursive, maxDepth: test.maxDepth, shard: test.shard}
+			entries, unlisted, err := w.listFiles(context.Background(), readDir(listed))
+			if err != nil {
+				t.Fatalf("listFiles() = %v", err)
+			}
+			if diff := cmp.Diff(test.want, names(entries)); diff != "" {
+				t.Errorf("listFiles() (-want, +got): %s", diff)
+			}
+			if diff := cmp.Diff(test.unlisted, unlisted); diff != "" {
+				t.Errorf("unlisted (-want, +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestListFilesRootFails(t *testing.T) {
+	w := &watcher{dir: "/in", recursive: true}
+	if _, _, err := w.listFiles(context.Background(), readDir(nil)); !errors.Is(err, errNoSuchDir) {
+		t.Errorf("listFiles() = %v, want %v", err, errNoSuchDir)
+	}
+}
+
+func TestInDirs(t *testing.T) {
+	dirs := []string{"x", "y/deep"}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"a.csv", false},
+		{"x/b.csv", true},
+		{"x/deep/c.csv", true},
+		{"xx/b.csv", false},
+		{"y/d.csv", false},
+		{"y/deep/e.csv", true},
+	}
+	for _, test := range tests {
+		if got := inDirs(test.name, dirs); got != test.want {
+			t.Errorf("inDirs(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestShardOf(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"a.csv", ""},
+		{"x/b.csv", "x"},
+		{"x/deep/c.csv", "x"},
+		{"x", ""},
+	}
+	for _, test := range tests {
+		if got := shardOf(test.name); got != test.want {
+			t.Errorf("shardOf(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
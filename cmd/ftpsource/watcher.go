@@ -2,169 +2,457 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"golang.org/x/crypto/ssh"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"net"
 	"os"
+	"path"
 	"time"
 
 	"github.com/pkg/sftp"
 	"github.com/secsy/goftp"
+	"go.opencensus.io/trace"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
 	"knative.dev/pkg/logging"
+	"knative.dev/pkg/metrics"
+)
+
+// The protocols we can watch servers with.
+const (
+	protocolFTP          = "ftp"
+	protocolFTPSExplicit = "ftps-explicit"
+	protocolFTPSImplicit = "ftps-implicit"
+	protocolSFTP         = "sftp"
 )
 
+// dialTimeout bounds connecting to the server and the SSH handshake, so a
+// server that accepts connections but never answers doesn't hang the
+// watchers sharing the connection. FTP connections time out in goftp.
+const dialTimeout = 30 * time.Second
+
 type watcher struct {
 	server    string
 	dir       string
 	user      string
 	password  string
-	secure    bool          // use SFTP if true
+	protocol  string        // one of the protocol* constants
 	frequency time.Duration // in seconds
-	handler   func(context.Context, os.FileInfo) error
-	stop      chan bool
-	fetcher   func(context.Context)
+	handler   func(context.Context, string, os.FileInfo, *remoteFile) error
+	conn      *connection
 
-	store *store
-}
+	// Closed to stop the watcher, which gives up on the event it's
+	// sending, leaving the file for the next probe, and saves the state
+	// first.
+	stop <-chan struct{}
 
-type configdata struct {
-	LastFileProcessed string
-	LastModTime       time.Time
-}
+	// Interval between keepalives of the connection, and the maximum time
+	// to wait between reconnect attempts.
+	keepalive  time.Duration
+	maxBackoff time.Duration
+
+	// TLS configuration for FTPS.
+	tlsConfig *tls.Config
+
+	// Authentication methods to try, in order, and the host key
+	// verification for SFTP.
+	sshAuth  []ssh.AuthMethod
+	hostKeys *hostKeyVerifier
+
+	// How long to remember files that have disappeared, and how many of
+	// them to remember at most.
+	retention  time.Duration
+	maxEntries int
+
+	// Descend into subdirectories, at most maxDepth levels deep.
+	recursive bool
+	maxDepth  int
+
+	// shard returns true for the subdirectories of the shard of the
+	// directory we watch, and sub "" for the files directly in it. Nil if
+	// we watch the whole directory.
+	shard func(sub string) bool
+
+	// Selects the files we send events for.
+	filter *fileFilter
+
+	// Detects when uploads have completed.
+	stability *stabilityCheck
 
-func NewWatcher(server string, dir string, user string, password string, secure bool, frequency time.Duration, handler func(context.Context, os.FileInfo) error, stop chan bool, store *store) *watcher {
-	return &watcher{server: server, dir: dir, user: user, password: password, secure: secure, frequency: frequency, handler: handler, stop: stop, store: store}
+	// What to do with files once their events have been delivered.
+	postAction *postAction
+
+	store StateStore
 }
 
-func (s *watcher) run(ctx context.Context) {
+// run probes the directory right away, and then at every interval, until
+// stop is closed. The watcher dials its own connection to the server, unless
+// it's given one to share with the watchers of other directories on the
+// server, which stays open while any of them runs.
+func (s *watcher) run(ctx context.Context, stop <-chan struct{}) {
 	logger := logging.FromContext(ctx)
 
-	if s.secure {
-		logger.Info("Using SFTP to fetch files")
-		s.fetcher = s.fetchSFTP
-	} else {
-		logger.Info("Using FTP to fetch files")
-		s.fetcher = s.fetchFTP
+	if s.conn == nil {
+		s.conn = newConnection(s.dialer(ctx), s.keepalive, s.maxBackoff)
 	}
-	tickChan := time.NewTicker(s.frequency).C
-	go func() {
-		for {
-			select {
-			case <-tickChan:
-				s.fetcher(ctx)
-			case <-s.stop:
-				logger.Info("Exiting")
-				return
-			}
+	s.conn.acquire()
+	s.stop = stop
+	ticker := time.NewTicker(s.frequency)
+	defer ticker.Stop()
+	for {
+		s.fetch(ctx)
+		select {
+		case <-ticker.C:
+		case <-stop:
+			logger.Info("Exiting")
+			s.conn.release()
+			return
 		}
+	}
+}
+
+// owns returns true if the files of the subdirectory, or the files directly
+// in the directory for sub "", are ours to watch.
+func (s *watcher) owns(sub string) bool {
+	return s.shard == nil || s.shard(sub)
+}
 
+// untilStopped returns a context that's cancelled once the watcher has been
+// asked to stop.
+func (s *watcher) untilStopped(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-ctx.Done():
+		}
 	}()
+	return ctx, cancel
 }
 
-func (s *watcher) processFiles(ctx context.Context, entries []os.FileInfo) {
+// stopping returns true once the watcher has been asked to stop.
+func (s *watcher) stopping() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// dialer returns the function to dial the server with.
+func (s *watcher) dialer(ctx context.Context) func(context.Context) (remote, error) {
 	logger := logging.FromContext(ctx)
-	configStore := s.store.Load()
-	var data configdata
-	if err := json.Unmarshal([]byte(configStore.data), &data); err != nil {
-		logger.Error("Failed to load configmap:", zap.Error(err))
+	switch s.protocol {
+	case protocolSFTP:
+		logger.Info("Using SFTP to fetch files")
+		return s.dialSFTP
+	case protocolFTPSExplicit, protocolFTPSImplicit:
+		logger.Info("Using FTPS to fetch files", zap.String("protocol", s.protocol))
+	default:
+		logger.Info("Using FTP to fetch files")
+	}
+	return s.dialFTP
+}
+
+// processFiles sends the events of the files of the listing. The files we
+// know about in the subdirectories that couldn't be listed are left as they
+// are. Every event is traced in a child span of the span of the listing.
+func (s *watcher) processFiles(ctx context.Context, client remote, entries []os.FileInfo, unlisted []string, listing trace.SpanContext) {
+	logger := logging.FromContext(ctx)
+	data, err := s.store.Load(ctx)
+	if err != nil {
+		logger.Error("Failed to load state:", zap.Error(err))
 		return
 	}
-	logger.Info("Loaded configdata:", zap.Any("data", data))
+	if shards, ok := s.store.(*shardStore); ok && data.Files == nil {
+		if data, err = s.migrateShards(ctx, client, shards); err != nil {
+			logger.Error("Failed to migrate the state of the directory:", zap.Error(err))
+			return
+		}
+	}
+	logger.Info("Loaded configdata:", zap.Int("files", len(data.Files)))
+
+	// We keep track of every file we've processed (by path, size and
+	// ModTime), so every new file gets sent exactly once, regardless of
+	// the order the ModTimes are in, and we can tell when a file has been
+	// modified or deleted since the last listing.
+	migrated := data.Files == nil
+	data.migrate(entries)
+	modified := migrated
+
+	present := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		present[e.Name()] = true
+	}
+	// We can't tell whether the files we didn't get to see are still there.
+	for name := range data.Files {
+		if !present[name] && inDirs(name, unlisted) {
+			present[name] = true
+		}
+	}
 
-	// This is super simple way to figure out what we've seen so far, we look
-	// at the ModTime and upon finishing the batch of files, we'll stash the
-	// highest we've seen.
-	// Better way would be to keep list of files we've seen (so that you could
-	// send deleted events, etc.). For now, we'll just stash the last file seen
-	// and the latest modtime. Not great, but will suffice for now.
-	highwaterMark := data.LastModTime
-	highwaterFile := data.LastFileProcessed
+	// Events are retried by the handler, and we only record a file as
+	// processed once the sink has ACKed its event, so it's sent again in
+	// the next probe otherwise. When the sink rejects an event, we carry on
+	// with the next one. When the sink is unavailable, or we fail to
+	// download a file the handler needs the content of, we stop, since the
+	// other events would fail just the same, and leave them for the next
+	// probe.
+	// The handler is given the files that are still on the server, for when
+	// it needs their content. Files that fail checksum verification are
+	// recorded as processed, since a failure event was sent for them, and
+	// so are modified files whose checksum didn't change, which no event
+	// is sent for, and files whose events were sent to the dead letter
+	// sink. The post delivery action is only applied to files the sink
+	// ACKed the event of.
+	// Sending an event can take minutes of retries, which we give up on
+	// when we're asked to stop, leaving the file for whoever carries on.
+	hctx, cancel := s.untilStopped(ctx)
+	defer cancel()
+	sinkDown := false
+	deliver := func(eventType string, e os.FileInfo, f *remoteFile) error {
+		fctx, span := startFileSpan(hctx, listing, s.server, s.dir, e.Name(), eventType)
+		err := s.handler(fctx, eventType, e, f)
+		if errors.Is(err, errUnchanged) || errors.Is(err, errDeadLettered) {
+			endSpan(span, nil)
+		} else {
+			endSpan(span, err)
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, errUnchanged):
+			logger.Info("Content unchanged, not sending the file again:", zap.String("file", e.Name()))
+		case errors.Is(err, errChecksumMismatch):
+			logger.Warn("Checksum mismatch, sent a failure event:", zap.String("file", e.Name()))
+		case errors.Is(err, errDeadLettered):
+			logger.Warn("Sent to the dead letter sink, leaving the file as it is:", zap.String("file", e.Name()))
+		case errors.Is(err, context.Canceled) && s.stopping():
+			logger.Info("Stopped while sending, leaving the file for the next probe:", zap.String("file", e.Name()))
+		case retriable(err):
+			logger.Error("Failed to post, leaving the remaining files for the next probe:", zap.String("file", e.Name()), zap.Error(err))
+			sinkDown = true
+		default:
+			logger.Error("Failed to post:", zap.String("file", e.Name()), zap.Error(err))
+		}
+		return err
+	}
 
+	// When we're asked to stop, we stop sending events, and save the state
+	// of the files we did send them for, so that whoever watches the
+	// directory next carries on from there.
+	now := time.Now()
 	for _, e := range entries {
-		if e.ModTime().Before(data.LastModTime) {
+		if sinkDown || s.stopping() {
+			break
+		}
+		if s.stability.isMarker(e.Name()) || s.postAction.ignores(e.Name()) {
+			continue
+		}
+		if !s.filter.matches(e.Name()) {
+			logger.Debug("Skipping filtered file:", zap.String("file", e.Name()))
+			metrics.Record(ctx, filesFilteredM.M(1))
+			continue
+		}
+		eventType := data.change(e.Name(), e)
+		if eventType == "" {
+			if data.Files[e.Name()].PendingAction {
+				s.applyPostAction(ctx, client, data, e.Name())
+				modified = true
+			}
 			continue
 		}
-		if e.Name() == highwaterFile {
+		if !s.stability.complete(e, present, now) {
+			logger.Info("Waiting for upload to complete:", zap.String("file", e.Name()))
 			continue
 		}
-		logger.Info("Found new file:", zap.String("file", e.Name()))
-		handlerErr := s.handler(ctx, e)
-		if handlerErr != nil {
-			logger.Error("Failed to post:", zap.Error(handlerErr))
+		logger.Info("Found changed file:", zap.String("file", e.Name()), zap.String("type", eventType))
+		recordFileDetected(ctx, eventType)
+		f := &remoteFile{client: client, path: path.Join(s.dir, e.Name())}
+		if eventType == event_type_modified {
+			name := e.Name()
+			f.unchanged = func(checksum string) bool { return data.unchanged(name, checksum) }
+		}
+		err := deliver(eventType, e, f)
+		if err != nil && !errors.Is(err, errChecksumMismatch) && !errors.Is(err, errUnchanged) && !errors.Is(err, errDeadLettered) {
+			continue
+		}
+		s.stability.done(e.Name())
+		data.markProcessed(e.Name(), e, f.checksum)
+		// Files that failed verification or delivery are left alone for
+		// someone to look into.
+		if err == nil {
+			s.applyPostAction(ctx, client, data, e.Name())
+		}
+		modified = true
+	}
+	s.stability.prune(present)
+
+	for _, e := range data.deleted(present) {
+		if sinkDown || s.stopping() {
 			break
 		}
-		if e.ModTime().After(highwaterMark) {
-			highwaterFile = e.Name()
-			highwaterMark = e.ModTime()
+		if !s.filter.matches(e.Name()) {
+			data.markGone(e.Name(), time.Now())
+			modified = true
+			continue
+		}
+		logger.Info("Found deleted file:", zap.String("file", e.Name()))
+		recordFileDetected(ctx, event_type_deleted)
+		if err := deliver(event_type_deleted, e, nil); err != nil && !errors.Is(err, errDeadLettered) {
+			continue
 		}
+		data.markGone(e.Name(), time.Now())
+		modified = true
 	}
-	if highwaterMark.After(data.LastModTime) {
-		logger.Info("Saving CONFIGMAP")
-		newConfigData := configdata{LastFileProcessed: highwaterFile, LastModTime: highwaterMark}
-		err := configStore.save(ctx, &newConfigData)
+
+	if data.compact(time.Now(), s.retention, s.maxEntries) {
+		modified = true
+	}
+
+	if modified {
+		logger.Info("Saving state")
+		err := s.store.Save(ctx, data)
 		if err != nil {
 			logger.Error("Failed to save the configdata:", zap.Error(err))
+			metrics.Record(ctx, stateSaveFailuresM.M(1))
 			return
 		}
 	}
 }
 
-func (s *watcher) fetchFTP(ctx context.Context) {
+// migrateShards migrates the state older versions kept for the sharded
+// directory, which needs a listing of the whole directory, and returns the
+// state of our shard afterwards.
+func (s *watcher) migrateShards(ctx context.Context, client remote, shards *shardStore) (*configdata, error) {
+	entries, unlisted, err := s.list(ctx, client.ReadDir, func(string) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+	// Files we don't get to see would be sent again.
+	if len(unlisted) > 0 {
+		return nil, fmt.Errorf("not migrating until %v can be listed", unlisted)
+	}
+	if err := shards.migrate(ctx, entries); err != nil {
+		return nil, err
+	}
+	return s.store.Load(ctx)
+}
+
+// applyPostAction applies the post delivery action to a file whose event was
+// delivered, and records the outcome.
+func (s *watcher) applyPostAction(ctx context.Context, client remote, data *configdata, name string) {
+	if !s.postAction.enabled() {
+		return
+	}
 	logger := logging.FromContext(ctx)
-	config := goftp.Config{
-		User:        s.user,
-		Password:    s.password,
-		DisableEPSV: true,
+	action, err := s.postAction.apply(client, s.dir, name, time.Now())
+	if err != nil {
+		logger.Error("Failed to apply the post delivery action, retrying in the next probe:", zap.String("file", name), zap.Error(err))
+		data.markActionPending(name)
+		return
 	}
+	logger.Info("Applied the post delivery action:", zap.String("file", name), zap.String("action", action))
+	data.markActioned(name, action, time.Now())
+}
 
-	logger.Info("making plain connection to", zap.String("server", s.server))
-	client, err := goftp.DialConfig(config, s.server)
+// fetch lists the files on the server and processes them.
+func (s *watcher) fetch(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	start := time.Now()
+	client, err := s.conn.get(ctx)
 	if err != nil {
-		logger.Error("Failed to dial:", zap.Error(err))
+		logger.Error("Failed to connect:", zap.String("server", s.server), zap.Error(err))
 		return
 	}
-	defer client.Close()
-	entries, err := client.ReadDir(s.dir)
+	_, listing := trace.StartSpan(ctx, spanListing)
+	listing.AddAttributes(
+		trace.StringAttribute("ftp.server", s.server),
+		trace.StringAttribute("ftp.directory", s.dir),
+	)
+	entries, unlisted, err := s.listFiles(ctx, client.ReadDir)
 	if err != nil {
+		endSpan(listing, err)
 		logger.Error("Failed to ReadDir:", zap.Error(err))
 		return
 	}
+	listing.AddAttributes(trace.Int64Attribute("ftp.files", int64(len(entries))))
+	endSpan(listing, nil)
+	metrics.Record(ctx, listingSizeM.M(int64(len(entries))))
+
+	s.processFiles(ctx, client, entries, unlisted, listing.SpanContext())
+	metrics.Record(ctx, probeDurationM.M(millis(time.Since(start))))
+}
+
+func (s *watcher) dialFTP(ctx context.Context) (remote, error) {
+	logger := logging.FromContext(ctx)
+	config := goftp.Config{
+		User:        s.user,
+		Password:    s.password,
+		DisableEPSV: true,
+	}
+	switch s.protocol {
+	case protocolFTPSExplicit:
+		config.TLSConfig = s.tlsConfig
+		config.TLSMode = goftp.TLSExplicit
+	case protocolFTPSImplicit:
+		config.TLSConfig = s.tlsConfig
+		config.TLSMode = goftp.TLSImplicit
+	}
 
-	s.processFiles(ctx, entries)
+	logger.Info("making connection to", zap.String("server", s.server), zap.String("protocol", s.protocol))
+	client, err := goftp.DialConfig(config, s.server)
+	if err != nil {
+		return nil, fmt.Errorf("dialing: %w", err)
+	}
+	// goftp only connects on the first command, make sure we can log in.
+	r := &ftpRemote{client}
+	if err := r.keepalive(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("logging in: %w", err)
+	}
+	return r, nil
 }
 
-func (s *watcher) fetchSFTP(ctx context.Context) {
+func (s *watcher) dialSFTP(ctx context.Context) (remote, error) {
 	logger := logging.FromContext(ctx)
 	sshConfig := &ssh.ClientConfig{
-		User: s.user,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(s.password),
-		},
-		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			return nil
-		},
+		User:            s.user,
+		Auth:            s.sshAuth,
+		HostKeyCallback: s.hostKeys.callback(ctx),
+		Timeout:         dialTimeout,
 	}
 
 	logger.Info("making ssh connection to", zap.String("server", s.server))
-	conn, err := ssh.Dial("tcp", s.server, sshConfig)
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", s.server)
 	if err != nil {
-		logger.Error("Failed to ssh dial:", zap.Error(err))
-		return
+		return nil, fmt.Errorf("ssh dialing: %w", err)
 	}
-
-	sftp, err := sftp.NewClient(conn)
+	// ssh.ClientConfig.Timeout only covers connecting, the handshake and
+	// starting the SFTP session get a deadline of their own.
+	if err := netConn.SetDeadline(time.Now().Add(dialTimeout)); err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("ssh dialing: %w", err)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(netConn, s.server, sshConfig)
 	if err != nil {
-		logger.Error("Failed to create new sftp client:", zap.Error(err))
-		return
+		netConn.Close()
+		return nil, fmt.Errorf("ssh handshake: %w", err)
 	}
-	defer sftp.Close()
+	conn := ssh.NewClient(c, chans, reqs)
 
-	entries, err := sftp.ReadDir(s.dir)
+	client, err := sftp.NewClient(conn)
 	if err != nil {
-		logger.Error("Failed to ReadDir:", zap.Error(err))
-		return
+		conn.Close()
+		return nil, fmt.Errorf("creating sftp client: %w", err)
 	}
-
-	s.processFiles(ctx, entries)
+	if err := netConn.SetDeadline(time.Time{}); err != nil {
+		client.Close()
+		conn.Close()
+		return nil, fmt.Errorf("creating sftp client: %w", err)
+	}
+	return &sftpRemote{Client: client, conn: conn}, nil
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"github.com/google/go-cmp/cmp"
+	"go.opencensus.io/trace"
+)
+
+// summarize describes the state of every file, like "2 deleted" for a file
+// of size 2 that was deleted once its event was ACKed.
+func summarize(data *configdata) map[string]string {
+	files := make(map[string]string, len(data.Files))
+	for name, fs := range data.Files {
+		s := fmt.Sprint(fs.Size)
+		switch {
+		case fs.Action != "":
+			s += " " + fs.Action
+		case fs.GoneSince != nil:
+			s += " gone"
+		}
+		if fs.PendingAction {
+			s += " pending"
+		}
+		files[name] = s
+	}
+	return files
+}
+
+func TestProcessFiles(t *testing.T) {
+	unavailable := cehttp.NewResult(http.StatusServiceUnavailable, "unavailable")
+	badRequest := cehttp.NewResult(http.StatusBadRequest, "bad")
+	tests := []struct {
+		name     string
+		saved    *configdata
+		entries  []os.FileInfo
+		unlisted []string
+		// How many probes uploads need to stay the same for.
+		probes int
+		// What the handler returns for the files, nil for the others.
+		results   map[string]error
+		wantSent  []string
+		wantFiles map[string]string
+		wantOps   []string
+	}{{
+		name:      "new files are sent and deleted once ACKed",
+		entries:   []os.FileInfo{file("a.csv", 1, t0), file("b.csv", 2, t1)},
+		wantSent:  []string{"fileadded a.csv", "fileadded b.csv"},
+		wantFiles: map[string]string{"a.csv": "1 deleted", "b.csv": "2 deleted"},
+		wantOps:   []string{"remove /in/a.csv", "remove /in/b.csv"},
+	}, {
+		name:      "processed files aren't sent again",
+		saved:     &configdata{Files: map[string]fileState{"a.csv": {Size: 1, ModTime: t0}}},
+		entries:   []os.FileInfo{file("a.csv", 1, t0)},
+		wantFiles: map[string]string{"a.csv": "1"},
+	}, {
+		name:      "failed post delivery action is retried without sending again",
+		saved:     &configdata{Files: map[string]fileState{"a.csv": {Size: 1, ModTime: t0, PendingAction: true}}},
+		entries:   []os.FileInfo{file("a.csv", 1, t0)},
+		wantFiles: map[string]string{"a.csv": "1 deleted"},
+		wantOps:   []string{"remove /in/a.csv"},
+	}, {
+		name:      "modified file",
+		saved:     &configdata{Files: map[string]fileState{"a.csv": {Size: 1, ModTime: t0}}},
+		entries:   []os.FileInfo{file("a.csv", 2, t1)},
+		wantSent:  []string{"filemodified a.csv"},
+		wantFiles: map[string]string{"a.csv": "2 deleted"},
+		wantOps:   []string{"remove /in/a.csv"},
+	}, {
+		name:      "modified file with unchanged content",
+		saved:     &configdata{Files: map[string]fileState{"a.csv": {Size: 1, ModTime: t0}}},
+		entries:   []os.FileInfo{file("a.csv", 1, t1)},
+		results:   map[string]error{"a.csv": errUnchanged},
+		wantSent:  []string{"filemodified a.csv"},
+		wantFiles: map[string]string{"a.csv": "1"},
+	}, {
+		name:      "sink unavailable leaves the remaining files for the next probe",
+		entries:   []os.FileInfo{file("a.csv", 1, t0), file("b.csv", 2, t0), file("c.csv", 3, t0)},
+		results:   map[string]error{"b.csv": unavailable},
+		wantSent:  []string{"fileadded a.csv", "fileadded b.csv"},
+		wantFiles: map[string]string{"a.csv": "1 deleted"},
+		wantOps:   []string{"remove /in/a.csv"},
+	}, {
+		name:      "rejected event is retried in the next probe, the others are sent",
+		entries:   []os.FileInfo{file("a.csv", 1, t0), file("b.csv", 2, t0), file("c.csv", 3, t0)},
+		results:   map[string]error{"b.csv": badRequest},
+		wantSent:  []string{"fileadded a.csv", "fileadded b.csv", "fileadded c.csv"},
+		wantFiles: map[string]string{"a.csv": "1 deleted", "c.csv": "3 deleted"},
+		wantOps:   []string{"remove /in/a.csv", "remove /in/c.csv"},
+	}, {
+		name:      "dead lettered file is recorded and left alone",
+		entries:   []os.FileInfo{file("a.csv", 1, t0), file("b.csv", 2, t0)},
+		results:   map[string]error{"a.csv": fmt.Errorf("%w, after: %v", errDeadLettered, badRequest)},
+		wantSent:  []string{"fileadded a.csv", "fileadded b.csv"},
+		wantFiles: map[string]string{"a.csv": "1", "b.csv": "2 deleted"},
+		wantOps:   []string{"remove /in/b.csv"},
+	}, {
+		name:      "file failing verification is recorded and left alone",
+		entries:   []os.FileInfo{file("a.csv", 1, t0)},
+		results:   map[string]error{"a.csv": errChecksumMismatch},
+		wantSent:  []string{"fileadded a.csv"},
+		wantFiles: map[string]string{"a.csv": "1"},
+	}, {
+		name: "deleted file",
+		saved: &configdata{Files: map[string]fileState{
+			"a.csv": {Size: 1, ModTime: t0},
+			"b.csv": {Size: 2, ModTime: t0},
+		}},
+		entries:   []os.FileInfo{file("a.csv", 1, t0)},
+		wantSent:  []string{"filedeleted b.csv"},
+		wantFiles: map[string]string{"a.csv": "1", "b.csv": "2 gone"},
+	}, {
+		name: "deleted event not ACKed",
+		saved: &configdata{Files: map[string]fileState{
+			"b.csv": {Size: 2, ModTime: t0},
+		}},
+		results:   map[string]error{"b.csv": unavailable},
+		wantSent:  []string{"filedeleted b.csv"},
+		wantFiles: map[string]string{"b.csv": "2"},
+	}, {
+		name: "deleted event dead lettered",
+		saved: &configdata{Files: map[string]fileState{
+			"b.csv": {Size: 2, ModTime: t0},
+		}},
+		results:   map[string]error{"b.csv": fmt.Errorf("%w, after: %v", errDeadLettered, badRequest)},
+		wantSent:  []string{"filedeleted b.csv"},
+		wantFiles: map[string]string{"b.csv": "2 gone"},
+	}, {
+		name: "files of subdirectories that couldn't be listed aren't deleted",
+		saved: &configdata{Files: map[string]fileState{
+			"a.csv":   {Size: 1, ModTime: t0},
+			"x/b.csv": {Size: 2, ModTime: t0},
+		}},
+		entries:   []os.FileInfo{file("a.csv", 1, t0)},
+		unlisted:  []string{"x"},
+		wantFiles: map[string]string{"a.csv": "1", "x/b.csv": "2"},
+	}, {
+		name:      "high water mark of older versions",
+		saved:     &configdata{LastFileProcessed: "b.csv", LastModTime: &t1},
+		entries:   []os.FileInfo{file("a.csv", 1, t0), file("b.csv", 2, t1), file("c.csv", 3, t1), file("d.csv", 4, t2)},
+		wantSent:  []string{"fileadded c.csv", "fileadded d.csv"},
+		wantFiles: map[string]string{"a.csv": "1", "b.csv": "2", "c.csv": "3 deleted", "d.csv": "4 deleted"},
+		wantOps:   []string{"remove /in/c.csv", "remove /in/d.csv"},
+	}, {
+		name:      "upload still in progress",
+		entries:   []os.FileInfo{file("a.csv", 1, t0)},
+		probes:    2,
+		wantFiles: map[string]string{},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := &memStore{}
+			if test.saved != nil {
+				if err := store.Save(ctx, test.saved); err != nil {
+					t.Fatalf("Save() = %v", err)
+				}
+			}
+			client := &fakeRemote{}
+			var sent []string
+			w := &watcher{
+				dir:        "/in",
+				retention:  time.Hour,
+				filter:     &fileFilter{},
+				stability:  newStabilityCheck(test.probes, 0, nil),
+				postAction: &postAction{action: postActionDelete},
+				store:      store,
+				handler: func(ctx context.Context, eventType string, e os.FileInfo, f *remoteFile) error {
+					sent = append(sent, strings.TrimPrefix(eventType, "org.aikas.ftp.")+" "+e.Name())
+					return test.results[e.Name()]
+				},
+			}
+
+			w.processFiles(ctx, client, test.entries, test.unlisted, trace.SpanContext{})
+
+			if diff := cmp.Diff(test.wantSent, sent); diff != "" {
+				t.Errorf("sent (-want, +got): %s", diff)
+			}
+			data, err := store.Load(ctx)
+			if err != nil {
+				t.Fatalf("Load() = %v", err)
+			}
+			if diff := cmp.Diff(test.wantFiles, summarize(data)); diff != "" {
+				t.Errorf("saved files (-want, +got): %s", diff)
+			}
+			if diff := cmp.Diff(test.wantOps, client.ops); diff != "" {
+				t.Errorf("ops (-want, +got): %s", diff)
+			}
+		})
+	}
+}
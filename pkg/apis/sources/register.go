@@ -0,0 +1,6 @@
+package sources
+
+const (
+	// GroupName is the API group the FTPSource lives in.
+	GroupName = "sources.knative.dev"
+)
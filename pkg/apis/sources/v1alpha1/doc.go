@@ -0,0 +1,4 @@
+// Package v1alpha1 contains API Schema definitions for the sources v1alpha1 API group
+// +k8s:deepcopy-gen=package
+// +groupName=sources.knative.dev
+package v1alpha1
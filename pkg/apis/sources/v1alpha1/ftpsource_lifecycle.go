@@ -0,0 +1,80 @@
+package v1alpha1
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/apis"
+)
+
+var ftpCondSet = apis.NewLivingConditionSet(
+	FTPSourceConditionSinkProvided,
+	FTPSourceConditionDeployed,
+)
+
+// GetConditionSet retrieves the condition set for this resource. Implements the KRShaped interface.
+func (*FTPSource) GetConditionSet() apis.ConditionSet {
+	return ftpCondSet
+}
+
+// GetCondition returns the condition currently associated with the given type, or nil.
+func (s *FTPSourceStatus) GetCondition(t apis.ConditionType) *apis.Condition {
+	return ftpCondSet.Manage(s).GetCondition(t)
+}
+
+// GetTopLevelCondition returns the top level condition.
+func (s *FTPSourceStatus) GetTopLevelCondition() *apis.Condition {
+	return ftpCondSet.Manage(s).GetTopLevelCondition()
+}
+
+// IsReady returns true if the resource is ready overall.
+func (s *FTPSourceStatus) IsReady() bool {
+	return ftpCondSet.Manage(s).IsHappy()
+}
+
+// InitializeConditions sets relevant unset conditions to Unknown state.
+func (s *FTPSourceStatus) InitializeConditions() {
+	ftpCondSet.Manage(s).InitializeConditions()
+}
+
+// MarkSink sets the condition that the source has a sink configured.
+func (s *FTPSourceStatus) MarkSink(uri *apis.URL) {
+	s.SinkURI = uri
+	if uri != nil && !uri.IsEmpty() {
+		ftpCondSet.Manage(s).MarkTrue(FTPSourceConditionSinkProvided)
+	} else {
+		ftpCondSet.Manage(s).MarkFalse(FTPSourceConditionSinkProvided, "SinkEmpty", "Sink has resolved to empty.")
+	}
+}
+
+// MarkNoSink sets the condition that the source does not have a sink configured.
+func (s *FTPSourceStatus) MarkNoSink(reason, messageFormat string, messageA ...interface{}) {
+	s.SinkURI = nil
+	ftpCondSet.Manage(s).MarkFalse(FTPSourceConditionSinkProvided, reason, messageFormat, messageA...)
+}
+
+// PropagateDeploymentAvailability uses the availability of the provided Deployment to determine if
+// FTPSourceConditionDeployed should be marked as true or false.
+func (s *FTPSourceStatus) PropagateDeploymentAvailability(d *appsv1.Deployment) {
+	deploymentAvailableFound := false
+	for _, cond := range d.Status.Conditions {
+		if cond.Type == appsv1.DeploymentAvailable {
+			deploymentAvailableFound = true
+			if cond.Status == corev1.ConditionTrue {
+				ftpCondSet.Manage(s).MarkTrue(FTPSourceConditionDeployed)
+			} else if cond.Status == corev1.ConditionFalse {
+				ftpCondSet.Manage(s).MarkFalse(FTPSourceConditionDeployed, cond.Reason, cond.Message)
+			} else if cond.Status == corev1.ConditionUnknown {
+				ftpCondSet.Manage(s).MarkUnknown(FTPSourceConditionDeployed, cond.Reason, cond.Message)
+			}
+		}
+	}
+	if !deploymentAvailableFound {
+		ftpCondSet.Manage(s).MarkUnknown(FTPSourceConditionDeployed, "DeploymentUnavailable", "The Deployment '%s' is unavailable.", d.Name)
+	}
+}
+
+// MarkNoDeployment sets the condition that the receive adapter Deployment
+// could not be reconciled.
+func (s *FTPSourceStatus) MarkNoDeployment(reason, messageFormat string, messageA ...interface{}) {
+	ftpCondSet.Manage(s).MarkFalse(FTPSourceConditionDeployed, reason, messageFormat, messageA...)
+}
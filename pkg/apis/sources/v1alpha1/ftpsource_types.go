@@ -0,0 +1,570 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/kmeta"
+)
+
+// +genclient
+// +genreconciler
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// FTPSource watches a directory on an FTP / SFTP server and sends an event
+// to the sink for every new file uploaded.
+type FTPSource struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FTPSourceSpec   `json:"spec"`
+	Status FTPSourceStatus `json:"status,omitempty"`
+}
+
+// GetGroupVersionKind returns the GroupVersionKind.
+func (*FTPSource) GetGroupVersionKind() schema.GroupVersionKind {
+	return SchemeGroupVersion.WithKind("FTPSource")
+}
+
+var (
+	// Check that FTPSource is a runtime.Object.
+	_ runtime.Object = (*FTPSource)(nil)
+	// Check that we can create OwnerReferences to an FTPSource.
+	_ kmeta.OwnerRefable = (*FTPSource)(nil)
+	// Check that the type conforms to the duck Knative Resource shape.
+	_ duckv1.KRShaped = (*FTPSource)(nil)
+)
+
+const (
+	// ProtocolFTP is plain FTP.
+	ProtocolFTP = "ftp"
+	// ProtocolFTPSExplicit is FTP upgraded to TLS with AUTH TLS.
+	ProtocolFTPSExplicit = "ftps-explicit"
+	// ProtocolFTPSImplicit is FTP over a TLS connection.
+	ProtocolFTPSImplicit = "ftps-implicit"
+	// ProtocolSFTP is FTP over SSH.
+	ProtocolSFTP = "sftp"
+)
+
+// FTPSourceSpec defines the desired state of the FTPSource.
+type FTPSourceSpec struct {
+	// inherits duck/v1 SourceSpec, which currently provides:
+	// * Sink - a reference to an object that will resolve to a domain name or
+	//   a URI directly to use as the sink.
+	// * CloudEventOverrides - defines overrides to control the output format
+	//   and modifications of the event sent to the sink.
+	duckv1.SourceSpec `json:",inline"`
+
+	// Server is the host name or IP address of the server to watch.
+	Server string `json:"server"`
+
+	// Port to connect to. Defaults to 22 for sftp, 990 for ftps-implicit
+	// and 21 otherwise.
+	// +optional
+	Port int32 `json:"port,omitempty"`
+
+	// Protocol is one of ftp, ftps-explicit, ftps-implicit or sftp.
+	// Defaults to sftp.
+	// +optional
+	Protocol string `json:"protocol,omitempty"`
+
+	// TLS configures the TLS connection for the ftps protocols.
+	// +optional
+	TLS FTPTLS `json:"tls,omitempty"`
+
+	// Directory to watch files in. Defaults to the login directory.
+	// +optional
+	Directory string `json:"directory,omitempty"`
+
+	// Recursive makes the source also watch files in subdirectories of
+	// Directory.
+	// +optional
+	Recursive bool `json:"recursive,omitempty"`
+
+	// MaxDepth is the maximum number of subdirectory levels to descend into
+	// when Recursive is set. Defaults to no limit.
+	// +optional
+	MaxDepth int32 `json:"maxDepth,omitempty"`
+
+	// Filter selects the files events are sent for. Defaults to all files.
+	// +optional
+	Filter FTPFilter `json:"filter,omitempty"`
+
+	// Stability controls when the upload of a file is considered complete.
+	// Defaults to sending events as soon as a file is seen.
+	// +optional
+	Stability FTPStability `json:"stability,omitempty"`
+
+	// Credentials used to log into the server.
+	// +optional
+	Credentials FTPCredentials `json:"credentials,omitempty"`
+
+	// HostKey configures how the host key of sftp servers is verified.
+	// Defaults to trusting the key seen on first connection.
+	// +optional
+	HostKey FTPHostKey `json:"hostKey,omitempty"`
+
+	// Events configures the type and source of the events. Static
+	// extensions are set with CloudEventOverrides.
+	// +optional
+	Events FTPEvents `json:"events,omitempty"`
+
+	// Checksum computes the checksums of files and verifies them. Defaults
+	// to not computing checksums.
+	// +optional
+	Checksum FTPChecksum `json:"checksum,omitempty"`
+
+	// InlineContent embeds the content of small files in their events.
+	// Defaults to only sending the metadata of files.
+	// +optional
+	InlineContent FTPInlineContent `json:"inlineContent,omitempty"`
+
+	// ClaimCheck copies files to an object store before sending their
+	// events, which then reference the copies instead of the server.
+	// Defaults to off.
+	// +optional
+	ClaimCheck FTPClaimCheck `json:"claimCheck,omitempty"`
+
+	// Delivery configures how events the sink doesn't ACK are retried, and
+	// the dead letter sink events that still fail after the retries are
+	// sent to. Defaults to 4 retries with exponential backoff, starting at
+	// 1s, and no dead letter sink.
+	// +optional
+	Delivery *eventingduckv1.DeliverySpec `json:"delivery,omitempty"`
+
+	// PostDelivery configures what is done with files on the server once
+	// their events have been delivered. Defaults to leaving them in place.
+	// +optional
+	PostDelivery FTPPostDelivery `json:"postDelivery,omitempty"`
+
+	// State configures where the source keeps track of the files it has
+	// sent events for. Defaults to a ConfigMap.
+	// +optional
+	State FTPState `json:"state,omitempty"`
+
+	// Interval between two probes of the directory. Defaults to 10s.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Replicas is the number of receive adapter Pods. They elect a leader
+	// with a Lease, and only the leader watches the directory, while the
+	// others take over when it fails. The file state backend only supports
+	// one replica. Defaults to 1.
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Shards splits the subdirectories of Directory into this many shards
+	// when Recursive is set, which are spread over the Replicas, so that
+	// each replica only lists and watches the subdirectories of its shards.
+	// Shards are rebalanced when replicas come and go, and keep their own
+	// state. Defaults to 1.
+	// +optional
+	Shards int32 `json:"shards,omitempty"`
+}
+
+// FTPTLS configures the TLS connection to FTPS servers.
+type FTPTLS struct {
+	// SecretRef names a Secret in the namespace of the FTPSource holding
+	// the optional `ca.crt` CA bundle to verify the server certificate with
+	// instead of the system roots, and the optional `tls.crt` and `tls.key`
+	// client certificate and key.
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// InsecureSkipVerify skips verifying the server certificate, which
+	// makes the connection vulnerable to man in the middle attacks. Only
+	// meant for testing.
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// FTPFilter selects the files events are sent for. A file is selected if it
+// matches at least one of the include patterns (or there are none), and none
+// of the exclude patterns. Glob patterns containing a "/" are matched against
+// the path of the file relative to Directory, other globs against the base
+// name of the file. Regular expressions are matched against the relative path.
+type FTPFilter struct {
+	// Include lists the glob patterns of the files to send events for.
+	// +optional
+	Include []string `json:"include,omitempty"`
+
+	// Exclude lists the glob patterns of the files not to send events for.
+	// +optional
+	Exclude []string `json:"exclude,omitempty"`
+
+	// IncludeRegex lists the regular expressions of the files to send
+	// events for.
+	// +optional
+	IncludeRegex []string `json:"includeRegex,omitempty"`
+
+	// ExcludeRegex lists the regular expressions of the files not to send
+	// events for.
+	// +optional
+	ExcludeRegex []string `json:"excludeRegex,omitempty"`
+}
+
+// FTPStability controls when the upload of a file is considered complete,
+// so that no events are sent for files that are still being written. A file
+// is complete once its size and modification time have been unchanged for
+// Probes consecutive probes and for QuietPeriod, and, if MarkerSuffixes are
+// given, a marker file exists next to it.
+type FTPStability struct {
+	// Probes is the number of consecutive probes the size and modification
+	// time of a file must be unchanged in. Defaults to 1.
+	// +optional
+	Probes int32 `json:"probes,omitempty"`
+
+	// QuietPeriod is how long the size and modification time of a file
+	// must be unchanged for.
+	// +optional
+	QuietPeriod *metav1.Duration `json:"quietPeriod,omitempty"`
+
+	// MarkerSuffixes lists suffixes of marker files. When set, a file is
+	// only complete once a file named like it with one of the suffixes
+	// appended exists, for example foo.csv.done for foo.csv. No events are
+	// sent for the marker files themselves.
+	// +optional
+	MarkerSuffixes []string `json:"markerSuffixes,omitempty"`
+}
+
+const (
+	// AuthMethodPublicKey authenticates to SFTP servers with the private
+	// key, and certificate if there is one.
+	AuthMethodPublicKey = "publickey"
+	// AuthMethodPassword authenticates to SFTP servers with the password.
+	AuthMethodPassword = "password"
+	// AuthMethodKeyboardInteractive authenticates to SFTP servers by
+	// answering keyboard-interactive prompts with the password.
+	AuthMethodKeyboardInteractive = "keyboard-interactive"
+)
+
+// FTPCredentials points at the credentials used to log into the server.
+type FTPCredentials struct {
+	// SecretRef names a Secret in the namespace of the FTPSource holding
+	// the `user` key, and the `password` key and/or, for sftp, the
+	// `privateKey` key. The private key may be PEM or OpenSSH encoded, and
+	// protected by the passphrase in the optional `privateKeyPassphrase`
+	// key. The optional `certificate` key holds an OpenSSH certificate for
+	// the private key.
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// AuthMethods lists the sftp authentication methods to try, in order.
+	// Each is one of publickey, password or keyboard-interactive. Defaults
+	// to every method the Secret holds credentials for, in that order.
+	// +optional
+	AuthMethods []string `json:"authMethods,omitempty"`
+}
+
+// FTPEvents configures the type and source of the events, so that
+// different teams can route on them.
+type FTPEvents struct {
+	// TypePrefix replaces the org.aikas.ftp prefix of the event types, which
+	// are followed by .fileadded, .filemodified and so on.
+	// +optional
+	TypePrefix string `json:"typePrefix,omitempty"`
+
+	// SourceTemplate is a Go text/template of the source of the events,
+	// executed with .Server (host:port), .Host, .Port, .Directory and
+	// .Protocol, for example ftp://{{ .Host }}/partners/acme. Defaults to
+	// //<server>:<port>/<directory>.
+	// +optional
+	SourceTemplate string `json:"sourceTemplate,omitempty"`
+}
+
+const (
+	// ChecksumSHA256 computes SHA-256 checksums.
+	ChecksumSHA256 = "sha256"
+	// ChecksumMD5 computes MD5 checksums.
+	ChecksumMD5 = "md5"
+	// ChecksumCRC32 computes CRC-32 checksums.
+	ChecksumCRC32 = "crc32"
+
+	// ChecksumVerifySidecar verifies files against the checksum in a
+	// sidecar file named like the file with the algorithm appended, for
+	// example report.csv.sha256.
+	ChecksumVerifySidecar = "sidecar"
+	// ChecksumVerifyServer verifies files against the checksum computed by
+	// the server, for FTP servers supporting the HASH command or the older
+	// XSHA256, XMD5 or XCRC commands, and SFTP servers supporting the
+	// check-file extension, which OpenSSH doesn't.
+	ChecksumVerifyServer = "server"
+)
+
+// FTPChecksum configures computing the checksums of files, which are
+// included in their events, and verifying them. When a file fails
+// verification, a failure event is sent instead of its event.
+type FTPChecksum struct {
+	// Algorithm is one of sha256, md5 or crc32. Checksums are not computed
+	// if empty.
+	// +optional
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// Verify lists where to get the checksums to verify files against from,
+	// sidecar or server, in order. Files there's no checksum for are sent
+	// unverified.
+	// +optional
+	Verify []string `json:"verify,omitempty"`
+}
+
+const (
+	// InlineEncodingBinary makes the content the data of the event, with
+	// the media type of the file as its datacontenttype.
+	InlineEncodingBinary = "binary"
+	// InlineEncodingBase64 base64 encodes the content into the JSON data of
+	// the event.
+	InlineEncodingBase64 = "base64"
+)
+
+// FTPInlineContent configures embedding the content of small files in their
+// events, so that consumers don't need credentials for the server.
+type FTPInlineContent struct {
+	// MaxSize is the size in bytes up to which the content of files is
+	// embedded. Larger files are sent with their metadata only. Defaults
+	// to 0, which never embeds the content.
+	// +optional
+	MaxSize int64 `json:"maxSize,omitempty"`
+
+	// Encoding is binary or base64. Defaults to binary.
+	// +optional
+	Encoding string `json:"encoding,omitempty"`
+}
+
+const (
+	// PostDeliveryNone leaves files in place.
+	PostDeliveryNone = "none"
+	// PostDeliveryDelete deletes files.
+	PostDeliveryDelete = "delete"
+	// PostDeliveryMove moves files to the archive directory.
+	PostDeliveryMove = "move"
+	// PostDeliveryRename appends a suffix to the names of files.
+	PostDeliveryRename = "rename"
+)
+
+// FTPPostDelivery configures what is done with files on the server once
+// their events have been delivered.
+type FTPPostDelivery struct {
+	// Action is one of none, delete, move or rename. Defaults to none.
+	// +optional
+	Action string `json:"action,omitempty"`
+
+	// ArchiveDirectory is the directory the move action moves files to,
+	// relative to Directory unless absolute. Files keep their path relative
+	// to Directory below it. It's a Go text/template, where .Time is the
+	// time the file is moved at, for example
+	// /archive/{{ .Time.Format "2006/01/02" }}. When Recursive is set, it
+	// must be outside of Directory, or the moved files would be sent again.
+	// +optional
+	ArchiveDirectory string `json:"archiveDirectory,omitempty"`
+
+	// RenameSuffix is the suffix the rename action appends to the names of
+	// files. Files with the suffix are ignored. Defaults to .processed.
+	// +optional
+	RenameSuffix string `json:"renameSuffix,omitempty"`
+}
+
+const (
+	// StateBackendConfigMap keeps state in a ConfigMap, which is limited to
+	// 1MiB.
+	StateBackendConfigMap = "configmap"
+	// StateBackendFile keeps state in a database file on a
+	// PersistentVolumeClaim.
+	StateBackendFile = "file"
+	// StateBackendRedis keeps state in Redis.
+	StateBackendRedis = "redis"
+)
+
+// FTPState configures where the source keeps track of the files it has sent
+// events for.
+type FTPState struct {
+	// Backend is one of configmap, file or redis. Defaults to configmap.
+	// +optional
+	Backend string `json:"backend,omitempty"`
+
+	// File configures the file backend.
+	// +optional
+	File *FTPStateFile `json:"file,omitempty"`
+
+	// Redis configures the redis backend.
+	// +optional
+	Redis *FTPStateRedis `json:"redis,omitempty"`
+}
+
+// FTPStateFile configures the file state backend.
+type FTPStateFile struct {
+	// ClaimName names the PersistentVolumeClaim in the namespace of the
+	// FTPSource to keep the database file on.
+	ClaimName string `json:"claimName"`
+}
+
+// FTPStateRedis configures the redis state backend.
+type FTPStateRedis struct {
+	// Address is the host:port of the Redis server.
+	Address string `json:"address"`
+
+	// DB is the number of the database to use.
+	// +optional
+	DB int32 `json:"db,omitempty"`
+
+	// PasswordSecretRef selects the key of a Secret in the namespace of the
+	// FTPSource holding the password of the Redis server.
+	// +optional
+	PasswordSecretRef *corev1.SecretKeySelector `json:"passwordSecretRef,omitempty"`
+}
+
+// FTPHostKey configures how the host key of sftp servers is verified. A key
+// is accepted if its fingerprint is one of Fingerprints, or KnownHosts lists
+// it for the server. With TrustOnFirstUse, the key of a server none of those
+// know about is recorded the first time the source connects, and only that
+// key is accepted afterwards. TrustOnFirstUse is the default when neither
+// KnownHosts nor Fingerprints are given.
+type FTPHostKey struct {
+	// KnownHosts points at a known_hosts file in a Secret or ConfigMap.
+	// +optional
+	KnownHosts *FTPKnownHosts `json:"knownHosts,omitempty"`
+
+	// Fingerprints lists the SHA256 fingerprints of the host keys to
+	// accept, as printed by `ssh-keygen -l`, for example
+	// SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8.
+	// +optional
+	Fingerprints []string `json:"fingerprints,omitempty"`
+
+	// TrustOnFirstUse trusts the host key seen on first connection.
+	// +optional
+	TrustOnFirstUse bool `json:"trustOnFirstUse,omitempty"`
+
+	// InsecureIgnoreHostKey accepts any host key, which makes the
+	// connection vulnerable to man in the middle attacks. Only meant for
+	// testing.
+	// +optional
+	InsecureIgnoreHostKey bool `json:"insecureIgnoreHostKey,omitempty"`
+}
+
+// FTPKnownHosts points at a known_hosts file. Exactly one of SecretKeyRef
+// and ConfigMapKeyRef must be set.
+type FTPKnownHosts struct {
+	// SecretKeyRef selects the key of a Secret holding the file.
+	// +optional
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+
+	// ConfigMapKeyRef selects the key of a ConfigMap holding the file.
+	// +optional
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+}
+
+const (
+	// FTPSourceConditionReady has status True when the FTPSource is ready to send events.
+	FTPSourceConditionReady = apis.ConditionReady
+
+	// FTPSourceConditionSinkProvided has status True when the FTPSource has been configured with a sink target.
+	FTPSourceConditionSinkProvided apis.ConditionType = "SinkProvided"
+
+	// FTPSourceConditionDeployed has status True when the FTPSource has had its receive adapter deployment created.
+	FTPSourceConditionDeployed apis.ConditionType = "Deployed"
+)
+
+// FTPSourceStatus defines the observed state of the FTPSource.
+type FTPSourceStatus struct {
+	// inherits duck/v1 SourceStatus, which currently provides:
+	// * ObservedGeneration - the 'Generation' of the Service that was last
+	//   processed by the controller.
+	// * Conditions - the latest available observations of a resource's current
+	//   state.
+	// * SinkURI - the current active sink URI that has been configured for the
+	//   Source.
+	duckv1.SourceStatus `json:",inline"`
+
+	// DeadLetterSinkURI is the resolved URI of the dead letter sink, if
+	// there is one.
+	// +optional
+	DeadLetterSinkURI *apis.URL `json:"deadLetterSinkUri,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// FTPSourceList contains a list of FTPSources.
+type FTPSourceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FTPSource `json:"items"`
+}
+
+// GetStatus retrieves the status of the FTPSource. Implements the KRShaped interface.
+func (s *FTPSource) GetStatus() *duckv1.Status {
+	return &s.Status.Status
+}
+
+const (
+	// ClaimCheckBackendS3 copies files to a bucket on S3 or an S3
+	// compatible store like MinIO.
+	ClaimCheckBackendS3 = "s3"
+	// ClaimCheckBackendVolume copies files to a PersistentVolumeClaim.
+	ClaimCheckBackendVolume = "volume"
+)
+
+// FTPClaimCheck configures copying files to an object store before sending
+// their events, so consumers don't need credentials for the server, or the
+// server to be available, to read them. Files whose content is inlined in
+// their events aren't copied.
+type FTPClaimCheck struct {
+	// Backend is one of s3 or volume. Claim-check mode is off if empty.
+	// +optional
+	Backend string `json:"backend,omitempty"`
+
+	// MinSize is the size in bytes from which files are copied. Smaller
+	// files are sent without a copy. Defaults to 0, which copies all files.
+	// +optional
+	MinSize int64 `json:"minSize,omitempty"`
+
+	// Prefix of the keys of the copies, which are followed by the server,
+	// Directory and the path of the file relative to it, like
+	// <prefix>/ftp.example.com:21/outgoing/report.csv.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// S3 configures the s3 backend.
+	// +optional
+	S3 *FTPClaimCheckS3 `json:"s3,omitempty"`
+
+	// Volume configures the volume backend.
+	// +optional
+	Volume *FTPClaimCheckVolume `json:"volume,omitempty"`
+}
+
+// FTPClaimCheckS3 configures the s3 claim-check backend.
+type FTPClaimCheckS3 struct {
+	// Bucket to copy files to.
+	Bucket string `json:"bucket"`
+
+	// Endpoint of the store, for S3 compatible stores other than AWS.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Region of the bucket. Defaults to us-east-1.
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// ForcePathStyle addresses the bucket in the path of URLs instead of
+	// the host name, as MinIO needs.
+	// +optional
+	ForcePathStyle bool `json:"forcePathStyle,omitempty"`
+
+	// AccessKeyID and SecretAccessKey select the keys of Secrets in the
+	// namespace of the FTPSource holding the credentials for the store.
+	// +optional
+	AccessKeyID *corev1.SecretKeySelector `json:"accessKeyId,omitempty"`
+	// +optional
+	SecretAccessKey *corev1.SecretKeySelector `json:"secretAccessKey,omitempty"`
+}
+
+// FTPClaimCheckVolume configures the volume claim-check backend.
+type FTPClaimCheckVolume struct {
+	// ClaimName names the PersistentVolumeClaim in the namespace of the
+	// FTPSource to copy files to. It's usually ReadWriteMany, so that
+	// consumers can mount it too.
+	ClaimName string `json:"claimName"`
+}
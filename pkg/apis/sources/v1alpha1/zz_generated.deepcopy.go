@@ -0,0 +1,517 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by deepcopy-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	duckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	apis "knative.dev/pkg/apis"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPChecksum) DeepCopyInto(out *FTPChecksum) {
+	*out = *in
+	if in.Verify != nil {
+		in, out := &in.Verify, &out.Verify
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPChecksum.
+func (in *FTPChecksum) DeepCopy() *FTPChecksum {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPChecksum)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPClaimCheck) DeepCopyInto(out *FTPClaimCheck) {
+	*out = *in
+	if in.S3 != nil {
+		in, out := &in.S3, &out.S3
+		*out = new(FTPClaimCheckS3)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Volume != nil {
+		in, out := &in.Volume, &out.Volume
+		*out = new(FTPClaimCheckVolume)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPClaimCheck.
+func (in *FTPClaimCheck) DeepCopy() *FTPClaimCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPClaimCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPClaimCheckS3) DeepCopyInto(out *FTPClaimCheckS3) {
+	*out = *in
+	if in.AccessKeyID != nil {
+		in, out := &in.AccessKeyID, &out.AccessKeyID
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SecretAccessKey != nil {
+		in, out := &in.SecretAccessKey, &out.SecretAccessKey
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPClaimCheckS3.
+func (in *FTPClaimCheckS3) DeepCopy() *FTPClaimCheckS3 {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPClaimCheckS3)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPClaimCheckVolume) DeepCopyInto(out *FTPClaimCheckVolume) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPClaimCheckVolume.
+func (in *FTPClaimCheckVolume) DeepCopy() *FTPClaimCheckVolume {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPClaimCheckVolume)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPCredentials) DeepCopyInto(out *FTPCredentials) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+	if in.AuthMethods != nil {
+		in, out := &in.AuthMethods, &out.AuthMethods
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPCredentials.
+func (in *FTPCredentials) DeepCopy() *FTPCredentials {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPCredentials)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPEvents) DeepCopyInto(out *FTPEvents) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPEvents.
+func (in *FTPEvents) DeepCopy() *FTPEvents {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPEvents)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPFilter) DeepCopyInto(out *FTPFilter) {
+	*out = *in
+	if in.Include != nil {
+		in, out := &in.Include, &out.Include
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exclude != nil {
+		in, out := &in.Exclude, &out.Exclude
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.IncludeRegex != nil {
+		in, out := &in.IncludeRegex, &out.IncludeRegex
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludeRegex != nil {
+		in, out := &in.ExcludeRegex, &out.ExcludeRegex
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPFilter.
+func (in *FTPFilter) DeepCopy() *FTPFilter {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPFilter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPHostKey) DeepCopyInto(out *FTPHostKey) {
+	*out = *in
+	if in.KnownHosts != nil {
+		in, out := &in.KnownHosts, &out.KnownHosts
+		*out = new(FTPKnownHosts)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Fingerprints != nil {
+		in, out := &in.Fingerprints, &out.Fingerprints
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPHostKey.
+func (in *FTPHostKey) DeepCopy() *FTPHostKey {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPHostKey)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPInlineContent) DeepCopyInto(out *FTPInlineContent) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPInlineContent.
+func (in *FTPInlineContent) DeepCopy() *FTPInlineContent {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPInlineContent)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPKnownHosts) DeepCopyInto(out *FTPKnownHosts) {
+	*out = *in
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ConfigMapKeyRef != nil {
+		in, out := &in.ConfigMapKeyRef, &out.ConfigMapKeyRef
+		*out = new(v1.ConfigMapKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPKnownHosts.
+func (in *FTPKnownHosts) DeepCopy() *FTPKnownHosts {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPKnownHosts)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPPostDelivery) DeepCopyInto(out *FTPPostDelivery) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPPostDelivery.
+func (in *FTPPostDelivery) DeepCopy() *FTPPostDelivery {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPPostDelivery)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPSource) DeepCopyInto(out *FTPSource) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPSource.
+func (in *FTPSource) DeepCopy() *FTPSource {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *FTPSource) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPSourceList) DeepCopyInto(out *FTPSourceList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]FTPSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPSourceList.
+func (in *FTPSourceList) DeepCopy() *FTPSourceList {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPSourceList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *FTPSourceList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPSourceSpec) DeepCopyInto(out *FTPSourceSpec) {
+	*out = *in
+	in.SourceSpec.DeepCopyInto(&out.SourceSpec)
+	in.TLS.DeepCopyInto(&out.TLS)
+	in.Filter.DeepCopyInto(&out.Filter)
+	in.Stability.DeepCopyInto(&out.Stability)
+	in.Credentials.DeepCopyInto(&out.Credentials)
+	in.HostKey.DeepCopyInto(&out.HostKey)
+	out.Events = in.Events
+	in.Checksum.DeepCopyInto(&out.Checksum)
+	out.InlineContent = in.InlineContent
+	in.ClaimCheck.DeepCopyInto(&out.ClaimCheck)
+	if in.Delivery != nil {
+		in, out := &in.Delivery, &out.Delivery
+		*out = new(duckv1.DeliverySpec)
+		(*in).DeepCopyInto(*out)
+	}
+	out.PostDelivery = in.PostDelivery
+	in.State.DeepCopyInto(&out.State)
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPSourceSpec.
+func (in *FTPSourceSpec) DeepCopy() *FTPSourceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPSourceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPSourceStatus) DeepCopyInto(out *FTPSourceStatus) {
+	*out = *in
+	in.SourceStatus.DeepCopyInto(&out.SourceStatus)
+	if in.DeadLetterSinkURI != nil {
+		in, out := &in.DeadLetterSinkURI, &out.DeadLetterSinkURI
+		*out = new(apis.URL)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPSourceStatus.
+func (in *FTPSourceStatus) DeepCopy() *FTPSourceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPSourceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPStability) DeepCopyInto(out *FTPStability) {
+	*out = *in
+	if in.QuietPeriod != nil {
+		in, out := &in.QuietPeriod, &out.QuietPeriod
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.MarkerSuffixes != nil {
+		in, out := &in.MarkerSuffixes, &out.MarkerSuffixes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPStability.
+func (in *FTPStability) DeepCopy() *FTPStability {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPStability)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPState) DeepCopyInto(out *FTPState) {
+	*out = *in
+	if in.File != nil {
+		in, out := &in.File, &out.File
+		*out = new(FTPStateFile)
+		**out = **in
+	}
+	if in.Redis != nil {
+		in, out := &in.Redis, &out.Redis
+		*out = new(FTPStateRedis)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPState.
+func (in *FTPState) DeepCopy() *FTPState {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPState)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPStateFile) DeepCopyInto(out *FTPStateFile) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPStateFile.
+func (in *FTPStateFile) DeepCopy() *FTPStateFile {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPStateFile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPStateRedis) DeepCopyInto(out *FTPStateRedis) {
+	*out = *in
+	if in.PasswordSecretRef != nil {
+		in, out := &in.PasswordSecretRef, &out.PasswordSecretRef
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPStateRedis.
+func (in *FTPStateRedis) DeepCopy() *FTPStateRedis {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPStateRedis)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FTPTLS) DeepCopyInto(out *FTPTLS) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FTPTLS.
+func (in *FTPTLS) DeepCopy() *FTPTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(FTPTLS)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	"context"
+
+	v1alpha1 "github.com/vaikas/ftp/pkg/apis/sources/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeFTPSources implements FTPSourceInterface
+type FakeFTPSources struct {
+	Fake *FakeSourcesV1alpha1
+	ns   string
+}
+
+var ftpsourcesResource = schema.GroupVersionResource{Group: "sources.knative.dev", Version: "v1alpha1", Resource: "ftpsources"}
+
+var ftpsourcesKind = schema.GroupVersionKind{Group: "sources.knative.dev", Version: "v1alpha1", Kind: "FTPSource"}
+
+// Get takes name of the fTPSource, and returns the corresponding fTPSource object, and an error if there is any.
+func (c *FakeFTPSources) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1alpha1.FTPSource, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewGetAction(ftpsourcesResource, c.ns, name), &v1alpha1.FTPSource{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.FTPSource), err
+}
+
+// List takes label and field selectors, and returns the list of FTPSources that match those selectors.
+func (c *FakeFTPSources) List(ctx context.Context, opts v1.ListOptions) (result *v1alpha1.FTPSourceList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewListAction(ftpsourcesResource, ftpsourcesKind, c.ns, opts), &v1alpha1.FTPSourceList{})
+
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &v1alpha1.FTPSourceList{ListMeta: obj.(*v1alpha1.FTPSourceList).ListMeta}
+	for _, item := range obj.(*v1alpha1.FTPSourceList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested fTPSources.
+func (c *FakeFTPSources) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewWatchAction(ftpsourcesResource, c.ns, opts))
+
+}
+
+// Create takes the representation of a fTPSource and creates it.  Returns the server's representation of the fTPSource, and an error, if there is any.
+func (c *FakeFTPSources) Create(ctx context.Context, fTPSource *v1alpha1.FTPSource, opts v1.CreateOptions) (result *v1alpha1.FTPSource, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewCreateAction(ftpsourcesResource, c.ns, fTPSource), &v1alpha1.FTPSource{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.FTPSource), err
+}
+
+// Update takes the representation of a fTPSource and updates it. Returns the server's representation of the fTPSource, and an error, if there is any.
+func (c *FakeFTPSources) Update(ctx context.Context, fTPSource *v1alpha1.FTPSource, opts v1.UpdateOptions) (result *v1alpha1.FTPSource, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateAction(ftpsourcesResource, c.ns, fTPSource), &v1alpha1.FTPSource{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.FTPSource), err
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeFTPSources) UpdateStatus(ctx context.Context, fTPSource *v1alpha1.FTPSource, opts v1.UpdateOptions) (*v1alpha1.FTPSource, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(ftpsourcesResource, "status", c.ns, fTPSource), &v1alpha1.FTPSource{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.FTPSource), err
+}
+
+// Delete takes name of the fTPSource and deletes it. Returns an error if one occurs.
+func (c *FakeFTPSources) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewDeleteAction(ftpsourcesResource, c.ns, name), &v1alpha1.FTPSource{})
+
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeFTPSources) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	action := testing.NewDeleteCollectionAction(ftpsourcesResource, c.ns, listOpts)
+
+	_, err := c.Fake.Invokes(action, &v1alpha1.FTPSourceList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched fTPSource.
+func (c *FakeFTPSources) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1alpha1.FTPSource, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewPatchSubresourceAction(ftpsourcesResource, c.ns, name, pt, data, subresources...), &v1alpha1.FTPSource{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.FTPSource), err
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	"context"
+	"time"
+
+	v1alpha1 "github.com/vaikas/ftp/pkg/apis/sources/v1alpha1"
+	scheme "github.com/vaikas/ftp/pkg/client/clientset/versioned/scheme"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// FTPSourcesGetter has a method to return a FTPSourceInterface.
+// A group's client should implement this interface.
+type FTPSourcesGetter interface {
+	FTPSources(namespace string) FTPSourceInterface
+}
+
+// FTPSourceInterface has methods to work with FTPSource resources.
+type FTPSourceInterface interface {
+	Create(ctx context.Context, fTPSource *v1alpha1.FTPSource, opts v1.CreateOptions) (*v1alpha1.FTPSource, error)
+	Update(ctx context.Context, fTPSource *v1alpha1.FTPSource, opts v1.UpdateOptions) (*v1alpha1.FTPSource, error)
+	UpdateStatus(ctx context.Context, fTPSource *v1alpha1.FTPSource, opts v1.UpdateOptions) (*v1alpha1.FTPSource, error)
+	Delete(ctx context.Context, name string, opts v1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error
+	Get(ctx context.Context, name string, opts v1.GetOptions) (*v1alpha1.FTPSource, error)
+	List(ctx context.Context, opts v1.ListOptions) (*v1alpha1.FTPSourceList, error)
+	Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1alpha1.FTPSource, err error)
+	FTPSourceExpansion
+}
+
+// fTPSources implements FTPSourceInterface
+type fTPSources struct {
+	client rest.Interface
+	ns     string
+}
+
+// newFTPSources returns a FTPSources
+func newFTPSources(c *SourcesV1alpha1Client, namespace string) *fTPSources {
+	return &fTPSources{
+		client: c.RESTClient(),
+		ns:     namespace,
+	}
+}
+
+// Get takes name of the fTPSource, and returns the corresponding fTPSource object, and an error if there is any.
+func (c *fTPSources) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1alpha1.FTPSource, err error) {
+	result = &v1alpha1.FTPSource{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("ftpsources").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of FTPSources that match those selectors.
+func (c *fTPSources) List(ctx context.Context, opts v1.ListOptions) (result *v1alpha1.FTPSourceList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result = &v1alpha1.FTPSourceList{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("ftpsources").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested fTPSources.
+func (c *fTPSources) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.client.Get().
+		Namespace(c.ns).
+		Resource("ftpsources").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Watch(ctx)
+}
+
+// Create takes the representation of a fTPSource and creates it.  Returns the server's representation of the fTPSource, and an error, if there is any.
+func (c *fTPSources) Create(ctx context.Context, fTPSource *v1alpha1.FTPSource, opts v1.CreateOptions) (result *v1alpha1.FTPSource, err error) {
+	result = &v1alpha1.FTPSource{}
+	err = c.client.Post().
+		Namespace(c.ns).
+		Resource("ftpsources").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(fTPSource).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Update takes the representation of a fTPSource and updates it. Returns the server's representation of the fTPSource, and an error, if there is any.
+func (c *fTPSources) Update(ctx context.Context, fTPSource *v1alpha1.FTPSource, opts v1.UpdateOptions) (result *v1alpha1.FTPSource, err error) {
+	result = &v1alpha1.FTPSource{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("ftpsources").
+		Name(fTPSource.Name).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(fTPSource).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *fTPSources) UpdateStatus(ctx context.Context, fTPSource *v1alpha1.FTPSource, opts v1.UpdateOptions) (result *v1alpha1.FTPSource, err error) {
+	result = &v1alpha1.FTPSource{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("ftpsources").
+		Name(fTPSource.Name).
+		SubResource("status").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(fTPSource).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Delete takes name of the fTPSource and deletes it. Returns an error if one occurs.
+func (c *fTPSources) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("ftpsources").
+		Name(name).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *fTPSources) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	var timeout time.Duration
+	if listOpts.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOpts.TimeoutSeconds) * time.Second
+	}
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("ftpsources").
+		VersionedParams(&listOpts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// Patch applies the patch and returns the patched fTPSource.
+func (c *fTPSources) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1alpha1.FTPSource, err error) {
+	result = &v1alpha1.FTPSource{}
+	err = c.client.Patch(pt).
+		Namespace(c.ns).
+		Resource("ftpsources").
+		Name(name).
+		SubResource(subresources...).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(data).
+		Do(ctx).
+		Into(result)
+	return
+}
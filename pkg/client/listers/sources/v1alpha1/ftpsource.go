@@ -0,0 +1,99 @@
+/*
+Copyright 2021 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	v1alpha1 "github.com/vaikas/ftp/pkg/apis/sources/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// FTPSourceLister helps list FTPSources.
+// All objects returned here must be treated as read-only.
+type FTPSourceLister interface {
+	// List lists all FTPSources in the indexer.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*v1alpha1.FTPSource, err error)
+	// FTPSources returns an object that can list and get FTPSources.
+	FTPSources(namespace string) FTPSourceNamespaceLister
+	FTPSourceListerExpansion
+}
+
+// fTPSourceLister implements the FTPSourceLister interface.
+type fTPSourceLister struct {
+	indexer cache.Indexer
+}
+
+// NewFTPSourceLister returns a new FTPSourceLister.
+func NewFTPSourceLister(indexer cache.Indexer) FTPSourceLister {
+	return &fTPSourceLister{indexer: indexer}
+}
+
+// List lists all FTPSources in the indexer.
+func (s *fTPSourceLister) List(selector labels.Selector) (ret []*v1alpha1.FTPSource, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.FTPSource))
+	})
+	return ret, err
+}
+
+// FTPSources returns an object that can list and get FTPSources.
+func (s *fTPSourceLister) FTPSources(namespace string) FTPSourceNamespaceLister {
+	return fTPSourceNamespaceLister{indexer: s.indexer, namespace: namespace}
+}
+
+// FTPSourceNamespaceLister helps list and get FTPSources.
+// All objects returned here must be treated as read-only.
+type FTPSourceNamespaceLister interface {
+	// List lists all FTPSources in the indexer for a given namespace.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*v1alpha1.FTPSource, err error)
+	// Get retrieves the FTPSource from the indexer for a given namespace and name.
+	// Objects returned here must be treated as read-only.
+	Get(name string) (*v1alpha1.FTPSource, error)
+	FTPSourceNamespaceListerExpansion
+}
+
+// fTPSourceNamespaceLister implements the FTPSourceNamespaceLister
+// interface.
+type fTPSourceNamespaceLister struct {
+	indexer   cache.Indexer
+	namespace string
+}
+
+// List lists all FTPSources in the indexer for a given namespace.
+func (s fTPSourceNamespaceLister) List(selector labels.Selector) (ret []*v1alpha1.FTPSource, err error) {
+	err = cache.ListAllByNamespace(s.indexer, s.namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.FTPSource))
+	})
+	return ret, err
+}
+
+// Get retrieves the FTPSource from the indexer for a given namespace and name.
+func (s fTPSourceNamespaceLister) Get(name string) (*v1alpha1.FTPSource, error) {
+	obj, exists, err := s.indexer.GetByKey(s.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1alpha1.Resource("ftpsource"), name)
+	}
+	return obj.(*v1alpha1.FTPSource), nil
+}
@@ -0,0 +1,109 @@
+package ftpsource
+
+import (
+	"context"
+
+	"github.com/kelseyhightower/envconfig"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/client/injection/ducks/duck/v1/source"
+	kubeclient "knative.dev/pkg/client/injection/kube/client"
+	deploymentinformer "knative.dev/pkg/client/injection/kube/informers/apps/v1/deployment"
+	configmapinformer "knative.dev/pkg/client/injection/kube/informers/core/v1/configmap"
+	serviceaccountinformer "knative.dev/pkg/client/injection/kube/informers/core/v1/serviceaccount"
+	roleinformer "knative.dev/pkg/client/injection/kube/informers/rbac/v1/role"
+	rolebindinginformer "knative.dev/pkg/client/injection/kube/informers/rbac/v1/rolebinding"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/injection/clients/dynamicclient"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/metrics"
+	"knative.dev/pkg/resolver"
+	tracingconfig "knative.dev/pkg/tracing/config"
+
+	"github.com/vaikas/ftp/pkg/apis/sources/v1alpha1"
+	ftpsourceinformer "github.com/vaikas/ftp/pkg/client/injection/informers/sources/v1alpha1/ftpsource"
+	ftpsourcereconciler "github.com/vaikas/ftp/pkg/client/injection/reconciler/sources/v1alpha1/ftpsource"
+	"github.com/vaikas/ftp/pkg/reconciler/ftpsource/resources"
+)
+
+type envConfig struct {
+	// Image is the receive adapter image the FTPSource Deployments run.
+	Image string `envconfig:"FTP_SOURCE_RA_IMAGE" required:"true"`
+}
+
+// NewController initializes the controller and is called by the generated code.
+// Registers event handlers to enqueue events.
+func NewController(
+	ctx context.Context,
+	cmw configmap.Watcher,
+) *controller.Impl {
+	logger := logging.FromContext(ctx)
+
+	env := &envConfig{}
+	if err := envconfig.Process("", env); err != nil {
+		logger.Fatalw("Unable to process FTPSource's required environment variables", "error", err)
+	}
+
+	ftpSourceInformer := ftpsourceinformer.Get(ctx)
+	deploymentInformer := deploymentinformer.Get(ctx)
+	configMapInformer := configmapinformer.Get(ctx)
+	serviceAccountInformer := serviceaccountinformer.Get(ctx)
+	roleInformer := roleinformer.Get(ctx)
+	roleBindingInformer := rolebindinginformer.Get(ctx)
+
+	r := &Reconciler{
+		kubeClientSet:        kubeclient.Get(ctx),
+		dynamicClientSet:     dynamicclient.Get(ctx),
+		deploymentLister:     deploymentInformer.Lister(),
+		configMapLister:      configMapInformer.Lister(),
+		serviceAccountLister: serviceAccountInformer.Lister(),
+		roleLister:           roleInformer.Lister(),
+		roleBindingLister:    roleBindingInformer.Lister(),
+		receiveAdapterImage:  env.Image,
+		observability:        &observability{},
+	}
+	impl := ftpsourcereconciler.NewImpl(ctx, r)
+	r.sinkResolver = resolver.NewURIResolver(ctx, impl.EnqueueKey)
+
+	sinkBindingInformer, sinkBindingLister, err := source.Get(ctx).Get(ctx, resources.SinkBindingGVR)
+	if err != nil {
+		logger.Fatalw("Unable to get a SinkBinding informer", "error", err)
+	}
+	r.sinkBindingLister = sinkBindingLister
+
+	// The receive adapters are updated when the observability configuration
+	// changes.
+	cmw.Watch(metrics.ConfigMapName(), func(cm *corev1.ConfigMap) {
+		if err := r.observability.updateMetrics(cm); err != nil {
+			logger.Error("Failed to update the metrics configuration of the receive adapters:", zap.Error(err))
+			return
+		}
+		impl.GlobalResync(ftpSourceInformer.Informer())
+	})
+	cmw.Watch(tracingconfig.ConfigName, func(cm *corev1.ConfigMap) {
+		if err := r.observability.updateTracing(cm); err != nil {
+			logger.Error("Failed to update the tracing configuration of the receive adapters:", zap.Error(err))
+			return
+		}
+		impl.GlobalResync(ftpSourceInformer.Informer())
+	})
+
+	logger.Info("Setting up event handlers")
+
+	ftpSourceInformer.Informer().AddEventHandler(controller.HandleAll(impl.Enqueue))
+
+	handleOwned := cache.FilteringResourceEventHandler{
+		FilterFunc: controller.FilterControllerGK(v1alpha1.Kind("FTPSource")),
+		Handler:    controller.HandleAll(impl.EnqueueControllerOf),
+	}
+	deploymentInformer.Informer().AddEventHandler(handleOwned)
+	configMapInformer.Informer().AddEventHandler(handleOwned)
+	serviceAccountInformer.Informer().AddEventHandler(handleOwned)
+	roleInformer.Informer().AddEventHandler(handleOwned)
+	roleBindingInformer.Informer().AddEventHandler(handleOwned)
+	sinkBindingInformer.AddEventHandler(handleOwned)
+
+	return impl
+}
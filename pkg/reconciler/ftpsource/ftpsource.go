@@ -0,0 +1,278 @@
+package ftpsource
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	appsv1listers "k8s.io/client-go/listers/apps/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	rbacv1listers "k8s.io/client-go/listers/rbac/v1"
+	"k8s.io/client-go/tools/cache"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/controller"
+	"knative.dev/pkg/logging"
+	pkgreconciler "knative.dev/pkg/reconciler"
+	"knative.dev/pkg/resolver"
+
+	"github.com/vaikas/ftp/pkg/apis/sources/v1alpha1"
+	ftpsourcereconciler "github.com/vaikas/ftp/pkg/client/injection/reconciler/sources/v1alpha1/ftpsource"
+	"github.com/vaikas/ftp/pkg/reconciler/ftpsource/resources"
+)
+
+// Reconciler reconciles an FTPSource object
+type Reconciler struct {
+	kubeClientSet    kubernetes.Interface
+	dynamicClientSet dynamic.Interface
+
+	deploymentLister     appsv1listers.DeploymentLister
+	configMapLister      corev1listers.ConfigMapLister
+	serviceAccountLister corev1listers.ServiceAccountLister
+	roleLister           rbacv1listers.RoleLister
+	roleBindingLister    rbacv1listers.RoleBindingLister
+	sinkBindingLister    cache.GenericLister
+
+	// Resolves the dead letter sink.
+	sinkResolver *resolver.URIResolver
+
+	receiveAdapterImage string
+
+	// Observability configuration handed on to the receive adapters.
+	observability *observability
+}
+
+// Check that our Reconciler implements Interface
+var _ ftpsourcereconciler.Interface = (*Reconciler)(nil)
+
+// ReconcileKind implements Interface.ReconcileKind.
+func (r *Reconciler) ReconcileKind(ctx context.Context, src *v1alpha1.FTPSource) pkgreconciler.Event {
+	if err := r.reconcileRBAC(ctx, src); err != nil {
+		return err
+	}
+
+	if err := r.reconcileStateConfigMap(ctx, src); err != nil {
+		return err
+	}
+
+	if err := r.resolveDeadLetterSink(ctx, src); err != nil {
+		return err
+	}
+
+	ra, err := r.reconcileReceiveAdapter(ctx, src)
+	if err != nil {
+		return err
+	}
+	src.Status.PropagateDeploymentAvailability(ra)
+
+	sb, err := r.reconcileSinkBinding(ctx, src, ra)
+	if err != nil {
+		return err
+	}
+	if sb == nil || sb.Status.SinkURI == nil {
+		src.Status.MarkNoSink("SinkNotResolved", "The sink has not been resolved yet")
+		return nil
+	}
+	src.Status.MarkSink(sb.Status.SinkURI)
+	return nil
+}
+
+func (r *Reconciler) reconcileRBAC(ctx context.Context, src *v1alpha1.FTPSource) error {
+	recorder := controller.GetEventRecorder(ctx)
+
+	expectedSA := resources.MakeServiceAccount(src)
+	if _, err := r.serviceAccountLister.ServiceAccounts(src.Namespace).Get(expectedSA.Name); apierrors.IsNotFound(err) {
+		if _, err := r.kubeClientSet.CoreV1().ServiceAccounts(src.Namespace).Create(ctx, expectedSA, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("creating service account: %w", err)
+		}
+		recorder.Eventf(src, corev1.EventTypeNormal, "ServiceAccountCreated", "Created ServiceAccount %q", expectedSA.Name)
+	} else if err != nil {
+		return fmt.Errorf("getting service account: %w", err)
+	}
+
+	expectedRole := resources.MakeRole(src)
+	role, err := r.roleLister.Roles(src.Namespace).Get(expectedRole.Name)
+	if apierrors.IsNotFound(err) {
+		if _, err := r.kubeClientSet.RbacV1().Roles(src.Namespace).Create(ctx, expectedRole, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("creating role: %w", err)
+		}
+		recorder.Eventf(src, corev1.EventTypeNormal, "RoleCreated", "Created Role %q", expectedRole.Name)
+	} else if err != nil {
+		return fmt.Errorf("getting role: %w", err)
+	} else if !equality.Semantic.DeepEqual(role.Rules, expectedRole.Rules) {
+		role = role.DeepCopy()
+		role.Rules = expectedRole.Rules
+		if _, err := r.kubeClientSet.RbacV1().Roles(src.Namespace).Update(ctx, role, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("updating role: %w", err)
+		}
+	}
+
+	expectedRB := resources.MakeRoleBinding(src)
+	if _, err := r.roleBindingLister.RoleBindings(src.Namespace).Get(expectedRB.Name); apierrors.IsNotFound(err) {
+		if _, err := r.kubeClientSet.RbacV1().RoleBindings(src.Namespace).Create(ctx, expectedRB, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("creating role binding: %w", err)
+		}
+		recorder.Eventf(src, corev1.EventTypeNormal, "RoleBindingCreated", "Created RoleBinding %q", expectedRB.Name)
+	} else if err != nil {
+		return fmt.Errorf("getting role binding: %w", err)
+	}
+	return nil
+}
+
+// reconcileStateConfigMap makes sure the ConfigMap the receive adapter keeps
+// its state in exists, unless the state is kept elsewhere. The contents are
+// owned by the receive adapter, so an existing ConfigMap is never updated.
+func (r *Reconciler) reconcileStateConfigMap(ctx context.Context, src *v1alpha1.FTPSource) error {
+	if b := src.Spec.State.Backend; b != "" && b != v1alpha1.StateBackendConfigMap {
+		return nil
+	}
+	expected := resources.MakeStateConfigMap(src)
+	_, err := r.configMapLister.ConfigMaps(src.Namespace).Get(expected.Name)
+	if apierrors.IsNotFound(err) {
+		if _, err := r.kubeClientSet.CoreV1().ConfigMaps(src.Namespace).Create(ctx, expected, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("creating state configmap: %w", err)
+		}
+		controller.GetEventRecorder(ctx).Eventf(src, corev1.EventTypeNormal, "ConfigMapCreated", "Created ConfigMap %q", expected.Name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("getting state configmap: %w", err)
+	}
+	return nil
+}
+
+// resolveDeadLetterSink resolves the URI of the dead letter sink, if there is
+// one, into the status.
+func (r *Reconciler) resolveDeadLetterSink(ctx context.Context, src *v1alpha1.FTPSource) error {
+	if src.Spec.Delivery == nil || src.Spec.Delivery.DeadLetterSink == nil {
+		src.Status.DeadLetterSinkURI = nil
+		return nil
+	}
+	uri, err := r.sinkResolver.URIFromDestinationV1(ctx, *src.Spec.Delivery.DeadLetterSink, src)
+	if err != nil {
+		src.Status.DeadLetterSinkURI = nil
+		controller.GetEventRecorder(ctx).Eventf(src, corev1.EventTypeWarning, "DeadLetterSinkNotResolved", "Failed to resolve the dead letter sink: %v", err)
+		return fmt.Errorf("resolving dead letter sink: %w", err)
+	}
+	src.Status.DeadLetterSinkURI = uri
+	return nil
+}
+
+func (r *Reconciler) reconcileReceiveAdapter(ctx context.Context, src *v1alpha1.FTPSource) (*appsv1.Deployment, error) {
+	expected := resources.MakeReceiveAdapter(&resources.ReceiveAdapterArgs{
+		Image:          r.receiveAdapterImage,
+		Source:         src,
+		Labels:         resources.Labels(src.Name),
+		DeadLetterSink: src.Status.DeadLetterSinkURI,
+		MetricsConfig:  r.observability.metricsJSON(),
+		TracingConfig:  r.observability.tracingJSON(),
+	})
+
+	ra, err := r.deploymentLister.Deployments(src.Namespace).Get(expected.Name)
+	if apierrors.IsNotFound(err) {
+		ra, err = r.kubeClientSet.AppsV1().Deployments(src.Namespace).Create(ctx, expected, metav1.CreateOptions{})
+		if err != nil {
+			src.Status.MarkNoDeployment("DeploymentCreateFailed", "Failed to create the receive adapter Deployment: %v", err)
+			return nil, fmt.Errorf("creating receive adapter: %w", err)
+		}
+		controller.GetEventRecorder(ctx).Eventf(src, corev1.EventTypeNormal, "DeploymentCreated", "Created Deployment %q", ra.Name)
+		return ra, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting receive adapter: %w", err)
+	}
+	if !metav1.IsControlledBy(ra, src) {
+		src.Status.MarkNoDeployment("DeploymentNotOwned", "Deployment %q is not owned by FTPSource %q", ra.Name, src.Name)
+		return nil, fmt.Errorf("deployment %q is not owned by FTPSource %q", ra.Name, src.Name)
+	}
+	if !equality.Semantic.DeepDerivative(expected.Spec, ra.Spec) {
+		ra = ra.DeepCopy()
+		ra.Spec = expected.Spec
+		if ra, err = r.kubeClientSet.AppsV1().Deployments(src.Namespace).Update(ctx, ra, metav1.UpdateOptions{}); err != nil {
+			return nil, fmt.Errorf("updating receive adapter: %w", err)
+		}
+		controller.GetEventRecorder(ctx).Eventf(src, corev1.EventTypeNormal, "DeploymentUpdated", "Updated Deployment %q", ra.Name)
+	}
+	return ra, nil
+}
+
+// reconcileSinkBinding makes sure the SinkBinding injecting the sink into
+// the receive adapter is up to date, and returns its last observed status.
+// The returned Source is nil if the SinkBinding has not been observed yet.
+func (r *Reconciler) reconcileSinkBinding(ctx context.Context, src *v1alpha1.FTPSource, ra *appsv1.Deployment) (*duckv1.Source, error) {
+	expected, err := resources.MakeSinkBinding(src, ra)
+	if err != nil {
+		return nil, fmt.Errorf("making sink binding: %w", err)
+	}
+	client := r.dynamicClientSet.Resource(resources.SinkBindingGVR).Namespace(src.Namespace)
+
+	existing, err := client.Get(ctx, expected.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := client.Create(ctx, expected, metav1.CreateOptions{}); err != nil {
+			src.Status.MarkNoSink("SinkBindingCreateFailed", "Failed to create the SinkBinding: %v", err)
+			return nil, fmt.Errorf("creating sink binding: %w", err)
+		}
+		controller.GetEventRecorder(ctx).Eventf(src, corev1.EventTypeNormal, "SinkBindingCreated", "Created SinkBinding %q", expected.GetName())
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting sink binding: %w", err)
+	}
+	want, _, _ := unstructured.NestedMap(expected.Object, "spec")
+	got, _, _ := unstructured.NestedMap(existing.Object, "spec")
+	if sinkBindingSpecChanged(want, got) {
+		existing = existing.DeepCopy()
+		if got == nil {
+			got = map[string]interface{}{}
+		}
+		for _, f := range sinkBindingSpecFields {
+			if v, ok := want[f]; ok {
+				got[f] = v
+			} else {
+				delete(got, f)
+			}
+		}
+		if err := unstructured.SetNestedMap(existing.Object, got, "spec"); err != nil {
+			return nil, fmt.Errorf("updating sink binding: %w", err)
+		}
+		if _, err := client.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+			return nil, fmt.Errorf("updating sink binding: %w", err)
+		}
+		logging.FromContext(ctx).Info("Updated SinkBinding", zap.String("name", existing.GetName()))
+	}
+
+	obj, err := r.sinkBindingLister.ByNamespace(src.Namespace).Get(expected.GetName())
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting sink binding status: %w", err)
+	}
+	return obj.(*duckv1.Source), nil
+}
+
+// sinkBindingSpecFields are the fields of the SinkBinding spec the FTPSource
+// owns. The webhook of the SinkBinding defaults others, and fills in parts of
+// these, which are left alone.
+var sinkBindingSpecFields = []string{"sink", "ceOverrides", "subject"}
+
+// sinkBindingSpecChanged returns whether the SinkBinding spec got differs
+// from the one wanted in the fields the FTPSource owns, ignoring what was
+// defaulted.
+func sinkBindingSpecChanged(want, got map[string]interface{}) bool {
+	for _, f := range sinkBindingSpecFields {
+		w, ok := want[f]
+		g, has := got[f]
+		if ok != has || ok && !equality.Semantic.DeepDerivative(w, g) {
+			return true
+		}
+	}
+	return false
+}
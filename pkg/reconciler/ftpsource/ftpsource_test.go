@@ -0,0 +1,458 @@
+package ftpsource
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	appsv1listers "k8s.io/client-go/listers/apps/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	rbacv1listers "k8s.io/client-go/listers/rbac/v1"
+	clientgotesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/apis"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	fakekubeclient "knative.dev/pkg/client/injection/kube/client/fake"
+	"knative.dev/pkg/controller"
+	fakedynamicclient "knative.dev/pkg/injection/clients/dynamicclient/fake"
+	"knative.dev/pkg/logging"
+	logtesting "knative.dev/pkg/logging/testing"
+	pkgreconciler "knative.dev/pkg/reconciler"
+	. "knative.dev/pkg/reconciler/testing"
+
+	"github.com/vaikas/ftp/pkg/apis/sources/v1alpha1"
+	fakeftpclient "github.com/vaikas/ftp/pkg/client/injection/client/fake"
+	ftpsourcereconciler "github.com/vaikas/ftp/pkg/client/injection/reconciler/sources/v1alpha1/ftpsource"
+	listers "github.com/vaikas/ftp/pkg/client/listers/sources/v1alpha1"
+	"github.com/vaikas/ftp/pkg/reconciler/ftpsource/resources"
+)
+
+const (
+	testNS    = "test-namespace"
+	testName  = "test-source"
+	testImage = "example.com/ftpsource-receive-adapter"
+)
+
+var (
+	testKey  = testNS + "/" + testName
+	testSink = duckv1.Destination{
+		Ref: &duckv1.KReference{APIVersion: "serving.knative.dev/v1", Kind: "Service", Name: "sink"},
+	}
+	otherSink = duckv1.Destination{
+		Ref: &duckv1.KReference{APIVersion: "serving.knative.dev/v1", Kind: "Service", Name: "other"},
+	}
+	sinkURI = apis.HTTP("sink.test-namespace.svc.cluster.local")
+)
+
+type sourceOption func(*v1alpha1.FTPSource)
+
+func newSource(opts ...sourceOption) *v1alpha1.FTPSource {
+	src := &v1alpha1.FTPSource{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:  testNS,
+			Name:       testName,
+			UID:        "test-uid",
+			Generation: 1,
+		},
+		Spec: v1alpha1.FTPSourceSpec{
+			SourceSpec: duckv1.SourceSpec{Sink: testSink},
+			Server:     "ftp.example.com",
+			Directory:  "incoming",
+		},
+	}
+	for _, opt := range opts {
+		opt(src)
+	}
+	return src
+}
+
+func withRedisState(src *v1alpha1.FTPSource) {
+	src.Spec.State = v1alpha1.FTPState{
+		Backend: v1alpha1.StateBackendRedis,
+		Redis:   &v1alpha1.FTPStateRedis{Address: "redis:6379"},
+	}
+}
+
+func withInitConditions(src *v1alpha1.FTPSource) {
+	src.Status.InitializeConditions()
+	src.Status.ObservedGeneration = src.Generation
+}
+
+func withDeploymentUnavailable(src *v1alpha1.FTPSource) {
+	src.Status.PropagateDeploymentAvailability(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: resources.DeploymentName(src)},
+	})
+}
+
+func withDeploymentAvailable(src *v1alpha1.FTPSource) {
+	src.Status.PropagateDeploymentAvailability(availableDeployment(newDeployment(src)))
+}
+
+func withNoDeployment(reason, format string, args ...interface{}) sourceOption {
+	return func(src *v1alpha1.FTPSource) {
+		src.Status.MarkNoDeployment(reason, format, args...)
+	}
+}
+
+func withSinkNotResolved(src *v1alpha1.FTPSource) {
+	src.Status.MarkNoSink("SinkNotResolved", "The sink has not been resolved yet")
+}
+
+func withSink(src *v1alpha1.FTPSource) {
+	src.Status.MarkSink(sinkURI)
+}
+
+func newDeployment(src *v1alpha1.FTPSource) *appsv1.Deployment {
+	return resources.MakeReceiveAdapter(&resources.ReceiveAdapterArgs{
+		Image:  testImage,
+		Source: src,
+		Labels: resources.Labels(src.Name),
+	})
+}
+
+func availableDeployment(d *appsv1.Deployment) *appsv1.Deployment {
+	d.Status.Conditions = []appsv1.DeploymentCondition{{
+		Type:   appsv1.DeploymentAvailable,
+		Status: corev1.ConditionTrue,
+	}}
+	return d
+}
+
+func newSinkBinding(t *testing.T, src *v1alpha1.FTPSource) *unstructured.Unstructured {
+	t.Helper()
+	sb, err := resources.MakeSinkBinding(src, newDeployment(src))
+	if err != nil {
+		t.Fatal("MakeSinkBinding() =", err)
+	}
+	return sb
+}
+
+// defaultedSinkBinding is the SinkBinding of the source the way the webhook
+// of the SinkBinding stores it: with the namespaces filled in and the sink
+// resolved in the status.
+func defaultedSinkBinding(t *testing.T, src *v1alpha1.FTPSource) *unstructured.Unstructured {
+	t.Helper()
+	sb := newSinkBinding(t, src)
+	if err := unstructured.SetNestedField(sb.Object, testNS, "spec", "sink", "ref", "namespace"); err != nil {
+		t.Fatal(err)
+	}
+	if err := unstructured.SetNestedField(sb.Object, sinkURI.String(), "status", "sinkUri"); err != nil {
+		t.Fatal(err)
+	}
+	return sb
+}
+
+func roleWithoutLeases(src *v1alpha1.FTPSource) *rbacv1.Role {
+	role := resources.MakeRole(src)
+	role.Rules = role.Rules[:1]
+	return role
+}
+
+func foreignDeployment(src *v1alpha1.FTPSource) *appsv1.Deployment {
+	d := newDeployment(src)
+	d.OwnerReferences = nil
+	return d
+}
+
+func existing(t *testing.T, src *v1alpha1.FTPSource) []runtime.Object {
+	return []runtime.Object{
+		resources.MakeServiceAccount(src),
+		resources.MakeRole(src),
+		resources.MakeRoleBinding(src),
+		resources.MakeStateConfigMap(src),
+		availableDeployment(newDeployment(src)),
+		defaultedSinkBinding(t, src),
+	}
+}
+
+func createdEvents(src *v1alpha1.FTPSource) []string {
+	name := resources.DeploymentName(src)
+	return []string{
+		Eventf(corev1.EventTypeNormal, "ServiceAccountCreated", "Created ServiceAccount %q", name),
+		Eventf(corev1.EventTypeNormal, "RoleCreated", "Created Role %q", name),
+		Eventf(corev1.EventTypeNormal, "RoleBindingCreated", "Created RoleBinding %q", name),
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	src := newSource()
+	redisSrc := newSource(withRedisState)
+	name := resources.DeploymentName(src)
+
+	// Updates put back the fields the source owns as they are rendered, and
+	// leave the webhook to default them again.
+	restoredSinkBinding := defaultedSinkBinding(t, src)
+	unstructured.RemoveNestedField(restoredSinkBinding.Object, "spec", "sink", "ref", "namespace")
+	updatedSinkBinding := restoredSinkBinding.DeepCopy()
+	if err := unstructured.SetNestedField(updatedSinkBinding.Object, "other", "spec", "sink", "ref", "name"); err != nil {
+		t.Fatal(err)
+	}
+
+	table := TableTest{{
+		Name: "bad workqueue key",
+		Key:  "too/many/parts",
+	}, {
+		Name: "key not found",
+		Key:  "foo/not-found",
+	}, {
+		Name:    "creates everything",
+		Objects: []runtime.Object{newSource()},
+		Key:     testKey,
+		WantCreates: []runtime.Object{
+			resources.MakeServiceAccount(src),
+			resources.MakeRole(src),
+			resources.MakeRoleBinding(src),
+			resources.MakeStateConfigMap(src),
+			newDeployment(src),
+			newSinkBinding(t, src),
+		},
+		WantEvents: append(createdEvents(src),
+			Eventf(corev1.EventTypeNormal, "ConfigMapCreated", "Created ConfigMap %q", resources.StoreName(src)),
+			Eventf(corev1.EventTypeNormal, "DeploymentCreated", "Created Deployment %q", name),
+			Eventf(corev1.EventTypeNormal, "SinkBindingCreated", "Created SinkBinding %q", name),
+		),
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: newSource(withInitConditions, withDeploymentUnavailable, withSinkNotResolved),
+		}},
+	}, {
+		Name:    "no state configmap without the configmap backend",
+		Objects: []runtime.Object{newSource(withRedisState)},
+		Key:     testKey,
+		WantCreates: []runtime.Object{
+			resources.MakeServiceAccount(redisSrc),
+			resources.MakeRole(redisSrc),
+			resources.MakeRoleBinding(redisSrc),
+			newDeployment(redisSrc),
+			newSinkBinding(t, redisSrc),
+		},
+		WantEvents: append(createdEvents(redisSrc),
+			Eventf(corev1.EventTypeNormal, "DeploymentCreated", "Created Deployment %q", name),
+			Eventf(corev1.EventTypeNormal, "SinkBindingCreated", "Created SinkBinding %q", name),
+		),
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: newSource(withRedisState, withInitConditions, withDeploymentUnavailable, withSinkNotResolved),
+		}},
+	}, {
+		Name: "up to date",
+		Objects: append(existing(t, src),
+			newSource(withInitConditions, withDeploymentAvailable, withSink)),
+		Key: testKey,
+	}, {
+		Name: "becomes ready",
+		Objects: append(existing(t, src),
+			newSource(withInitConditions, withDeploymentUnavailable, withSinkNotResolved)),
+		Key: testKey,
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: newSource(withInitConditions, withDeploymentAvailable, withSink),
+		}},
+	}, {
+		Name: "sink changed",
+		Objects: append(existing(t, src),
+			newSource(withInitConditions, withDeploymentAvailable, withSink, func(src *v1alpha1.FTPSource) {
+				src.Spec.Sink = otherSink
+			})),
+		Key: testKey,
+		WantUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: updatedSinkBinding,
+		}},
+	}, {
+		Name: "sink binding spec taken over",
+		Objects: []runtime.Object{
+			resources.MakeServiceAccount(src),
+			resources.MakeRole(src),
+			resources.MakeRoleBinding(src),
+			resources.MakeStateConfigMap(src),
+			availableDeployment(newDeployment(src)),
+			func() runtime.Object {
+				sb := defaultedSinkBinding(t, src)
+				unstructured.RemoveNestedField(sb.Object, "spec", "subject")
+				if err := unstructured.SetNestedField(sb.Object, "cloudevents", "spec", "ceOverrides", "extensions", "added"); err != nil {
+					t.Fatal(err)
+				}
+				return sb
+			}(),
+			newSource(withInitConditions, withDeploymentAvailable, withSink),
+		},
+		Key: testKey,
+		WantUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: restoredSinkBinding,
+		}},
+	}, {
+		Name: "deployment changed",
+		Objects: []runtime.Object{
+			resources.MakeServiceAccount(src),
+			resources.MakeRole(src),
+			resources.MakeRoleBinding(src),
+			resources.MakeStateConfigMap(src),
+			availableDeployment(newDeployment(newSource(func(src *v1alpha1.FTPSource) {
+				src.Spec.Directory = "outgoing"
+			}))),
+			defaultedSinkBinding(t, src),
+			newSource(withInitConditions, withDeploymentAvailable, withSink),
+		},
+		Key: testKey,
+		WantUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: availableDeployment(newDeployment(src)),
+		}},
+		WantEvents: []string{
+			Eventf(corev1.EventTypeNormal, "DeploymentUpdated", "Updated Deployment %q", name),
+		},
+	}, {
+		Name: "role rules changed",
+		Objects: []runtime.Object{
+			resources.MakeServiceAccount(src),
+			roleWithoutLeases(src),
+			resources.MakeRoleBinding(src),
+			resources.MakeStateConfigMap(src),
+			availableDeployment(newDeployment(src)),
+			defaultedSinkBinding(t, src),
+			newSource(withInitConditions, withDeploymentAvailable, withSink),
+		},
+		Key: testKey,
+		WantUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: resources.MakeRole(src),
+		}},
+	}, {
+		Name: "deployment not owned",
+		Objects: []runtime.Object{
+			resources.MakeServiceAccount(src),
+			resources.MakeRole(src),
+			resources.MakeRoleBinding(src),
+			resources.MakeStateConfigMap(src),
+			foreignDeployment(src),
+			newSource(withInitConditions, withDeploymentAvailable, withSink),
+		},
+		Key:     testKey,
+		WantErr: true,
+		WantEvents: []string{
+			Eventf(corev1.EventTypeWarning, "InternalError", "deployment %q is not owned by FTPSource %q", name, testName),
+		},
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: newSource(withInitConditions, withSink,
+				withNoDeployment("DeploymentNotOwned", "Deployment %q is not owned by FTPSource %q", name, testName)),
+		}},
+	}, {
+		Name: "deployment create fails",
+		Objects: []runtime.Object{
+			resources.MakeServiceAccount(src),
+			resources.MakeRole(src),
+			resources.MakeRoleBinding(src),
+			resources.MakeStateConfigMap(src),
+			newSource(),
+		},
+		Key:     testKey,
+		WantErr: true,
+		WithReactors: []clientgotesting.ReactionFunc{
+			InduceFailure("create", "deployments"),
+		},
+		WantCreates: []runtime.Object{
+			newDeployment(src),
+		},
+		WantEvents: []string{
+			Eventf(corev1.EventTypeWarning, "InternalError", "creating receive adapter: inducing failure for create deployments"),
+		},
+		WantStatusUpdates: []clientgotesting.UpdateActionImpl{{
+			Object: newSource(withInitConditions,
+				withNoDeployment("DeploymentCreateFailed", "Failed to create the receive adapter Deployment: %v",
+					fmt.Errorf("inducing failure for create deployments"))),
+		}},
+	}}
+
+	table.Test(t, makeFactory)
+}
+
+// makeFactory sets up the Reconciler with fake clients and listers holding
+// the objects of the row. SinkBindings are given as Unstructured, and listed
+// as the Source duck type.
+func makeFactory(t *testing.T, r *TableRow) (controller.Reconciler, ActionRecorderList, EventList) {
+	ctx := r.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = logging.WithLogger(ctx, logtesting.TestLogger(t))
+
+	indexer := func() cache.Indexer {
+		return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	}
+	sources, deployments, configMaps := indexer(), indexer(), indexer()
+	serviceAccounts, roles, roleBindings, sinkBindings := indexer(), indexer(), indexer(), indexer()
+
+	var kubeObjs, ftpObjs, dynamicObjs []runtime.Object
+	for _, obj := range r.Objects {
+		var err error
+		switch o := obj.(type) {
+		case *v1alpha1.FTPSource:
+			ftpObjs = append(ftpObjs, o)
+			err = sources.Add(o)
+		case *appsv1.Deployment:
+			kubeObjs = append(kubeObjs, o)
+			err = deployments.Add(o)
+		case *corev1.ConfigMap:
+			kubeObjs = append(kubeObjs, o)
+			err = configMaps.Add(o)
+		case *corev1.ServiceAccount:
+			kubeObjs = append(kubeObjs, o)
+			err = serviceAccounts.Add(o)
+		case *rbacv1.Role:
+			kubeObjs = append(kubeObjs, o)
+			err = roles.Add(o)
+		case *rbacv1.RoleBinding:
+			kubeObjs = append(kubeObjs, o)
+			err = roleBindings.Add(o)
+		case *unstructured.Unstructured:
+			dynamicObjs = append(dynamicObjs, o)
+			sb := &duckv1.Source{}
+			if err = runtime.DefaultUnstructuredConverter.FromUnstructured(o.Object, sb); err == nil {
+				err = sinkBindings.Add(sb)
+			}
+		default:
+			t.Fatalf("Unexpected object %T", obj)
+		}
+		if err != nil {
+			t.Fatal("Adding to the listers =", err)
+		}
+	}
+
+	ctx, kubeClient := fakekubeclient.With(ctx, kubeObjs...)
+	ctx, ftpClient := fakeftpclient.With(ctx, ftpObjs...)
+	ctx, dynamicClient := fakedynamicclient.With(ctx, runtime.NewScheme(), dynamicObjs...)
+	recorder := record.NewFakeRecorder(10)
+	ctx = controller.WithEventRecorder(ctx, recorder)
+	r.Ctx = ctx
+
+	for _, reactor := range r.WithReactors {
+		kubeClient.PrependReactor("*", "*", reactor)
+		ftpClient.PrependReactor("*", "*", reactor)
+		dynamicClient.PrependReactor("*", "*", reactor)
+	}
+
+	rec := &Reconciler{
+		kubeClientSet:        kubeClient,
+		dynamicClientSet:     dynamicClient,
+		deploymentLister:     appsv1listers.NewDeploymentLister(deployments),
+		configMapLister:      corev1listers.NewConfigMapLister(configMaps),
+		serviceAccountLister: corev1listers.NewServiceAccountLister(serviceAccounts),
+		roleLister:           rbacv1listers.NewRoleLister(roles),
+		roleBindingLister:    rbacv1listers.NewRoleBindingLister(roleBindings),
+		sinkBindingLister:    cache.NewGenericLister(sinkBindings, resources.SinkBindingGVR.GroupResource()),
+		receiveAdapterImage:  testImage,
+		observability:        &observability{},
+	}
+	c := ftpsourcereconciler.NewReconciler(ctx, logging.FromContext(ctx), ftpClient,
+		listers.NewFTPSourceLister(sources), recorder, rec)
+
+	// The reconciler only acts once it's the leader.
+	if la, ok := c.(pkgreconciler.LeaderAware); ok {
+		la.Promote(pkgreconciler.UniversalBucket(), func(pkgreconciler.Bucket, types.NamespacedName) {})
+	}
+
+	return c, ActionRecorderList{kubeClient, dynamicClient, ftpClient}, EventList{Recorder: recorder}
+}
@@ -0,0 +1,73 @@
+package ftpsource
+
+import (
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/metrics"
+	tracingconfig "knative.dev/pkg/tracing/config"
+)
+
+// Metrics of the receive adapters are reported under this domain and
+// component, like the ones of the knative sources.
+const (
+	metricsDomain    = "knative.dev/sources"
+	metricsComponent = "ftpsource"
+)
+
+// observability hands the observability configuration of the controller on
+// to the receive adapters, which can't read the ConfigMaps of the controller
+// namespace themselves.
+type observability struct {
+	mu sync.RWMutex
+	// The metrics exporter options, as the JSON of K_METRICS_CONFIG.
+	metricsConfig string
+	// The tracing configuration, as the JSON of K_TRACING_CONFIG.
+	tracingConfig string
+}
+
+// updateMetrics records the config-observability ConfigMap.
+func (o *observability) updateMetrics(cm *corev1.ConfigMap) error {
+	config, err := metrics.OptionsToJSON(&metrics.ExporterOptions{
+		Domain:    metricsDomain,
+		Component: metricsComponent,
+		ConfigMap: cm.Data,
+	})
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.metricsConfig = config
+	return nil
+}
+
+// metricsJSON returns the metrics exporter options of the receive adapters.
+func (o *observability) metricsJSON() string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.metricsConfig
+}
+
+// updateTracing records the config-tracing ConfigMap.
+func (o *observability) updateTracing(cm *corev1.ConfigMap) error {
+	cfg, err := tracingconfig.NewTracingConfigFromConfigMap(cm)
+	if err != nil {
+		return err
+	}
+	config, err := tracingconfig.TracingConfigToJSON(cfg)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.tracingConfig = config
+	return nil
+}
+
+// tracingJSON returns the tracing configuration of the receive adapters.
+func (o *observability) tracingJSON() string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.tracingConfig
+}
@@ -0,0 +1,23 @@
+package resources
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/kmeta"
+
+	"github.com/vaikas/ftp/pkg/apis/sources/v1alpha1"
+)
+
+// MakeStateConfigMap creates the ConfigMap the receive adapter stores its
+// state in. The data is owned by the receive adapter, so the ConfigMap is
+// created empty.
+func MakeStateConfigMap(source *v1alpha1.FTPSource) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       source.Namespace,
+			Name:            StoreName(source),
+			Labels:          Labels(source.Name),
+			OwnerReferences: []metav1.OwnerReference{*kmeta.NewControllerRef(source)},
+		},
+	}
+}
@@ -0,0 +1,16 @@
+package resources
+
+const (
+	// controllerAgentName is the string used by this controller to identify
+	// itself when creating events.
+	controllerAgentName = "ftp-source-controller"
+)
+
+// Labels returns the labels put on every resource rendered for the given
+// FTPSource.
+func Labels(name string) map[string]string {
+	return map[string]string{
+		"sources.knative.dev/source": controllerAgentName,
+		"sources.knative.dev/name":   name,
+	}
+}
@@ -0,0 +1,19 @@
+package resources
+
+import (
+	"knative.dev/pkg/kmeta"
+
+	"github.com/vaikas/ftp/pkg/apis/sources/v1alpha1"
+)
+
+// DeploymentName returns the name of the receive adapter Deployment. The
+// ServiceAccount, Role, RoleBinding and SinkBinding share the same name.
+func DeploymentName(source *v1alpha1.FTPSource) string {
+	return kmeta.ChildName(source.Name, "-ftpsource")
+}
+
+// StoreName returns the name of the ConfigMap the receive adapter keeps its
+// state in.
+func StoreName(source *v1alpha1.FTPSource) string {
+	return kmeta.ChildName(source.Name, "-ftpsource-store")
+}
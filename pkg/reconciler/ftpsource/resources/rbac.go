@@ -0,0 +1,69 @@
+package resources
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/kmeta"
+
+	"github.com/vaikas/ftp/pkg/apis/sources/v1alpha1"
+)
+
+// MakeServiceAccount creates the ServiceAccount the receive adapter runs as.
+func MakeServiceAccount(source *v1alpha1.FTPSource) *corev1.ServiceAccount {
+	return &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       source.Namespace,
+			Name:            DeploymentName(source),
+			Labels:          Labels(source.Name),
+			OwnerReferences: []metav1.OwnerReference{*kmeta.NewControllerRef(source)},
+		},
+	}
+}
+
+// MakeRole creates the Role granting the receive adapter access to the
+// ConfigMap it stores its state in, and the Leases its replicas elect their
+// leader and share out the shards with.
+func MakeRole(source *v1alpha1.FTPSource) *rbacv1.Role {
+	return &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       source.Namespace,
+			Name:            DeploymentName(source),
+			Labels:          Labels(source.Name),
+			OwnerReferences: []metav1.OwnerReference{*kmeta.NewControllerRef(source)},
+		},
+		Rules: []rbacv1.PolicyRule{{
+			APIGroups: []string{""},
+			Resources: []string{"configmaps"},
+			Verbs:     []string{"get", "watch", "list", "create", "update"},
+		}, {
+			APIGroups: []string{"coordination.k8s.io"},
+			Resources: []string{"leases"},
+			Verbs:     []string{"get", "watch", "list", "create", "update", "delete"},
+		}},
+	}
+}
+
+// MakeRoleBinding binds the Role from MakeRole to the ServiceAccount from
+// MakeServiceAccount.
+func MakeRoleBinding(source *v1alpha1.FTPSource) *rbacv1.RoleBinding {
+	name := DeploymentName(source)
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       source.Namespace,
+			Name:            name,
+			Labels:          Labels(source.Name),
+			OwnerReferences: []metav1.OwnerReference{*kmeta.NewControllerRef(source)},
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Namespace: source.Namespace,
+			Name:      name,
+		}},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     name,
+		},
+	}
+}
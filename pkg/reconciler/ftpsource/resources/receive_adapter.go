@@ -0,0 +1,465 @@
+package resources
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/rickb777/date/period"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/kmeta"
+
+	"github.com/vaikas/ftp/pkg/apis/sources/v1alpha1"
+)
+
+const (
+	defaultSFTPPort         = 22
+	defaultFTPPort          = 21
+	defaultFTPSImplicitPort = 990
+
+	defaultInterval = 10 * time.Second
+
+	knownHostsVolume = "known-hosts"
+	knownHostsDir    = "/etc/ftpsource/known-hosts"
+	knownHostsFile   = "known_hosts"
+
+	tlsVolume = "tls"
+	tlsDir    = "/etc/ftpsource/tls"
+
+	stateVolume = "state"
+	stateDir    = "/var/lib/ftpsource"
+
+	claimCheckVolume = "claim-check"
+	claimCheckDir    = "/var/lib/ftpsource-claim-check"
+
+	// Port the receive adapter serves its metrics on for Prometheus to
+	// scrape, the default of the knative metrics exporter.
+	metricsPort = 9090
+)
+
+// ReceiveAdapterArgs are the arguments needed to create an FTPSource
+// receive adapter. Every field but DeadLetterSink is required.
+type ReceiveAdapterArgs struct {
+	Image  string
+	Source *v1alpha1.FTPSource
+	Labels map[string]string
+	// DeadLetterSink is the resolved URI of the dead letter sink.
+	DeadLetterSink *apis.URL
+	// MetricsConfig is the JSON of the metrics exporter options of the
+	// receive adapter. The adapter reports to Prometheus if it's empty.
+	MetricsConfig string
+	// TracingConfig is the JSON of the tracing configuration of the receive
+	// adapter. The adapter doesn't export spans if it's empty.
+	TracingConfig string
+}
+
+// MakeReceiveAdapter generates (but does not insert into K8s) the receive
+// adapter Deployment for FTPSources.
+func MakeReceiveAdapter(args *ReceiveAdapterArgs) *appsv1.Deployment {
+	replicas := int32(1)
+	if r := args.Source.Spec.Replicas; r != nil && *r > 1 && args.Source.Spec.State.Backend != v1alpha1.StateBackendFile {
+		replicas = *r
+	}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       args.Source.Namespace,
+			Name:            DeploymentName(args.Source),
+			Labels:          args.Labels,
+			OwnerReferences: []metav1.OwnerReference{*kmeta.NewControllerRef(args.Source)},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: args.Labels,
+			},
+			Replicas: &replicas,
+			Strategy: makeStrategy(args.Source),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: args.Labels,
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: DeploymentName(args.Source),
+					Containers: []corev1.Container{{
+						Name:  "receive-adapter",
+						Image: args.Image,
+						Args:  makeArgs(args),
+						Env:   makeEnv(args),
+						Ports: []corev1.ContainerPort{{
+							Name:          "metrics",
+							ContainerPort: metricsPort,
+						}},
+
+						VolumeMounts: makeVolumeMounts(args.Source),
+					}},
+					Volumes: makeVolumes(args.Source),
+				},
+			},
+		},
+	}
+}
+
+func makeArgs(ra *ReceiveAdapterArgs) []string {
+	source := ra.Source
+	protocol := source.Spec.Protocol
+	if protocol == "" {
+		protocol = v1alpha1.ProtocolSFTP
+	}
+	port := int(source.Spec.Port)
+	if port == 0 {
+		switch protocol {
+		case v1alpha1.ProtocolSFTP:
+			port = defaultSFTPPort
+		case v1alpha1.ProtocolFTPSImplicit:
+			port = defaultFTPSImplicitPort
+		default:
+			port = defaultFTPPort
+		}
+	}
+	dir := source.Spec.Directory
+	if dir == "" {
+		dir = "."
+	}
+	interval := defaultInterval
+	if source.Spec.Interval != nil && source.Spec.Interval.Duration >= time.Second {
+		interval = source.Spec.Interval.Duration
+	}
+
+	args := []string{
+		"--sftpServer=" + net.JoinHostPort(source.Spec.Server, strconv.Itoa(port)),
+		"--protocol=" + protocol,
+		"--dir=" + dir,
+		"--storename=" + StoreName(source),
+		// Even with a single replica, the Pods of a rollout overlap.
+		"--leaderElection=true",
+		"--leaseName=" + DeploymentName(source),
+		fmt.Sprintf("--probeFrequency=%d", int(interval.Seconds())),
+	}
+	if source.Spec.Recursive {
+		args = append(args, "--recursive=true", fmt.Sprintf("--maxDepth=%d", source.Spec.MaxDepth))
+		if source.Spec.Shards > 1 {
+			args = append(args, fmt.Sprintf("--shards=%d", source.Spec.Shards))
+		}
+	}
+	filter := source.Spec.Filter
+	for _, p := range filter.Include {
+		args = append(args, "--include="+p)
+	}
+	for _, p := range filter.Exclude {
+		args = append(args, "--exclude="+p)
+	}
+	for _, p := range filter.IncludeRegex {
+		args = append(args, "--includeRegex="+p)
+	}
+	for _, p := range filter.ExcludeRegex {
+		args = append(args, "--excludeRegex="+p)
+	}
+	stability := source.Spec.Stability
+	if stability.Probes > 1 {
+		args = append(args, fmt.Sprintf("--stableProbes=%d", stability.Probes))
+	}
+	if stability.QuietPeriod != nil && stability.QuietPeriod.Duration > 0 {
+		args = append(args, "--quietPeriod="+stability.QuietPeriod.Duration.String())
+	}
+	for _, s := range stability.MarkerSuffixes {
+		args = append(args, "--markerSuffix="+s)
+	}
+	for _, m := range source.Spec.Credentials.AuthMethods {
+		args = append(args, "--authMethod="+m)
+	}
+	args = append(args, makeStateArgs(source.Spec.State)...)
+	if pd := source.Spec.PostDelivery; pd.Action != "" && pd.Action != v1alpha1.PostDeliveryNone {
+		args = append(args, "--postAction="+pd.Action)
+		if pd.ArchiveDirectory != "" {
+			args = append(args, "--archiveDir="+pd.ArchiveDirectory)
+		}
+		if pd.RenameSuffix != "" {
+			args = append(args, "--renameSuffix="+pd.RenameSuffix)
+		}
+	}
+	events := source.Spec.Events
+	if events.TypePrefix != "" {
+		args = append(args, "--typePrefix="+events.TypePrefix)
+	}
+	if events.SourceTemplate != "" {
+		args = append(args, "--sourceTemplate="+events.SourceTemplate)
+	}
+	if cs := source.Spec.Checksum; cs.Algorithm != "" {
+		args = append(args, "--checksum="+cs.Algorithm)
+		for _, v := range cs.Verify {
+			args = append(args, "--verifyChecksum="+v)
+		}
+	}
+	if ic := source.Spec.InlineContent; ic.MaxSize > 0 {
+		args = append(args, fmt.Sprintf("--inlineMaxSize=%d", ic.MaxSize))
+		if ic.Encoding != "" {
+			args = append(args, "--inlineEncoding="+ic.Encoding)
+		}
+	}
+	args = append(args, makeClaimCheckArgs(source.Spec.ClaimCheck)...)
+	args = append(args, makeDeliveryArgs(source.Spec.Delivery)...)
+	if ra.DeadLetterSink != nil {
+		args = append(args, "--deadLetterSink="+ra.DeadLetterSink.String())
+	}
+	switch protocol {
+	case v1alpha1.ProtocolSFTP:
+		args = append(args, makeHostKeyArgs(source.Spec.HostKey)...)
+	case v1alpha1.ProtocolFTPSExplicit, v1alpha1.ProtocolFTPSImplicit:
+		if source.Spec.TLS.SecretRef != nil {
+			args = append(args, "--tlsDir="+tlsDir)
+		}
+		if source.Spec.TLS.InsecureSkipVerify {
+			args = append(args, "--tlsInsecureSkipVerify=true")
+		}
+	}
+	return args
+}
+
+func makeEnv(ra *ReceiveAdapterArgs) []corev1.EnvVar {
+	source := ra.Source
+	env := []corev1.EnvVar{{
+		Name: "SYSTEM_NAMESPACE",
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{
+				FieldPath: "metadata.namespace",
+			},
+		},
+	}, {
+		Name:  "NAMESPACE",
+		Value: source.Namespace,
+	}, {
+		Name:  "NAME",
+		Value: source.Name,
+	}}
+
+	if ra.MetricsConfig != "" {
+		env = append(env, corev1.EnvVar{Name: "K_METRICS_CONFIG", Value: ra.MetricsConfig})
+	}
+	if ra.TracingConfig != "" {
+		env = append(env, corev1.EnvVar{Name: "K_TRACING_CONFIG", Value: ra.TracingConfig})
+	}
+
+	if ref := source.Spec.Credentials.SecretRef; ref != nil {
+		env = append(env,
+			secretEnv("FTP_USER", ref, "user", false),
+			secretEnv("FTP_PASSWORD", ref, "password", true),
+			secretEnv("FTP_PRIVATE_KEY", ref, "privateKey", true),
+			secretEnv("FTP_PRIVATE_KEY_PASSPHRASE", ref, "privateKeyPassphrase", true),
+			secretEnv("FTP_CERTIFICATE", ref, "certificate", true),
+		)
+	}
+	if redis := source.Spec.State.Redis; source.Spec.State.Backend == v1alpha1.StateBackendRedis && redis != nil && redis.PasswordSecretRef != nil {
+		ref := redis.PasswordSecretRef
+		env = append(env, secretEnv("REDIS_PASSWORD", &ref.LocalObjectReference, ref.Key, false))
+	}
+	if s3 := source.Spec.ClaimCheck.S3; source.Spec.ClaimCheck.Backend == v1alpha1.ClaimCheckBackendS3 && s3 != nil {
+		if ref := s3.AccessKeyID; ref != nil {
+			env = append(env, secretEnv("AWS_ACCESS_KEY_ID", &ref.LocalObjectReference, ref.Key, false))
+		}
+		if ref := s3.SecretAccessKey; ref != nil {
+			env = append(env, secretEnv("AWS_SECRET_ACCESS_KEY", &ref.LocalObjectReference, ref.Key, false))
+		}
+	}
+	return env
+}
+
+func secretEnv(name string, ref *corev1.LocalObjectReference, key string, optional bool) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: *ref,
+				Key:                  key,
+				Optional:             &optional,
+			},
+		},
+	}
+}
+
+// makeDeliveryArgs returns the arguments configuring how the receive adapter
+// retries events. Invalid values are left at the receive adapter defaults.
+func makeDeliveryArgs(delivery *eventingduckv1.DeliverySpec) []string {
+	if delivery == nil {
+		return nil
+	}
+	var args []string
+	if delivery.Retry != nil && *delivery.Retry >= 0 {
+		args = append(args, fmt.Sprintf("--maxAttempts=%d", *delivery.Retry+1))
+	}
+	if delivery.BackoffPolicy != nil && *delivery.BackoffPolicy == eventingduckv1.BackoffPolicyLinear {
+		args = append(args, "--backoffPolicy=linear")
+	}
+	if delivery.BackoffDelay != nil {
+		if p, err := period.Parse(*delivery.BackoffDelay); err == nil {
+			if d, _ := p.Duration(); d > 0 {
+				args = append(args, "--retryBackoff="+d.String())
+			}
+		}
+	}
+	return args
+}
+
+func makeStateArgs(state v1alpha1.FTPState) []string {
+	switch state.Backend {
+	case v1alpha1.StateBackendFile:
+		return []string{
+			"--stateStore=file",
+			"--stateFile=" + path.Join(stateDir, "state.db"),
+		}
+	case v1alpha1.StateBackendRedis:
+		var redis v1alpha1.FTPStateRedis
+		if state.Redis != nil {
+			redis = *state.Redis
+		}
+		return []string{
+			"--stateStore=redis",
+			"--redisAddress=" + redis.Address,
+			fmt.Sprintf("--redisDB=%d", redis.DB),
+		}
+	}
+	return nil
+}
+
+func makeClaimCheckArgs(cc v1alpha1.FTPClaimCheck) []string {
+	var args []string
+	switch {
+	case cc.Backend == v1alpha1.ClaimCheckBackendS3 && cc.S3 != nil:
+		args = append(args, "--claimCheck=s3", "--s3Bucket="+cc.S3.Bucket)
+		if cc.S3.Endpoint != "" {
+			args = append(args, "--s3Endpoint="+cc.S3.Endpoint)
+		}
+		if cc.S3.Region != "" {
+			args = append(args, "--s3Region="+cc.S3.Region)
+		}
+		if cc.S3.ForcePathStyle {
+			args = append(args, "--s3PathStyle=true")
+		}
+	case cc.Backend == v1alpha1.ClaimCheckBackendVolume && cc.Volume != nil:
+		args = append(args, "--claimCheck=volume", "--claimCheckDir="+claimCheckDir)
+	default:
+		return nil
+	}
+	if cc.MinSize > 0 {
+		args = append(args, fmt.Sprintf("--claimCheckMinSize=%d", cc.MinSize))
+	}
+	if cc.Prefix != "" {
+		args = append(args, "--claimCheckPrefix="+cc.Prefix)
+	}
+	return args
+}
+
+// makeStrategy makes sure the old receive adapter Pod is gone before a new
+// one is started when the state is kept in a file, since the file is locked
+// by the Pod using it, and volumes usually can't be mounted by two Pods.
+func makeStrategy(source *v1alpha1.FTPSource) appsv1.DeploymentStrategy {
+	if source.Spec.State.Backend == v1alpha1.StateBackendFile {
+		return appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+	}
+	return appsv1.DeploymentStrategy{}
+}
+
+func makeHostKeyArgs(hostKey v1alpha1.FTPHostKey) []string {
+	var args []string
+	if hasKnownHosts(hostKey) {
+		args = append(args, "--knownHosts="+path.Join(knownHostsDir, knownHostsFile))
+	}
+	for _, fp := range hostKey.Fingerprints {
+		args = append(args, "--hostKeyFingerprint="+fp)
+	}
+	if hostKey.TrustOnFirstUse || (!hasKnownHosts(hostKey) && len(hostKey.Fingerprints) == 0) {
+		args = append(args, "--trustOnFirstUse=true")
+	}
+	if hostKey.InsecureIgnoreHostKey {
+		args = append(args, "--insecureIgnoreHostKey=true")
+	}
+	return args
+}
+
+func hasKnownHosts(hostKey v1alpha1.FTPHostKey) bool {
+	kh := hostKey.KnownHosts
+	return kh != nil && (kh.SecretKeyRef != nil || kh.ConfigMapKeyRef != nil)
+}
+
+// makeVolumes returns the volumes holding the files the receive adapter
+// needs, which are mounted by makeVolumeMounts.
+func makeVolumes(source *v1alpha1.FTPSource) []corev1.Volume {
+	var volumes []corev1.Volume
+	if hasKnownHosts(source.Spec.HostKey) {
+		kh := source.Spec.HostKey.KnownHosts
+		v := corev1.Volume{Name: knownHostsVolume}
+		switch {
+		case kh.SecretKeyRef != nil:
+			v.Secret = &corev1.SecretVolumeSource{
+				SecretName: kh.SecretKeyRef.Name,
+				Items:      []corev1.KeyToPath{{Key: kh.SecretKeyRef.Key, Path: knownHostsFile}},
+			}
+		default:
+			v.ConfigMap = &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: kh.ConfigMapKeyRef.LocalObjectReference,
+				Items:                []corev1.KeyToPath{{Key: kh.ConfigMapKeyRef.Key, Path: knownHostsFile}},
+			}
+		}
+		volumes = append(volumes, v)
+	}
+	if state := source.Spec.State; state.Backend == v1alpha1.StateBackendFile && state.File != nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: stateVolume,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: state.File.ClaimName},
+			},
+		})
+	}
+	if cc := source.Spec.ClaimCheck; cc.Backend == v1alpha1.ClaimCheckBackendVolume && cc.Volume != nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: claimCheckVolume,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: cc.Volume.ClaimName},
+			},
+		})
+	}
+	if ref := source.Spec.TLS.SecretRef; ref != nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: tlsVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: ref.Name},
+			},
+		})
+	}
+	return volumes
+}
+
+func makeVolumeMounts(source *v1alpha1.FTPSource) []corev1.VolumeMount {
+	var mounts []corev1.VolumeMount
+	if hasKnownHosts(source.Spec.HostKey) {
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      knownHostsVolume,
+			MountPath: knownHostsDir,
+			ReadOnly:  true,
+		})
+	}
+	if state := source.Spec.State; state.Backend == v1alpha1.StateBackendFile && state.File != nil {
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      stateVolume,
+			MountPath: stateDir,
+		})
+	}
+	if cc := source.Spec.ClaimCheck; cc.Backend == v1alpha1.ClaimCheckBackendVolume && cc.Volume != nil {
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      claimCheckVolume,
+			MountPath: claimCheckDir,
+		})
+	}
+	if source.Spec.TLS.SecretRef != nil {
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      tlsVolume,
+			MountPath: tlsDir,
+			ReadOnly:  true,
+		})
+	}
+	return mounts
+}
@@ -0,0 +1,286 @@
+package resources
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	eventingduckv1 "knative.dev/eventing/pkg/apis/duck/v1"
+	"knative.dev/pkg/apis"
+
+	"github.com/vaikas/ftp/pkg/apis/sources/v1alpha1"
+)
+
+func newSource(spec v1alpha1.FTPSourceSpec) *v1alpha1.FTPSource {
+	spec.Server = "ftp.example.com"
+	return &v1alpha1.FTPSource{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "src", UID: "uid"},
+		Spec:       spec,
+	}
+}
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func TestMakeReceiveAdapterArgs(t *testing.T) {
+	retry := int32(2)
+	linear := eventingduckv1.BackoffPolicyLinear
+	delay := "PT1.5S"
+	common := []string{
+		"--storename=src-ftpsource-store",
+		"--leaderElection=true",
+		"--leaseName=src-ftpsource",
+	}
+	tests := []struct {
+		name           string
+		spec           v1alpha1.FTPSourceSpec
+		deadLetterSink *apis.URL
+		want           []string
+	}{{
+		name: "defaults",
+		want: append(append([]string{"--sftpServer=ftp.example.com:22", "--protocol=sftp", "--dir=."}, common...),
+			"--probeFrequency=10", "--trustOnFirstUse=true"),
+	}, {
+		name: "ftp",
+		spec: v1alpha1.FTPSourceSpec{
+			Protocol:  v1alpha1.ProtocolFTP,
+			Directory: "in",
+			Interval:  &metav1.Duration{Duration: time.Minute},
+		},
+		want: append(append([]string{"--sftpServer=ftp.example.com:21", "--protocol=ftp", "--dir=in"}, common...),
+			"--probeFrequency=60"),
+	}, {
+		name: "implicit ftps",
+		spec: v1alpha1.FTPSourceSpec{
+			Protocol: v1alpha1.ProtocolFTPSImplicit,
+			TLS: v1alpha1.FTPTLS{
+				SecretRef:          &corev1.LocalObjectReference{Name: "tls"},
+				InsecureSkipVerify: true,
+			},
+		},
+		want: append(append([]string{"--sftpServer=ftp.example.com:990", "--protocol=ftps-implicit", "--dir=."}, common...),
+			"--probeFrequency=10", "--tlsDir=/etc/ftpsource/tls", "--tlsInsecureSkipVerify=true"),
+	}, {
+		name: "host keys",
+		spec: v1alpha1.FTPSourceSpec{
+			Port: 2222,
+			HostKey: v1alpha1.FTPHostKey{
+				KnownHosts: &v1alpha1.FTPKnownHosts{
+					SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "hosts"}, Key: "known_hosts"},
+				},
+				Fingerprints: []string{"SHA256:abc"},
+			},
+		},
+		want: append(append([]string{"--sftpServer=ftp.example.com:2222", "--protocol=sftp", "--dir=."}, common...),
+			"--probeFrequency=10", "--knownHosts=/etc/ftpsource/known-hosts/known_hosts", "--hostKeyFingerprint=SHA256:abc"),
+	}, {
+		name: "walking and delivery",
+		spec: v1alpha1.FTPSourceSpec{
+			Recursive: true,
+			MaxDepth:  3,
+			Shards:    4,
+			Filter:    v1alpha1.FTPFilter{Include: []string{"*.csv"}, ExcludeRegex: []string{"^tmp"}},
+			Stability: v1alpha1.FTPStability{
+				Probes:         2,
+				QuietPeriod:    &metav1.Duration{Duration: 30 * time.Second},
+				MarkerSuffixes: []string{".done"},
+			},
+			Credentials:  v1alpha1.FTPCredentials{AuthMethods: []string{"publickey"}},
+			State:        v1alpha1.FTPState{Backend: v1alpha1.StateBackendRedis, Redis: &v1alpha1.FTPStateRedis{Address: "redis:6379", DB: 1}},
+			PostDelivery: v1alpha1.FTPPostDelivery{Action: v1alpha1.PostDeliveryMove, ArchiveDirectory: "done"},
+			Events:       v1alpha1.FTPEvents{TypePrefix: "com.example", SourceTemplate: "ftp://{{.Server}}"},
+			Checksum:     v1alpha1.FTPChecksum{Algorithm: "sha256", Verify: []string{"sha256-file"}},
+			InlineContent: v1alpha1.FTPInlineContent{
+				MaxSize:  1024,
+				Encoding: "base64",
+			},
+			ClaimCheck: v1alpha1.FTPClaimCheck{
+				Backend: v1alpha1.ClaimCheckBackendS3,
+				MinSize: 2048,
+				S3:      &v1alpha1.FTPClaimCheckS3{Bucket: "files", Region: "eu-west-1", ForcePathStyle: true},
+			},
+			Delivery: &eventingduckv1.DeliverySpec{Retry: &retry, BackoffPolicy: &linear, BackoffDelay: &delay},
+		},
+		deadLetterSink: apis.HTTP("dls.ns.svc.cluster.local"),
+		want: append(append([]string{"--sftpServer=ftp.example.com:22", "--protocol=sftp", "--dir=."}, common...),
+			"--probeFrequency=10",
+			"--recursive=true", "--maxDepth=3", "--shards=4",
+			"--include=*.csv", "--excludeRegex=^tmp",
+			"--stableProbes=2", "--quietPeriod=30s", "--markerSuffix=.done",
+			"--authMethod=publickey",
+			"--stateStore=redis", "--redisAddress=redis:6379", "--redisDB=1",
+			"--postAction=move", "--archiveDir=done",
+			"--typePrefix=com.example", "--sourceTemplate=ftp://{{.Server}}",
+			"--checksum=sha256", "--verifyChecksum=sha256-file",
+			"--inlineMaxSize=1024", "--inlineEncoding=base64",
+			"--claimCheck=s3", "--s3Bucket=files", "--s3Region=eu-west-1", "--s3PathStyle=true", "--claimCheckMinSize=2048",
+			"--maxAttempts=3", "--backoffPolicy=linear", "--retryBackoff=1.5s",
+			"--deadLetterSink=http://dls.ns.svc.cluster.local",
+			"--trustOnFirstUse=true"),
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ra := MakeReceiveAdapter(&ReceiveAdapterArgs{
+				Image:          "image",
+				Source:         newSource(tc.spec),
+				Labels:         Labels("src"),
+				DeadLetterSink: tc.deadLetterSink,
+			})
+			if diff := cmp.Diff(tc.want, ra.Spec.Template.Spec.Containers[0].Args); diff != "" {
+				t.Error("Args (-want, +got):", diff)
+			}
+		})
+	}
+}
+
+func TestMakeReceiveAdapterEnv(t *testing.T) {
+	secretEnv := func(name, secret, key string, optional bool) corev1.EnvVar {
+		return corev1.EnvVar{
+			Name: name,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secret},
+					Key:                  key,
+					Optional:             &optional,
+				},
+			},
+		}
+	}
+	key := func(secret, key string) *corev1.SecretKeySelector {
+		return &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: secret}, Key: key}
+	}
+
+	ra := MakeReceiveAdapter(&ReceiveAdapterArgs{
+		Image: "image",
+		Source: newSource(v1alpha1.FTPSourceSpec{
+			Credentials: v1alpha1.FTPCredentials{SecretRef: &corev1.LocalObjectReference{Name: "creds"}},
+			State: v1alpha1.FTPState{
+				Backend: v1alpha1.StateBackendRedis,
+				Redis:   &v1alpha1.FTPStateRedis{Address: "redis:6379", PasswordSecretRef: key("redis", "pw")},
+			},
+			ClaimCheck: v1alpha1.FTPClaimCheck{
+				Backend: v1alpha1.ClaimCheckBackendS3,
+				S3:      &v1alpha1.FTPClaimCheckS3{Bucket: "files", AccessKeyID: key("aws", "id"), SecretAccessKey: key("aws", "secret")},
+			},
+		}),
+		Labels:        Labels("src"),
+		MetricsConfig: `{"Domain":"knative.dev/sources"}`,
+		TracingConfig: `{"backend":"zipkin"}`,
+	})
+
+	want := []corev1.EnvVar{{
+		Name:      "SYSTEM_NAMESPACE",
+		ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
+	}, {
+		Name: "NAMESPACE", Value: "ns",
+	}, {
+		Name: "NAME", Value: "src",
+	}, {
+		Name: "K_METRICS_CONFIG", Value: `{"Domain":"knative.dev/sources"}`,
+	}, {
+		Name: "K_TRACING_CONFIG", Value: `{"backend":"zipkin"}`,
+	},
+		secretEnv("FTP_USER", "creds", "user", false),
+		secretEnv("FTP_PASSWORD", "creds", "password", true),
+		secretEnv("FTP_PRIVATE_KEY", "creds", "privateKey", true),
+		secretEnv("FTP_PRIVATE_KEY_PASSPHRASE", "creds", "privateKeyPassphrase", true),
+		secretEnv("FTP_CERTIFICATE", "creds", "certificate", true),
+		secretEnv("REDIS_PASSWORD", "redis", "pw", false),
+		secretEnv("AWS_ACCESS_KEY_ID", "aws", "id", false),
+		secretEnv("AWS_SECRET_ACCESS_KEY", "aws", "secret", false),
+	}
+	if diff := cmp.Diff(want, ra.Spec.Template.Spec.Containers[0].Env); diff != "" {
+		t.Error("Env (-want, +got):", diff)
+	}
+}
+
+func TestMakeReceiveAdapterVolumes(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         v1alpha1.FTPSourceSpec
+		wantVolumes  []corev1.Volume
+		wantMounts   []corev1.VolumeMount
+		wantReplicas int32
+		wantStrategy appsv1.DeploymentStrategyType
+	}{{
+		name:         "none",
+		spec:         v1alpha1.FTPSourceSpec{Replicas: int32Ptr(3)},
+		wantReplicas: 3,
+	}, {
+		name: "known hosts in a config map",
+		spec: v1alpha1.FTPSourceSpec{
+			HostKey: v1alpha1.FTPHostKey{KnownHosts: &v1alpha1.FTPKnownHosts{
+				ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "hosts"}, Key: "hosts"},
+			}},
+		},
+		wantVolumes: []corev1.Volume{{
+			Name: "known-hosts",
+			VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "hosts"},
+				Items:                []corev1.KeyToPath{{Key: "hosts", Path: "known_hosts"}},
+			}},
+		}},
+		wantMounts:   []corev1.VolumeMount{{Name: "known-hosts", MountPath: "/etc/ftpsource/known-hosts", ReadOnly: true}},
+		wantReplicas: 1,
+	}, {
+		name: "state file, claim check volume and tls",
+		spec: v1alpha1.FTPSourceSpec{
+			Protocol: v1alpha1.ProtocolFTPSExplicit,
+			TLS:      v1alpha1.FTPTLS{SecretRef: &corev1.LocalObjectReference{Name: "tls"}},
+			State:    v1alpha1.FTPState{Backend: v1alpha1.StateBackendFile, File: &v1alpha1.FTPStateFile{ClaimName: "state"}},
+			ClaimCheck: v1alpha1.FTPClaimCheck{
+				Backend: v1alpha1.ClaimCheckBackendVolume,
+				Volume:  &v1alpha1.FTPClaimCheckVolume{ClaimName: "blobs"},
+			},
+			// The state file can only be used by one replica.
+			Replicas: int32Ptr(3),
+		},
+		wantVolumes: []corev1.Volume{{
+			Name: "state",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "state"},
+			},
+		}, {
+			Name: "claim-check",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "blobs"},
+			},
+		}, {
+			Name: "tls",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: "tls"},
+			},
+		}},
+		wantMounts: []corev1.VolumeMount{
+			{Name: "state", MountPath: "/var/lib/ftpsource"},
+			{Name: "claim-check", MountPath: "/var/lib/ftpsource-claim-check"},
+			{Name: "tls", MountPath: "/etc/ftpsource/tls", ReadOnly: true},
+		},
+		wantReplicas: 1,
+		wantStrategy: appsv1.RecreateDeploymentStrategyType,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ra := MakeReceiveAdapter(&ReceiveAdapterArgs{
+				Image:  "image",
+				Source: newSource(tc.spec),
+				Labels: Labels("src"),
+			})
+			pod := ra.Spec.Template.Spec
+			if diff := cmp.Diff(tc.wantVolumes, pod.Volumes); diff != "" {
+				t.Error("Volumes (-want, +got):", diff)
+			}
+			if diff := cmp.Diff(tc.wantMounts, pod.Containers[0].VolumeMounts); diff != "" {
+				t.Error("VolumeMounts (-want, +got):", diff)
+			}
+			if got := *ra.Spec.Replicas; got != tc.wantReplicas {
+				t.Errorf("Replicas = %d, want %d", got, tc.wantReplicas)
+			}
+			if got := ra.Spec.Strategy.Type; got != tc.wantStrategy {
+				t.Errorf("Strategy = %q, want %q", got, tc.wantStrategy)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package resources
+
+import (
+	"encoding/json"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	duckv1 "knative.dev/pkg/apis/duck/v1"
+	"knative.dev/pkg/kmeta"
+	"knative.dev/pkg/tracker"
+
+	"github.com/vaikas/ftp/pkg/apis/sources/v1alpha1"
+)
+
+// SinkBindingGVR is the resource the SinkBinding injecting K_SINK into the
+// receive adapter is created as.
+var SinkBindingGVR = schema.GroupVersionResource{
+	Group:    "sources.knative.dev",
+	Version:  "v1",
+	Resource: "sinkbindings",
+}
+
+// sinkBindingSpec mirrors the parts of the sources.knative.dev/v1
+// SinkBinding spec we render.
+type sinkBindingSpec struct {
+	duckv1.SourceSpec `json:",inline"`
+	Subject           tracker.Reference `json:"subject"`
+}
+
+// MakeSinkBinding creates the SinkBinding binding the sink of the FTPSource
+// to the receive adapter Deployment.
+func MakeSinkBinding(source *v1alpha1.FTPSource, ra *appsv1.Deployment) (*unstructured.Unstructured, error) {
+	spec := sinkBindingSpec{
+		SourceSpec: source.Spec.SourceSpec,
+		Subject: tracker.Reference{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Namespace:  ra.Namespace,
+			Name:       ra.Name,
+		},
+	}
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	var specMap map[string]interface{}
+	if err := json.Unmarshal(b, &specMap); err != nil {
+		return nil, err
+	}
+
+	sb := &unstructured.Unstructured{Object: map[string]interface{}{"spec": specMap}}
+	sb.SetAPIVersion(SinkBindingGVR.GroupVersion().String())
+	sb.SetKind("SinkBinding")
+	sb.SetNamespace(source.Namespace)
+	sb.SetName(DeploymentName(source))
+	sb.SetLabels(Labels(source.Name))
+	sb.SetOwnerReferences([]metav1.OwnerReference{*kmeta.NewControllerRef(source)})
+	return sb, nil
+}
@@ -17,6 +17,9 @@ type envConfig struct {
 	Pass   string `envconfig:"FTP_PASSWORD" required:"true"`
 	FTPUrl string `envconfig:"FTP_URL" required:"true"`
 	Path   string `envconfig:"PATH" required:"true"`
+
+	// SHA256 fingerprints of the host keys of the server.
+	HostKeyFingerprints []string `envconfig:"HOST_KEY_FINGERPRINTS" required:"true"`
 }
 
 func main() {
@@ -32,7 +35,13 @@ func main() {
 			ssh.Password(env.Pass),
 		},
 		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			return nil
+			fp := ssh.FingerprintSHA256(key)
+			for _, want := range env.HostKeyFingerprints {
+				if fp == want {
+					return nil
+				}
+			}
+			return fmt.Errorf("unknown host key %s for %s", fp, hostname)
 		},
 	}
 